@@ -0,0 +1,88 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_AutoMerge(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0.2
+	opts.AutoMergeInterval = time.Millisecond * 50
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 5000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 等待后台 merge 执行
+	var stat *Stat
+	for i := 0; i < 100; i++ {
+		stat = db.Stat()
+		if !stat.LastAutoMergeTime.IsZero() {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	assert.False(t, stat.LastAutoMergeTime.IsZero())
+	assert.True(t, stat.LastAutoMergeReclaimed > 0)
+	assert.True(t, stat.ReclaimableSize < int64(5000*128))
+
+	// 关闭时后台协程正常退出，重启之后数据正确
+	err = db.Close()
+	assert.Nil(t, err)
+
+	opts.AutoMergeInterval = 0
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db2); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+	assert.Equal(t, 5000, len(db2.ListKeys()))
+	for i := 5000; i < 10000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+func TestDB_inAutoMergeWindow(t *testing.T) {
+
+	db := &DB{options: DefaultOptions}
+	at := func(hour int) time.Time {
+		return time.Date(2024, 1, 1, hour, 30, 0, 0, time.Local)
+	}
+
+	// 默认不限制时间
+	assert.True(t, db.inAutoMergeWindow(at(12)))
+
+	db.options.AutoMergeStartHour, db.options.AutoMergeEndHour = 2, 6
+	assert.True(t, db.inAutoMergeWindow(at(2)))
+	assert.True(t, db.inAutoMergeWindow(at(5)))
+	assert.False(t, db.inAutoMergeWindow(at(6)))
+	assert.False(t, db.inAutoMergeWindow(at(12)))
+
+	// 跨越零点
+	db.options.AutoMergeStartHour, db.options.AutoMergeEndHour = 22, 6
+	assert.True(t, db.inAutoMergeWindow(at(23)))
+	assert.True(t, db.inAutoMergeWindow(at(1)))
+	assert.False(t, db.inAutoMergeWindow(at(12)))
+}
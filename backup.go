@@ -0,0 +1,460 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupManifestName 备份目录中备份清单的文件名
+const BackupManifestName = "backup-manifest"
+
+// BackupManifest 一次备份的清单，记录恢复数据库需要的所有文件
+type BackupManifest struct {
+	CreatedAt time.Time    `json:"created_at"`
+	Files     []BackupFile `json:"files"`
+}
+
+// BackupFile 备份中的一个文件
+type BackupFile struct {
+	Name string `json:"name"` /* 文件名 */
+	Dir  string `json:"dir"`  /* 文件所在的备份目录，为空表示清单所在的目录；增量备份中没有变化的文件位于之前的备份中 */
+	Size int64  `json:"size"`
+	CRC  uint32 `json:"crc"` /* 整个文件的 crc32 校验值 */
+}
+
+// backupSource 需要备份的文件，在持有 db.mu 时打开，文件被替换之后仍然读取打开时刻的内容
+type backupSource struct {
+	name      string
+	file      *os.File
+	size      int64
+	immutable bool /* 封存的数据文件及其 hint 不会再被修改，可以硬链接，也可以在增量备份中复用 */
+}
+
+// Backup 热备份数据库到 dir，备份目录可以直接打开，也可以通过 Restore 恢复
+func (db *DB) Backup(dir string) error {
+	return db.IncrementalBackup(dir, "")
+}
+
+// IncrementalBackup 热备份数据库到 dir，只拷贝 base 备份之后新增的文件，base 为空表示全量备份
+// 先封存活跃文件，然后在不持有 db.mu 的情况下硬链接或者拷贝封存的数据文件
+// 增量备份复用的文件记录在备份清单中，需要通过 Restore 恢复
+func (db *DB) IncrementalBackup(dir, base string) error {
+
+	// 备份需要封存活跃文件，只读模式下不能修改数据文件
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// 之前备份中的文件
+	baseFiles := make(map[string]BackupFile)
+	if base != "" {
+		base, err := filepath.Abs(base)
+		if err != nil {
+			return err
+		}
+		manifest, err := ReadBackupManifest(filepath.Join(base, BackupManifestName))
+		if err != nil {
+			return err
+		}
+		for _, file := range manifest.Files {
+			if file.Dir == "" {
+				file.Dir = base
+			}
+			baseFiles[file.Name] = file
+		}
+	}
+
+	// 备份目录必须为空
+	if err := checkEmptyDir(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	sources, fids, snapshot, seqNo, err := db.prepareBackup()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, source := range sources {
+			_ = source.file.Close()
+		}
+		db.unpinFiles(fids)
+	}()
+
+	// 最后一个数据文件在打开备份目录之后会继续写入，只能拷贝
+	var lastDataFile string
+	for _, source := range sources {
+		if strings.HasSuffix(source.name, data.DataFileNameSuffix) {
+			lastDataFile = source.name
+		}
+	}
+
+	manifest := &BackupManifest{CreatedAt: time.Now()}
+	for _, source := range sources {
+		if file, ok := baseFiles[source.name]; ok && source.immutable && file.Size == source.size {
+			manifest.Files = append(manifest.Files, file)
+			continue
+		}
+		canLink := source.immutable && source.name != lastDataFile
+		file, err := backupFile(source, dir, canLink)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	// B+ 树索引不从数据文件中加载，需要备份索引以及事务序列号
+	if snapshot != nil {
+		if err := db.backupBPTreeIndex(dir, snapshot, seqNo); err != nil {
+			return err
+		}
+		files, err := checksumNewFiles(dir, manifest)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, files...)
+	}
+
+	return writeBackupManifest(dir, manifest)
+}
+
+// prepareBackup 封存活跃文件，引用并打开需要备份的文件
+// B+ 树索引同时返回索引的快照和事务序列号，保证与数据文件一致
+func (db *DB) prepareBackup() ([]*backupSource, []uint32, index.Reader, uint64, error) {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 封存活跃文件，之后的写入进入新的活跃文件
+	if db.activeFile != nil && db.activeFile.WriteOff > 0 {
+		if err := db.activeFile.Sync(); err != nil {
+			return nil, nil, nil, 0, err
+		}
+		db.writeFileHint()
+		db.olderFiles[db.activeFile.FileId] = db.activeFile
+		if err := db.setActiveDataFile(); err != nil {
+			return nil, nil, nil, 0, err
+		}
+	}
+
+	// 封存活跃的 blob 文件，封存的 blob 文件不会再改变
+	if db.activeBlobFile != nil && db.activeBlobFile.WriteOff > 0 {
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, nil, nil, 0, err
+		}
+	}
+
+	var names []string
+	immutable := make(map[string]bool)
+	for fid := range db.olderFiles {
+		dataName := filepath.Base(data.GetDataFileName(db.options.DirPath, fid))
+		hintName := filepath.Base(data.GetFileHintName(db.options.DirPath, fid))
+		names = append(names, dataName, hintName)
+		immutable[dataName], immutable[hintName] = true, true
+	}
+	for fid := range db.blobFiles {
+		if db.activeBlobFile != nil && fid == db.activeBlobFile.FileId {
+			continue
+		}
+		blobName := filepath.Base(data.GetBlobFileName(db.options.DirPath, fid))
+		names = append(names, blobName)
+		immutable[blobName] = true
+	}
+	if db.options.IndexType != BPTree {
+		names = append(names, data.HintFileName, data.MergeFinishedFileName, data.NamespaceFileName)
+	}
+	names = append(names, data.ComparatorFileName)
+
+	var sources []*backupSource
+	closeSources := func() {
+		for _, source := range sources {
+			_ = source.file.Close()
+		}
+	}
+	for _, name := range names {
+		file, err := os.Open(filepath.Join(db.options.DirPath, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			closeSources()
+			return nil, nil, nil, 0, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			closeSources()
+			return nil, nil, nil, 0, err
+		}
+		sources = append(sources, &backupSource{
+			name:      name,
+			file:      file,
+			size:      info.Size(),
+			immutable: immutable[name],
+		})
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return backupFileOrder(sources[i].name) < backupFileOrder(sources[j].name)
+	})
+
+	var snapshot index.Reader
+	if db.options.IndexType == BPTree {
+		var err error
+		if snapshot, err = db.index.Snapshot(); err != nil {
+			closeSources()
+			return nil, nil, nil, 0, err
+		}
+	}
+
+	return sources, db.pinFiles(), snapshot, db.seqNo, nil
+}
+
+// backupFileOrder 备份文件的排序，数据文件按照文件 id 排序
+func backupFileOrder(name string) string {
+	if strings.HasSuffix(name, data.DataFileNameSuffix) || strings.HasSuffix(name, data.FileHintNameSuffix) ||
+		strings.HasSuffix(name, data.BlobFileNameSuffix) {
+		ext := filepath.Ext(name)
+		return strings.Repeat("0", 10-len(name)+len(ext)) + name
+	}
+	return name
+}
+
+// backupFile 将文件硬链接或者拷贝到备份目录，并计算校验值
+func backupFile(source *backupSource, dir string, canLink bool) (BackupFile, error) {
+
+	file := BackupFile{Name: source.name, Size: source.size}
+	dest := filepath.Join(dir, source.name)
+	hash := crc32.NewIEEE()
+
+	// 只读取打开时刻的长度，之后追加的数据不属于本次备份
+	reader := io.NewSectionReader(source.file, 0, source.size)
+
+	linked := canLink && os.Link(source.file.Name(), dest) == nil
+	if linked {
+		if _, err := io.Copy(hash, reader); err != nil {
+			return file, err
+		}
+	} else {
+		destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return file, err
+		}
+		if _, err := io.Copy(io.MultiWriter(destFile, hash), reader); err != nil {
+			_ = destFile.Close()
+			return file, err
+		}
+		if err := destFile.Sync(); err != nil {
+			_ = destFile.Close()
+			return file, err
+		}
+		if err := destFile.Close(); err != nil {
+			return file, err
+		}
+	}
+
+	file.CRC = hash.Sum32()
+	return file, nil
+}
+
+// backupBPTreeIndex 将 B+ 树索引的快照以及事务序列号写入备份目录
+func (db *DB) backupBPTreeIndex(dir string, snapshot index.Reader, seqNo uint64) error {
+
+	defer func() {
+		_ = snapshot.Close()
+	}()
+
+	bptree := index.NewBPlusTree(dir, true)
+	iterator := snapshot.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		bptree.Put(iterator.Key(), iterator.Value())
+	}
+	iterator.Close()
+	if err := bptree.Close(); err != nil {
+		return err
+	}
+
+	return db.saveSeqNo(dir, seqNo)
+}
+
+// checksumNewFiles 计算备份目录中还没有记录到清单的文件的校验值
+func checksumNewFiles(dir string, manifest *BackupManifest) ([]BackupFile, error) {
+
+	recorded := make(map[string]bool)
+	for _, file := range manifest.Files {
+		recorded[file.Name] = true
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []BackupFile
+	for _, entry := range entries {
+		if recorded[entry.Name()] {
+			continue
+		}
+		file, err := checksumFile(dir, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// checksumFile 计算文件的长度和校验值
+func checksumFile(dir, name string) (BackupFile, error) {
+
+	file := BackupFile{Name: name}
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return file, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	hash := crc32.NewIEEE()
+	if file.Size, err = io.Copy(hash, f); err != nil {
+		return file, err
+	}
+	file.CRC = hash.Sum32()
+	return file, nil
+}
+
+// writeBackupManifest 写入备份清单，清单写入完成表示备份完成
+func writeBackupManifest(dir string, manifest *BackupManifest) error {
+
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpName := filepath.Join(dir, BackupManifestName+".tmp")
+	f, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(dir, BackupManifestName))
+}
+
+// ReadBackupManifest 读取备份清单
+func ReadBackupManifest(path string) (*BackupManifest, error) {
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(buf, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Restore 根据备份清单将数据库恢复到 dir，dir 必须不存在或者为空
+// 拷贝时校验每个文件的 crc，并检查恢复之后所有记录的 crc，校验失败时删除恢复的数据
+func Restore(manifest, dir string) (err error) {
+
+	m, err := ReadBackupManifest(manifest)
+	if err != nil {
+		return err
+	}
+
+	if err := checkEmptyDir(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	for _, file := range m.Files {
+		if file.Dir == "" {
+			file.Dir = filepath.Dir(manifest)
+		}
+		if err := restoreFile(file, dir); err != nil {
+			return err
+		}
+	}
+
+	// 逐条校验记录的 crc
+	report, err := Check(dir)
+	if err != nil {
+		return err
+	}
+	if !report.Healthy() {
+		return ErrBackupCorrupted
+	}
+	return nil
+}
+
+// restoreFile 从备份中拷贝一个文件，并校验长度和 crc
+func restoreFile(file BackupFile, dir string) error {
+
+	src, err := os.Open(filepath.Join(file.Dir, file.Name))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	dest, err := os.OpenFile(filepath.Join(dir, file.Name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dest.Close()
+	}()
+
+	hash := crc32.NewIEEE()
+	size, err := io.Copy(io.MultiWriter(dest, hash), src)
+	if err != nil {
+		return err
+	}
+	if size != file.Size || hash.Sum32() != file.CRC {
+		return ErrBackupCorrupted
+	}
+	return dest.Sync()
+}
+
+// checkEmptyDir 检查目录不存在或者为空
+func checkEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return ErrDirNotEmpty
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_HotBackup(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hot-backup")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	// 备份期间继续写入
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 2000; i < 4000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+		}
+	}()
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-hot-backup-dst")
+	defer func() {
+		_ = os.RemoveAll(backupDir)
+	}()
+	assert.Nil(t, db.Backup(backupDir))
+	wg.Wait()
+
+	// 备份目录不为空
+	assert.Equal(t, ErrDirNotEmpty, db.Backup(backupDir))
+
+	// 备份之前写入的数据全部存在
+	restoreDir := filepath.Join(backupDir, "restore")
+	assert.Nil(t, Restore(filepath.Join(backupDir, BackupManifestName), restoreDir))
+	opts.DirPath = restoreDir
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	for i := 0; i < 2000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_IncrementalBackup(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-incremental-backup")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	fullDir, _ := os.MkdirTemp("", "bitcask-go-backup-full")
+	defer func() {
+		_ = os.RemoveAll(fullDir)
+	}()
+	assert.Nil(t, db.Backup(fullDir))
+	full, err := ReadBackupManifest(filepath.Join(fullDir, BackupManifestName))
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 2000; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	incDir, _ := os.MkdirTemp("", "bitcask-go-backup-inc")
+	defer func() {
+		_ = os.RemoveAll(incDir)
+	}()
+	assert.Nil(t, db.IncrementalBackup(incDir, fullDir))
+	inc, err := ReadBackupManifest(filepath.Join(incDir, BackupManifestName))
+	assert.Nil(t, err)
+
+	// 全量备份中封存的数据文件没有重新拷贝
+	absFullDir, _ := filepath.Abs(fullDir)
+	var reused int
+	for _, file := range inc.Files {
+		if file.Dir == absFullDir {
+			reused++
+			_, err := os.Stat(filepath.Join(incDir, file.Name))
+			assert.True(t, os.IsNotExist(err))
+		}
+	}
+	assert.Greater(t, reused, 0)
+	assert.Greater(t, len(inc.Files), len(full.Files))
+
+	// 分别恢复两次备份
+	fullRestore := filepath.Join(fullDir, "restore")
+	assert.Nil(t, Restore(filepath.Join(fullDir, BackupManifestName), fullRestore))
+	incRestore := filepath.Join(incDir, "restore")
+	assert.Nil(t, Restore(filepath.Join(incDir, BackupManifestName), incRestore))
+
+	opts.DirPath = fullRestore
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	_, err = db2.Get(utils.GetTestKey(2500))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, db2.Close())
+
+	opts.DirPath = incRestore
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 3000; i++ {
+		_, err := db3.Get(utils.GetTestKey(i))
+		if i < 500 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	assert.Nil(t, db3.Close())
+}
+
+func TestRestore_Corrupted(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-restore-corrupted")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-restore-corrupted-dst")
+	defer func() {
+		_ = os.RemoveAll(backupDir)
+	}()
+	assert.Nil(t, db.Backup(backupDir))
+
+	// 修改备份中最后一个数据文件的内容，备份中的文件是拷贝而不是硬链接
+	manifest, err := ReadBackupManifest(filepath.Join(backupDir, BackupManifestName))
+	assert.Nil(t, err)
+	var lastDataFile string
+	for _, file := range manifest.Files {
+		if filepath.Ext(file.Name) == ".data" {
+			lastDataFile = file.Name
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(backupDir, lastDataFile), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 100)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	restoreDir := filepath.Join(backupDir, "restore")
+	assert.Equal(t, ErrBackupCorrupted, Restore(filepath.Join(backupDir, BackupManifestName), restoreDir))
+	_, err = os.Stat(restoreDir)
+	assert.True(t, os.IsNotExist(err))
+}
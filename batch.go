@@ -16,7 +16,8 @@ type WriteBatch struct {
 	options       WriteBatchOptions /* 原子写的配置项 */
 	mu            *sync.Mutex
 	db            *DB
-	pendingWrites map[string]*data.LogRecord /* 暂存用户写入数据 */
+	pendingWrites map[string]*data.LogRecord /* 暂存用户写入数据，按照命名空间和 key 索引 */
+	namespace     *Namespace                 /* Put / Delete 写入的命名空间，nil 表示默认命名空间 */
 }
 
 // 初始化原子写操作
@@ -37,6 +38,16 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 
 // Put 批量写数据
 func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	return wb.PutIn(wb.namespace, key, value)
+}
+
+// Delete 删除数据
+func (wb *WriteBatch) Delete(key []byte) error {
+	return wb.DeleteIn(wb.namespace, key)
+}
+
+// PutIn 批量向指定的命名空间写数据，ns 为 nil 表示默认命名空间
+func (wb *WriteBatch) PutIn(ns *Namespace, key []byte, value []byte) error {
 
 	// key 不能为空
 	if len(key) == 0 {
@@ -47,15 +58,16 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
 
 	// 暂存 LogRecord
 	logRecord := &data.LogRecord{
-		Key:   key,
-		Value: value,
+		Key:       key,
+		Value:     value,
+		Namespace: namespaceId(ns),
 	}
-	wb.pendingWrites[string(key)] = logRecord
+	wb.pendingWrites[namespaceKey(logRecord.Namespace, key)] = logRecord
 	return nil
 }
 
-// Delete 删除数据
-func (wb *WriteBatch) Delete(key []byte) error {
+// DeleteIn 删除指定命名空间中的数据，ns 为 nil 表示默认命名空间
+func (wb *WriteBatch) DeleteIn(ns *Namespace, key []byte) error {
 
 	// key 不能为空
 	if len(key) == 0 {
@@ -64,21 +76,31 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
+	idx := wb.db.index
+	if ns != nil {
+		var err error
+		if idx, err = ns.getIndex(); err != nil {
+			return err
+		}
+	}
+	pendingKey := namespaceKey(namespaceId(ns), key)
+
 	// 数据不存在直接返回
-	logRecordPos := wb.db.index.Get(key)
+	logRecordPos := idx.Get(key)
 	if logRecordPos == nil {
-		if wb.pendingWrites[string(key)] == nil {
-			delete(wb.pendingWrites, string(key))
+		if wb.pendingWrites[pendingKey] == nil {
+			delete(wb.pendingWrites, pendingKey)
 		}
 		return nil
 	}
 
 	// 暂存 LogRecord
 	logRecord := &data.LogRecord{
-		Key:  key,
-		Type: data.LogRecordDeleted,
+		Key:       key,
+		Type:      data.LogRecordDeleted,
+		Namespace: namespaceId(ns),
 	}
-	wb.pendingWrites[string(key)] = logRecord
+	wb.pendingWrites[pendingKey] = logRecord
 	return nil
 }
 
@@ -96,27 +118,78 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchNum
 	}
 
-	// 加锁
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	// 将该条事务统一写入数据文件并更新索引
+	if err := wb.db.commitTxnRecords(wb.pendingWrites, wb.options.SyncWrites, nil); err != nil {
+		return err
+	}
+
+	// 清空暂存数据
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+
+	return nil
+}
+
+// commitTxnRecords 加锁并以事务的方式写入一组数据，check 不为 nil 时在持有锁之后、写入之前检查是否可以提交
+// 开启 SyncWrites 和 GroupCommit 时交由组提交流程，与并发的写入共用一次持久化，否则 sync 或 SyncWrites 时单独持久化
+func (db *DB) commitTxnRecords(records map[string]*data.LogRecord, sync bool, check func() error) error {
+
+	write := func(sync bool) ([]Event, error) {
+		if check != nil {
+			if err := check(); err != nil {
+				return nil, err
+			}
+		}
+		return db.writeTxnRecords(records, sync)
+	}
+
+	if db.options.SyncWrites && db.options.GroupCommit {
+		_, err := db.groupCommit(&commitRequest{batch: func() ([]Event, error) {
+			return write(false)
+		}})
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	events, err := write(sync || db.options.SyncWrites)
+	if err != nil {
+		return err
+	}
+	return db.publishEvents(events, true)
+}
+
+// writeTxnRecords 以事务的方式写入一组数据，并在全部写入之后更新内存索引，返回需要通知订阅者的事件
+// records 中的数据可以属于不同的命名空间，sync 表示在更新内存索引之前持久化，调用方需要持有 db.mu
+func (db *DB) writeTxnRecords(records map[string]*data.LogRecord, sync bool) ([]Event, error) {
+
+	// 所有的命名空间都需要存在
+	for _, record := range records {
+		if db.namespaceIndex(record.Namespace) == nil {
+			return nil, ErrNamespaceNotFound
+		}
+	}
 
 	// 获取当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 
-	// 将该条事务统一写入数据文件
+	// 将该条事务统一写入数据文件，记录写入的顺序
 	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
-		})
+	order := make([]string, 0, len(records))
+	for pendingKey, record := range records {
+		logRecordPos, err := db.writeLogRecord(&data.LogRecord{
+			Key:       logRecordKeyWithSeq(record.Key, seqNo),
+			Value:     record.Value,
+			Type:      record.Type,
+			Expire:    record.Expire,
+			Namespace: record.Namespace,
+		}, false)
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		positions[string(record.Key)] = logRecordPos
+		positions[pendingKey] = logRecordPos
+		order = append(order, pendingKey)
 	}
 
 	// 最后追加一条标识事务完成的数据
@@ -124,36 +197,58 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished,
 	}
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
-		return err
+	finishedPos, err := db.writeLogRecord(finishedRecord, false)
+	if err != nil {
+		return nil, err
 	}
 
-	// 根据配置决定是否持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
-			return err
+	// 根据配置决定是否持久化，整个事务只持久化一次
+	if sync {
+		if err := db.syncActiveFiles(); err != nil {
+			return nil, err
 		}
+		db.bytesWrite = 0
 	}
 
 	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
+	for pendingKey, record := range records {
+		idx := db.namespaceIndex(record.Namespace)
+		pos := positions[pendingKey]
 		var oldPos *data.LogRecordPos
 		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+			oldPos = idx.Put(record.Key, pos)
 		}
 		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			// 删除标识本身同样计入可以回收的数据，与 Delete 一致
+			db.addTombstoneSize(pos)
+			oldPos, _ = idx.Delete(record.Key)
 		}
 		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+			db.addReclaimSize(oldPos)
 		}
 	}
 
-	// 清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
+	// 索引更新之后由调用方通知订阅者，事件按照写入的顺序排列，命名空间中的写入不通知
+	var events []Event
+	if len(db.watchers) > 0 {
+		for _, pendingKey := range order {
+			record := records[pendingKey]
+			if record.Namespace != defaultNamespaceId {
+				continue
+			}
+			event := recordEvent(record.Key, record, positions[pendingKey])
+			event.Txn = true
+			events = append(events, event)
+		}
+		if len(events) > 0 {
+			events = append(events, Event{
+				Type: EventTxnCommit,
+				Seq:  eventSeq(finishedPos.Fid, finishedPos.Offset+int64(finishedPos.Size)),
+			})
+		}
+	}
 
-	return nil
+	return events, nil
 }
 
 // key + Seq Number 编码
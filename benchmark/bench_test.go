@@ -4,6 +4,7 @@ import (
 	bitcaskkv "bitcask-go"
 	"bitcask-go/utils"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -72,3 +73,44 @@ func Benchmark_Delete1KB(b *testing.B) {
 	}
 
 }
+
+// benchmarkPutSync 并发写入并且每次写入都持久化
+func benchmarkPutSync(b *testing.B, groupCommit bool) {
+
+	opts := bitcaskkv.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bench-sync")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.GroupCommit = groupCommit
+
+	syncDB, err := bitcaskkv.Open(opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer syncDB.Close()
+
+	value := utils.GetTestValue(1024)
+	var counter int64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&counter, 1)
+			if err := syncDB.Put(utils.GetTestKey(int(i)), value); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func Benchmark_PutSync1KB_GroupCommit(b *testing.B) {
+	benchmarkPutSync(b, true)
+}
+
+func Benchmark_PutSync1KB_NoGroupCommit(b *testing.B) {
+	benchmarkPutSync(b, false)
+}
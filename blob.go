@@ -0,0 +1,448 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+key-value 分离：value 达到 ValueThreshold 时写入单独的 blob 文件，数据文件中的记录只保存指向 blob 的指针（编码后的 LogRecordPos），
+merge 与 compaction 只重写数据文件中的指针，不需要重写大的 value
+
+blob 文件中的每条记录的 key 为实际的 key，命名空间 id 记录在 header 中，GCBlobFiles 据此判断 value 是否仍然有效：
+索引指向的记录仍然指向该位置时有效，否则 value 已经被覆盖或删除。回收时有效的 value 重新写入活跃的 blob 文件，
+并追加一条指向新位置的记录，与数据文件的 merge 互不影响
+
+被回收的 blob 文件在没有快照、迭代器等引用数据文件之后删除；回收之前写入的历史记录在回放时读不到已经被回收的 value，
+这些记录之后一定有覆盖或删除该 key 的记录
+*/
+
+// separateValue 将达到阈值的 value 写入 blob 文件，返回实际写入数据文件的记录（需要持有 db.mu）
+// 原记录不会被修改，调用方仍然可以使用原来的 value
+func (db *DB) separateValue(logRecord *data.LogRecord) (*data.LogRecord, error) {
+
+	if db.options.ValueThreshold <= 0 ||
+		logRecord.Blob ||
+		logRecord.Type != data.LogRecordNormal ||
+		len(logRecord.Value) < db.options.ValueThreshold {
+		return logRecord, nil
+	}
+
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+	blobPos, err := db.writeBlob(realKey, logRecord.Namespace, logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.LogRecord{
+		Key:       logRecord.Key,
+		Value:     data.EncodeLogRecordPos(blobPos),
+		Type:      logRecord.Type,
+		Expire:    logRecord.Expire,
+		Namespace: logRecord.Namespace,
+		Blob:      true,
+		Origin:    logRecord.Origin,
+	}, nil
+}
+
+// writeBlob 向活跃的 blob 文件追加 value，返回 value 在 blob 文件中的位置（需要持有 db.mu）
+func (db *DB) writeBlob(key []byte, namespace uint32, value []byte) (*data.LogRecordPos, error) {
+
+	record, err := db.compressLogRecord(&data.LogRecord{
+		Key:       key,
+		Value:     value,
+		Type:      data.LogRecordNormal,
+		Namespace: namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	encRecord, size, err := data.EncodeEncryptedLogRecord(record, db.encryptor)
+	if err != nil {
+		return nil, err
+	}
+
+	// 活跃的 blob 文件写满之后封存，打开新的 blob 文件
+	if db.activeBlobFile == nil || db.activeBlobFile.WriteOff+size > db.options.DataFileSize {
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	writeOff := db.activeBlobFile.WriteOff
+	if err := db.activeBlobFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	db.blobDirty = true
+
+	return &data.LogRecordPos{
+		Fid:    db.activeBlobFile.FileId,
+		Offset: writeOff,
+		Size:   uint32(size),
+	}, nil
+}
+
+// setActiveBlobFile 持久化并封存当前活跃的 blob 文件，打开新的 blob 文件（需要持有 db.mu）
+func (db *DB) setActiveBlobFile() error {
+
+	var fileId uint32 = 0
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+		db.blobDirty = false
+		fileId = db.activeBlobFile.FileId + 1
+	}
+
+	// 回收中的 blob 文件 id 同样不能复用
+	for fid := range db.retiredBlobs {
+		if fid >= fileId {
+			fileId = fid + 1
+		}
+	}
+
+	blobFile, err := data.OpenBlobFile(db.options.DirPath, fileId)
+	if err != nil {
+		return err
+	}
+	blobFile.Encryptor = db.encryptor
+	db.blobFiles[fileId] = blobFile
+	db.activeBlobFile = blobFile
+	return nil
+}
+
+// syncActiveFiles 持久化活跃文件（需要持有 db.mu）
+// 数据文件中的记录可能指向活跃 blob 文件中的 value，因此先持久化 blob 文件
+func (db *DB) syncActiveFiles() error {
+	if db.activeBlobFile != nil && db.blobDirty {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+		db.blobDirty = false
+	}
+	if db.activeFile == nil {
+		return nil
+	}
+	return db.activeFile.Sync()
+}
+
+// readBlobValue 根据数据文件记录中的 blob 指针读取 value（需要持有 db.mu）
+func (db *DB) readBlobValue(pointer []byte) ([]byte, error) {
+
+	blobPos := data.DecodeLogRecordPos(pointer)
+	blobFile, ok := db.blobFiles[blobPos.Fid]
+	if !ok {
+		if blobFile, ok = db.retiredBlobs[blobPos.Fid]; !ok {
+			return nil, ErrDataFileNoFound
+		}
+	}
+
+	logRecord, _, err := blobFile.ReadLogRecord(blobPos.Offset)
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		return nil, err
+	}
+	return logRecord.Value, nil
+}
+
+// loadBlobFiles 打开数据目录中所有的 blob 文件，最大 id 的文件作为活跃的 blob 文件，只读模式下不写入
+func (db *DB) loadBlobFiles() error {
+
+	if err := db.openNewBlobFiles(); err != nil {
+		return err
+	}
+	if db.options.ReadOnly {
+		return nil
+	}
+
+	for fid, blobFile := range db.blobFiles {
+		if db.activeBlobFile == nil || fid > db.activeBlobFile.FileId {
+			db.activeBlobFile = blobFile
+		}
+	}
+	if db.activeBlobFile != nil {
+		size, err := db.activeBlobFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		db.activeBlobFile.WriteOff = size
+	}
+	return nil
+}
+
+// openNewBlobFiles 打开数据目录中还没有打开的 blob 文件（需要持有 db.mu）
+func (db *DB) openNewBlobFiles() error {
+
+	fileIds, err := listBlobFileIds(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIds {
+		if _, ok := db.blobFiles[fid]; ok {
+			continue
+		}
+		blobFile, err := data.OpenBlobFile(db.options.DirPath, fid)
+		if err != nil {
+			return err
+		}
+		blobFile.Encryptor = db.encryptor
+		db.blobFiles[fid] = blobFile
+	}
+	return nil
+}
+
+// listBlobFileIds 列出目录中所有 blob 文件的 id，按照从小到大排列
+func listBlobFileIds(dirPath string) ([]uint32, error) {
+
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIds []uint32
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, data.BlobFileNameSuffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.TrimSuffix(name, data.BlobFileNameSuffix))
+		if err != nil {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		fileIds = append(fileIds, uint32(fid))
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	return fileIds, nil
+}
+
+// GCBlobFiles 回收 blob 文件中已经被覆盖或删除的 value
+// 无效数据占比达到 BlobGarbageRatio 的封存 blob 文件中，有效的 value 重新写入活跃的 blob 文件，然后删除该文件
+// 回收期间不阻塞读写，与数据文件的 merge、compaction 互不影响
+func (db *DB) GCBlobFiles() error {
+
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	db.mu.Lock()
+	if db.isBlobGC {
+		db.mu.Unlock()
+		return ErrBlobGCIsProgress
+	}
+	db.isBlobGC = true
+
+	// 只回收封存的 blob 文件
+	var blobFiles []*data.DataFile
+	for fid, blobFile := range db.blobFiles {
+		if db.activeBlobFile == nil || fid != db.activeBlobFile.FileId {
+			blobFiles = append(blobFiles, blobFile)
+		}
+	}
+	db.mu.Unlock()
+
+	defer func() {
+		db.mu.Lock()
+		db.isBlobGC = false
+		db.mu.Unlock()
+	}()
+
+	sort.Slice(blobFiles, func(i, j int) bool {
+		return blobFiles[i].FileId < blobFiles[j].FileId
+	})
+	for _, blobFile := range blobFiles {
+		liveSize, totalSize, err := db.blobLiveSize(blobFile)
+		if err != nil {
+			return err
+		}
+		if totalSize > 0 && float32(totalSize-liveSize)/float32(totalSize) < db.options.BlobGarbageRatio {
+			continue
+		}
+		if err := db.rewriteBlobFile(blobFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blobLiveSize 统计 blob 文件中仍然有效的 value 的大小以及文件中所有 value 的大小
+func (db *DB) blobLiveSize(blobFile *data.DataFile) (int64, int64, error) {
+
+	var liveSize, totalSize int64
+	var offset int64 = 0
+	for {
+		logRecord, size, err := blobFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, 0, err
+		}
+
+		db.mu.RLock()
+		pos, _, err := db.liveBlobRecord(logRecord, blobFile.FileId, offset)
+		db.mu.RUnlock()
+		if err != nil {
+			return 0, 0, err
+		}
+		if pos != nil {
+			liveSize += size
+		}
+		totalSize += size
+		offset += size
+	}
+	return liveSize, totalSize, nil
+}
+
+// rewriteBlobFile 将 blob 文件中有效的 value 重新写入活跃的 blob 文件并更新索引，然后删除该文件
+func (db *DB) rewriteBlobFile(blobFile *data.DataFile) error {
+
+	var offset int64 = 0
+	for {
+		logRecord, size, err := blobFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if err := db.rewriteBlob(logRecord, blobFile.FileId, offset); err != nil {
+			return err
+		}
+		offset += size
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 重写的数据持久化之后才能删除旧文件
+	if err := db.syncActiveFiles(); err != nil {
+		return err
+	}
+	delete(db.blobFiles, blobFile.FileId)
+	db.retiredBlobs[blobFile.FileId] = blobFile
+	if len(db.pinnedFiles) == 0 {
+		db.removeRetiredBlobs()
+	}
+	return nil
+}
+
+// rewriteBlob 如果 blob 中的 value 仍然有效，则写入活跃的 blob 文件并追加指向新位置的记录
+func (db *DB) rewriteBlob(logRecord *data.LogRecord, fid uint32, offset int64) error {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	pos, dataRecord, err := db.liveBlobRecord(logRecord, fid, offset)
+	if err != nil || pos == nil {
+		return err
+	}
+
+	// 重写的记录保留最初写入的位置，回放事件时据此跳过已经回放过的数据
+	origin := dataRecord.Origin
+	if origin == 0 {
+		origin = eventSeq(pos.Fid, pos.Offset+int64(pos.Size))
+	}
+
+	blobPos, err := db.writeBlob(logRecord.Key, logRecord.Namespace, logRecord.Value)
+	if err != nil {
+		return err
+	}
+	newPos, err := db.writeLogRecordSilently(&data.LogRecord{
+		Key:       logRecordKeyWithSeq(logRecord.Key, nonTransactionSeqNo),
+		Value:     data.EncodeLogRecordPos(blobPos),
+		Type:      data.LogRecordNormal,
+		Expire:    pos.Expire,
+		Namespace: logRecord.Namespace,
+		Blob:      true,
+		Origin:    origin,
+	}, false)
+	if err != nil {
+		return err
+	}
+	db.namespaceIndex(logRecord.Namespace).Put(logRecord.Key, newPos)
+	db.addReclaimSize(pos)
+	return nil
+}
+
+// liveBlobRecord 如果索引指向的记录仍然指向 blob 文件中的该位置，返回索引中的位置以及该记录，否则返回 nil（需要持有 db.mu）
+func (db *DB) liveBlobRecord(blobRecord *data.LogRecord, fid uint32, offset int64) (*data.LogRecordPos, *data.LogRecord, error) {
+
+	idx := db.namespaceIndex(blobRecord.Namespace)
+	if idx == nil {
+		return nil, nil, nil
+	}
+	pos := idx.Get(blobRecord.Key)
+	if pos == nil {
+		return nil, nil, nil
+	}
+
+	dataFile := db.getDataFile(pos.Fid)
+	if dataFile == nil {
+		return nil, nil, ErrDataFileNoFound
+	}
+	logRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !logRecord.Blob {
+		return nil, nil, nil
+	}
+	blobPos := data.DecodeLogRecordPos(logRecord.Value)
+	if blobPos.Fid != fid || blobPos.Offset != offset {
+		return nil, nil, nil
+	}
+	return pos, logRecord, nil
+}
+
+// removeRetiredBlobs 删除已经被回收的 blob 文件，只读模式下只关闭（需要持有 db.mu，且没有被引用的数据文件）
+func (db *DB) removeRetiredBlobs() {
+	for fid, blobFile := range db.retiredBlobs {
+		delete(db.retiredBlobs, fid)
+		_ = blobFile.Close()
+		if !db.options.ReadOnly {
+			_ = os.Remove(data.GetBlobFileName(db.options.DirPath, fid))
+		}
+	}
+}
+
+// refreshBlobFiles 只读模式下关闭写入方已经删除的 blob 文件，present 为之前列出的 blob 文件（需要持有 db.mu）
+func (db *DB) refreshBlobFiles(present []uint32) error {
+
+	presentIds := make(map[uint32]bool, len(present))
+	for _, fid := range present {
+		presentIds[fid] = true
+	}
+	for fid, blobFile := range db.blobFiles {
+		if !presentIds[fid] {
+			delete(db.blobFiles, fid)
+			db.retiredBlobs[fid] = blobFile
+		}
+	}
+	if len(db.pinnedFiles) == 0 {
+		db.removeRetiredBlobs()
+	}
+	return db.openNewBlobFiles()
+}
+
+// closeBlobFiles 关闭所有的 blob 文件
+func (db *DB) closeBlobFiles() error {
+	for _, blobFile := range db.blobFiles {
+		if err := blobFile.Close(); err != nil {
+			return err
+		}
+	}
+	for _, blobFile := range db.retiredBlobs {
+		if err := blobFile.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ValueSeparation(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 256
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	// 偶数 key 写入大的 value，奇数 key 写入小的 value
+	values := make(map[string][]byte)
+	for i := 0; i < 500; i++ {
+		value := utils.GetTestValue(16)
+		if i%2 == 0 {
+			value = utils.GetTestValue(1024)
+		}
+		values[string(utils.GetTestKey(i))] = value
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	assert.Greater(t, db.Stat().BlobFileNum, uint(1))
+
+	// 事务中写入的大 value 同样分离
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 500; i < 600; i++ {
+		value := utils.GetTestValue(1024)
+		values[string(utils.GetTestKey(i))] = value
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), value))
+	}
+	assert.Nil(t, wb.Commit())
+
+	checkValues := func(db *DB) {
+		for key, value := range values {
+			v, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, v)
+		}
+
+		count := 0
+		iterator := db.NewIterator(DefaultIteratorOptions)
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			v, err := iterator.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, values[string(iterator.Key())], v)
+			count++
+		}
+		iterator.Close()
+		assert.Equal(t, len(values), count)
+
+		assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+			assert.Equal(t, values[string(key)], value)
+			return true
+		}))
+	}
+	checkValues(db)
+
+	// merge 只重写数据文件中的指针，blob 文件不变
+	for i := 0; i < 500; i += 4 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(values, string(utils.GetTestKey(i)))
+	}
+	blobFileNum := db.Stat().BlobFileNum
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, blobFileNum, db.Stat().BlobFileNum)
+	checkValues(db)
+
+	// 重启之后仍然可以读取
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkValues(db)
+}
+
+func TestDB_GCBlobFiles(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-gc")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 256
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(1024)))
+	}
+
+	// 覆盖写入大部分 key，之前的 blob 文件中大部分 value 失效
+	values := make(map[string][]byte)
+	for i := 0; i < 300; i++ {
+		value := utils.GetTestValue(1024)
+		if i%10 == 0 {
+			value, err = db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+		} else {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		}
+		values[string(utils.GetTestKey(i))] = value
+	}
+
+	// 迭代器引用期间回收的 blob 文件不会被删除
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	blobFileNum := db.Stat().BlobFileNum
+	assert.Nil(t, db.GCBlobFiles())
+	assert.Less(t, db.Stat().BlobFileNum, blobFileNum)
+	assert.NotEmpty(t, db.retiredBlobs)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		_, err := iterator.Value()
+		assert.Nil(t, err)
+	}
+	iterator.Close()
+	assert.Empty(t, db.retiredBlobs)
+
+	blobFileIds, err := listBlobFileIds(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, int(db.Stat().BlobFileNum), len(blobFileIds))
+
+	for key, value := range values {
+		v, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, v)
+	}
+
+	// 重启之后仍然可以读取重写之后的 value
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for key, value := range values {
+		v, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, v)
+	}
+}
+
+func TestDB_ValueSeparationBackup(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-backup")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.ValueThreshold = 256
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(1024)))
+	}
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-blob-backup-dest")
+	defer func() {
+		_ = os.RemoveAll(backupDir)
+	}()
+	assert.Nil(t, db.Backup(backupDir))
+
+	restoreDir := filepath.Join(backupDir, "restore")
+	assert.Nil(t, Restore(filepath.Join(backupDir, BackupManifestName), restoreDir))
+	opts.DirPath = restoreDir
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	for i := 0; i < 200; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		restored, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, restored)
+	}
+}
@@ -0,0 +1,102 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"os"
+	"path/filepath"
+)
+
+// bloomFilterKey 布隆过滤器文件中记录的 key
+const bloomFilterKey = "bloom.filter"
+
+/*
+布隆过滤器包装在内存索引之外：写入时加入 key，查询时判断 key 一定不存在的话直接返回，
+DB.Get、WriteBatch.Delete 以及 redis 数据结构查找元数据时对不存在的 key 不再访问 B+ 树索引文件
+
+内存索引在启动时加载完成之后遍历构建；B+ 树索引在关闭时保存到 bloom-filter 文件，启动时加载之后删除，
+因此异常退出之后的下一次启动会遍历 B+ 树重新构建，不会使用过期的布隆过滤器，也不需要扫描数据文件
+*/
+
+// loadBloomFilter 为内存索引加上布隆过滤器，B+ 树索引优先加载保存的布隆过滤器
+func (db *DB) loadBloomFilter() error {
+
+	if !db.options.BloomFilter {
+		return nil
+	}
+
+	var filter *index.BloomFilter
+	if db.options.IndexType == BPTree {
+		filter = db.readBloomFilter()
+
+		// 加载之后删除，之后的写入只在内存中的布隆过滤器中
+		fileName := filepath.Join(db.options.DirPath, data.BloomFilterFileName)
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	db.index = index.NewBloomIndex(db.index, filter, db.options.BloomFalsePositiveRate)
+	return nil
+}
+
+// readBloomFilter 读取保存的布隆过滤器，文件不存在、损坏或者误判率配置改变时返回 nil
+func (db *DB) readBloomFilter() *index.BloomFilter {
+
+	fileName := filepath.Join(db.options.DirPath, data.BloomFilterFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	bloomFile, err := data.OpenBloomFilterFile(db.options.DirPath)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = bloomFile.Close()
+	}()
+	bloomFile.Encryptor = db.encryptor
+
+	record, _, err := bloomFile.ReadLogRecord(0)
+	if err != nil {
+		return nil
+	}
+	filter, err := index.DecodeBloomFilter(record.Value)
+	if err != nil || filter.TargetFalsePositiveRate() != db.options.BloomFalsePositiveRate {
+		return nil
+	}
+	return filter
+}
+
+// saveBloomFilter 保存 B+ 树索引的布隆过滤器（需要持有 db.mu）
+func (db *DB) saveBloomFilter() error {
+
+	bloomIndex, ok := db.index.(*index.BloomIndex)
+	if !ok || db.options.IndexType != BPTree {
+		return nil
+	}
+
+	record := &data.LogRecord{
+		Key:   []byte(bloomFilterKey),
+		Value: bloomIndex.EncodeFilter(),
+	}
+	encRecord, _, err := data.EncodeEncryptedLogRecord(record, db.encryptor)
+	if err != nil {
+		return err
+	}
+
+	// 先写入临时文件再替换，避免留下不完整的文件
+	tmpName := filepath.Join(db.options.DirPath, data.BloomFilterFileName+".tmp")
+	if err := os.WriteFile(tmpName, encRecord, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(db.options.DirPath, data.BloomFilterFileName))
+}
+
+// bloomFalsePositiveRate 布隆过滤器当前估计的误判率，没有开启时返回 0（需要持有 db.mu）
+func (db *DB) bloomFalsePositiveRate() float64 {
+	if bloomIndex, ok := db.index.(*index.BloomIndex); ok {
+		return bloomIndex.FalsePositiveRate()
+	}
+	return 0
+}
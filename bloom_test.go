@@ -0,0 +1,160 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_BloomFilter(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bloom")
+	opts.DirPath = dir
+	opts.IndexType = BPTree
+	opts.MMapAtStartup = false
+	opts.BloomFilter = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(16)))
+	}
+	stat := db.Stat()
+	assert.Greater(t, stat.BloomFalsePositiveRate, float64(0))
+	assert.Less(t, stat.BloomFalsePositiveRate, opts.BloomFalsePositiveRate*2)
+
+	// 不存在的 key 由布隆过滤器直接判断
+	bloomIndex := db.index.(*index.BloomIndex)
+	assert.False(t, bloomIndex.MayContain([]byte("missing")))
+	_, err = db.Get([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 关闭时保存，启动时加载之后删除
+	assert.Nil(t, db.Close())
+	fileName := filepath.Join(dir, data.BloomFilterFileName)
+	_, err = os.Stat(fileName)
+	assert.Nil(t, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(fileName)
+	assert.True(t, os.IsNotExist(err))
+	for i := 0; i < 3000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.InDelta(t, stat.BloomFalsePositiveRate, db.Stat().BloomFalsePositiveRate, 1e-9)
+
+	// 没有保存的布隆过滤器时遍历 B+ 树重新构建
+	assert.Nil(t, db.Put([]byte("after"), []byte("value")))
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.Remove(fileName))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	value, err := db.Get([]byte("after"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	_, err = db.Get(utils.GetTestKey(2999))
+	assert.Nil(t, err)
+}
+
+func TestDB_BloomFilterMemoryIndex(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bloom-memory")
+	opts.DirPath = dir
+	opts.BloomFilter = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(16)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	// 原子写删除不存在的 key 时不访问索引
+	assert.False(t, db.index.(*index.BloomIndex).MayContain([]byte("missing")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete([]byte("missing")))
+	assert.Nil(t, wb.Commit())
+
+	// 内存索引在启动时重新构建，不保存布隆过滤器
+	assert.Nil(t, db.Close())
+	_, err = os.Stat(filepath.Join(dir, data.BloomFilterFileName))
+	assert.True(t, os.IsNotExist(err))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Greater(t, db.Stat().BloomFalsePositiveRate, float64(0))
+
+	// 误判率必须在 0 和 1 之间
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-bloom-invalid")
+	defer func() {
+		_ = os.RemoveAll(opts.DirPath)
+	}()
+	opts.BloomFalsePositiveRate = 1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+// merge 之后没有 Close 就崩溃，重启时不能加载到 merge DB 的空布隆过滤器
+func TestDB_BloomFilterMergeCrash(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bloom-merge")
+	opts.DirPath = dir
+	opts.IndexType = BPTree
+	opts.MMapAtStartup = false
+	opts.BloomFilter = true
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(16)))
+	}
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	_, err = os.Stat(filepath.Join(dir, data.BloomFilterFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	// 模拟崩溃：不保存布隆过滤器，直接释放文件和锁
+	db.closeFiles()
+	assert.Nil(t, db.flieLock.Unlock())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
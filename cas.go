@@ -0,0 +1,158 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"time"
+)
+
+// CompareAndSwap 当 key 当前的 value 与 oldValue 相同时写入 newValue
+// key 不存在返回 ErrKeyNotFound，value 不一致返回 ErrValueMismatch
+func (db *DB) CompareAndSwap(key, oldValue, newValue []byte) error {
+
+	// 判断 key 是否有效
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	// 检查与写入在同一次持有锁的过程中完成，开启组提交时同样如此
+	_, err := db.appendCheckedLogRecord(func() (*data.LogRecord, error) {
+		value, logRecordPos, err := db.getLocked(key)
+		if err != nil {
+			return nil, err
+		}
+		if logRecordPos == nil {
+			return nil, ErrKeyNotFound
+		}
+		if !bytes.Equal(value, oldValue) {
+			return nil, ErrValueMismatch
+		}
+
+		// 只替换 value，保留 key 原有的过期时间
+		return putRecord(key, newValue, logRecordPos.Expire), nil
+	}, db.putIndex(key))
+	return err
+}
+
+// PutIfAbsent 仅当 key 不存在时写入，key 已存在返回 ErrKeyExists
+func (db *DB) PutIfAbsent(key, value []byte) error {
+
+	// 判断 key 是否有效
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	_, err := db.appendCheckedLogRecord(func() (*data.LogRecord, error) {
+		_, logRecordPos, err := db.getLocked(key)
+		if err != nil {
+			return nil, err
+		}
+		if logRecordPos != nil {
+			return nil, ErrKeyExists
+		}
+		return putRecord(key, value, 0), nil
+	}, db.putIndex(key))
+	return err
+}
+
+// DeleteIfEqual 当 key 当前的 value 与 value 相同时删除
+// key 不存在返回 ErrKeyNotFound，value 不一致返回 ErrValueMismatch
+func (db *DB) DeleteIfEqual(key, value []byte) error {
+
+	// 判断 key 是否有效
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	_, err := db.appendCheckedLogRecord(func() (*data.LogRecord, error) {
+		currValue, logRecordPos, err := db.getLocked(key)
+		if err != nil {
+			return nil, err
+		}
+		if logRecordPos == nil {
+			return nil, ErrKeyNotFound
+		}
+		if !bytes.Equal(currValue, value) {
+			return nil, ErrValueMismatch
+		}
+		return &data.LogRecord{
+			Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
+			Type: data.LogRecordDeleted,
+		}, nil
+	}, db.deleteIndex(key))
+	return err
+}
+
+// Update 原子地读取 key 当前的值，交由 fn 计算新值并写入
+// fn 返回错误时放弃写入并将错误返回给调用方
+func (db *DB) Update(key []byte, fn func(old []byte, exists bool) ([]byte, error)) error {
+
+	// 判断 key 是否有效
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	_, err := db.appendCheckedLogRecord(func() (*data.LogRecord, error) {
+		oldValue, logRecordPos, err := db.getLocked(key)
+		if err != nil {
+			return nil, err
+		}
+
+		newValue, err := fn(oldValue, logRecordPos != nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// key 已存在时保留原有的过期时间
+		var expire int64
+		if logRecordPos != nil {
+			expire = logRecordPos.Expire
+		}
+		return putRecord(key, newValue, expire), nil
+	}, db.putIndex(key))
+	return err
+}
+
+// getLocked 读取 key 当前的值以及索引信息，key 不存在或者已经过期时索引信息为 nil（需要持有 db.mu）
+func (db *DB) getLocked(key []byte) ([]byte, *data.LogRecordPos, error) {
+
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || isExpired(logRecordPos, time.Now().UnixNano()) {
+		return nil, nil, nil
+	}
+
+	value, err := db.getValueByPosition(logRecordPos)
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, logRecordPos, nil
+}
+
+// putRecord 构造写入 key 的 LogRecord，expire 为 0 表示永不过期
+func putRecord(key, value []byte, expire int64) *data.LogRecord {
+	return &data.LogRecord{
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
+	}
+}
+
+// putIndex 返回写入之后更新内存索引的函数（在持有 db.mu 的情况下调用）
+func (db *DB) putIndex(key []byte) func(pos *data.LogRecordPos) {
+	return func(pos *data.LogRecordPos) {
+		if oldPos := db.index.Put(key, pos); oldPos != nil {
+			db.addReclaimSize(oldPos)
+		}
+	}
+}
+
+// deleteIndex 返回写入删除标识之后从内存索引中删除 key 的函数（在持有 db.mu 的情况下调用）
+func (db *DB) deleteIndex(key []byte) func(pos *data.LogRecordPos) {
+	return func(pos *data.LogRecordPos) {
+		db.addTombstoneSize(pos)
+		if oldPos, _ := db.index.Delete(key); oldPos != nil {
+			db.addReclaimSize(oldPos)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_CompareAndSwap(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	/* CompareAndSwap */
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("x"), []byte("b"))
+	assert.Equal(t, ErrValueMismatch, err)
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+
+	/* PutIfAbsent */
+	err = db.PutIfAbsent(utils.GetTestKey(1), []byte("c"))
+	assert.Equal(t, ErrKeyExists, err)
+	err = db.PutIfAbsent(utils.GetTestKey(2), []byte("c"))
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+
+	/* DeleteIfEqual */
+	err = db.DeleteIfEqual(utils.GetTestKey(2), []byte("x"))
+	assert.Equal(t, ErrValueMismatch, err)
+	err = db.DeleteIfEqual(utils.GetTestKey(2), []byte("c"))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db.DeleteIfEqual(utils.GetTestKey(2), []byte("c"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_Update(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-update")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	// 并发自增，结果不会丢失更新
+	incr := func(old []byte, exists bool) ([]byte, error) {
+		var n int
+		if exists {
+			n, _ = strconv.Atoi(string(old))
+		}
+		return []byte(strconv.Itoa(n + 1)), nil
+	}
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.Nil(t, db.Update([]byte("counter"), incr))
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1000"), val)
+
+	// fn 返回错误时不写入
+	errAbort := errors.New("abort")
+	err = db.Update([]byte("counter"), func(old []byte, exists bool) ([]byte, error) {
+		return nil, errAbort
+	})
+	assert.Equal(t, errAbort, err)
+	val, err = db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1000"), val)
+}
+
+func TestDB_UpdateKeepsExpire(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-update-expire")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	// Update 与 CompareAndSwap 只替换 value，保留原有的过期时间
+	assert.Nil(t, db.PutWithTTL([]byte("key"), []byte("a"), time.Hour))
+	assert.Nil(t, db.Update([]byte("key"), func(old []byte, exists bool) ([]byte, error) {
+		return []byte("b"), nil
+	}))
+	ttl, err := db.TTL([]byte("key"))
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, 59*time.Minute)
+
+	assert.Nil(t, db.CompareAndSwap([]byte("key"), []byte("b"), []byte("c")))
+	ttl, err = db.TTL([]byte("key"))
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, 59*time.Minute)
+
+	// 短暂的存活时间在 Update 之后依然会过期
+	assert.Nil(t, db.PutWithTTL([]byte("short"), []byte("a"), 50*time.Millisecond))
+	assert.Nil(t, db.Update([]byte("short"), func(old []byte, exists bool) ([]byte, error) {
+		return []byte("b"), nil
+	}))
+	time.Sleep(100 * time.Millisecond)
+	_, err = db.Get([]byte("short"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后过期时间依然保留
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	ttl, err = db.TTL([]byte("key"))
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, 59*time.Minute)
+}
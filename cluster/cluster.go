@@ -0,0 +1,335 @@
+package cluster
+
+import (
+	bitcaskkv "bitcask-go"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotLeader       = errors.New("the node is not the leader")
+	ErrLeadershipLost  = errors.New("leadership lost before the command was committed")
+	ErrTimeout         = errors.New("timed out waiting for the command to be applied")
+	ErrClosed          = errors.New("the node has been closed")
+	ErrKeyIsEmpty      = bitcaskkv.ErrKeyIsEmpty
+	ErrKeyNotFound     = bitcaskkv.ErrKeyNotFound
+	ErrInvalidPeers    = errors.New("the peers must contain the node itself")
+	ErrInvalidSnapshot = errors.New("the snapshot is invalid")
+)
+
+// 数据目录中的子目录
+const (
+	dbDirName       = "db"       /* 状态机，即对外提供读写的 bitcask 实例 */
+	logDirName      = "raft"     /* raft 日志以及任期、投票 */
+	snapshotDirName = "snapshot" /* 最近一次快照 */
+)
+
+// Config 节点配置项
+type Config struct {
+	ID    string   /* 节点的地址，与 Transport 中使用的地址一致 */
+	Peers []string /* 集群中所有节点的地址，包括自身 */
+	Dir   string   /* 节点的数据目录 */
+
+	Options   bitcaskkv.Options /* 状态机的配置，DirPath 由 Dir 决定 */
+	Transport Transport         /* 节点之间的通信 */
+
+	ElectionTimeout   time.Duration /* 没有收到 leader 消息多久之后发起选举，实际时间在 [ElectionTimeout, 2*ElectionTimeout) 之间随机 */
+	HeartbeatInterval time.Duration /* leader 发送心跳的间隔 */
+	MaxAppendEntries  int           /* 一次复制的最大日志数量 */
+	SnapshotThreshold uint64        /* 快照之后应用了多少条日志再生成新的快照，0 表示不自动生成快照 */
+	ApplyTimeout      time.Duration /* 写入以及一致性读等待日志应用的超时时间 */
+	FollowerReads     bool          /* follower 是否直接读取本地数据，读取的数据可能落后于 leader */
+}
+
+var DefaultConfig = Config{
+	Options:           bitcaskkv.DefaultOptions,
+	ElectionTimeout:   300 * time.Millisecond,
+	HeartbeatInterval: 50 * time.Millisecond,
+	MaxAppendEntries:  64,
+	SnapshotThreshold: 4096,
+	ApplyTimeout:      5 * time.Second,
+	FollowerReads:     false,
+}
+
+// 节点的角色
+const (
+	follower = iota
+	candidate
+	leader
+)
+
+// proposal 等待应用的写入
+type proposal struct {
+	term uint64
+	done chan error
+}
+
+// Node 集群中的一个节点，写入通过 raft 日志复制到多数节点之后生效，读取默认只在 leader 上进行
+type Node struct {
+	config Config
+	mu     sync.Mutex
+	cond   *sync.Cond /* 日志应用、角色变化时通知等待者 */
+
+	db  *bitcaskkv.DB /* 状态机 */
+	log *raftLog
+
+	/* raft 状态 */
+	state       int
+	currentTerm uint64
+	votedFor    string
+	leaderID    string
+	commitIndex uint64
+	lastApplied uint64
+
+	/* leader 状态 */
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	triggers   map[string]chan struct{} /* 通知复制协程立即发送日志 */
+
+	proposals       map[uint64]*proposal /* 等待应用的写入，按照日志 index 索引 */
+	lastContact     time.Time            /* 最近一次收到 leader 消息或者投票的时间 */
+	electionTimeout time.Duration        /* 本轮随机的选举超时时间 */
+
+	closed  bool
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Open 打开节点，从快照和日志中恢复状态并开始参与选举
+func Open(config Config) (*Node, error) {
+
+	var self bool
+	for _, peer := range config.Peers {
+		if peer == config.ID {
+			self = true
+		}
+	}
+	if !self {
+		return nil, ErrInvalidPeers
+	}
+	if err := os.MkdirAll(config.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		config:    config,
+		proposals: make(map[uint64]*proposal),
+		closeCh:   make(chan struct{}),
+	}
+	n.cond = sync.NewCond(&n.mu)
+
+	// 状态机从快照恢复，之后由 leader 重新提交快照之后的日志，日志中的写入可以重复应用
+	snapshotIndex, snapshotTerm, err := n.restoreFromSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	opts := config.Options
+	opts.DirPath = filepath.Join(config.Dir, dbDirName)
+	if n.db, err = bitcaskkv.Open(opts); err != nil {
+		return nil, err
+	}
+
+	if n.log, err = openRaftLog(filepath.Join(config.Dir, logDirName), snapshotIndex, snapshotTerm); err != nil {
+		_ = n.db.Close()
+		return nil, err
+	}
+	if n.currentTerm, n.votedFor, err = n.log.loadState(); err != nil {
+		_ = n.log.close()
+		_ = n.db.Close()
+		return nil, err
+	}
+	n.commitIndex, n.lastApplied = snapshotIndex, snapshotIndex
+
+	n.resetElectionTimer()
+	if err := config.Transport.Serve(n); err != nil {
+		_ = n.log.close()
+		_ = n.db.Close()
+		return nil, err
+	}
+
+	n.wg.Add(1)
+	go n.run()
+
+	return n, nil
+}
+
+// Close 停止节点
+func (n *Node) Close() error {
+
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	close(n.closeCh)
+	for index, p := range n.proposals {
+		p.done <- ErrClosed
+		delete(n.proposals, index)
+	}
+	n.cond.Broadcast()
+	n.mu.Unlock()
+
+	_ = n.config.Transport.Close()
+	n.wg.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.log.close(); err != nil {
+		return err
+	}
+	return n.db.Close()
+}
+
+// ID 节点的地址
+func (n *Node) ID() string {
+	return n.config.ID
+}
+
+// IsLeader 节点当前是否为 leader
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state == leader
+}
+
+// Leader 当前已知的 leader 地址，未知时为空
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderID
+}
+
+// Put 写入数据，复制到多数节点并在本节点应用之后返回，只能在 leader 上调用
+func (n *Node) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return n.propose([]op{{typ: opPut, key: key, value: value}})
+}
+
+// Delete 删除数据，只能在 leader 上调用
+func (n *Node) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return n.propose([]op{{typ: opDelete, key: key}})
+}
+
+// Get 读取数据
+// leader 上的读取是线性一致的：确认自己仍然是 leader 并等待读取时刻已提交的日志应用之后再读取；
+// follower 在开启 FollowerReads 时直接读取本地数据，否则返回 ErrNotLeader
+func (n *Node) Get(key []byte) ([]byte, error) {
+
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	n.mu.Lock()
+	if n.state != leader {
+		defer n.mu.Unlock()
+		if !n.config.FollowerReads {
+			return nil, ErrNotLeader
+		}
+		return n.db.Get(key)
+	}
+	n.mu.Unlock()
+
+	if err := n.readIndex(); err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.db.Get(key)
+}
+
+// WriteBatch 原子地执行一组写入，作为一条日志复制
+type WriteBatch struct {
+	node *Node
+	ops  []op
+}
+
+// NewWriteBatch 创建原子写
+func (n *Node) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{node: n}
+}
+
+// Put 暂存写入
+func (wb *WriteBatch) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.ops = append(wb.ops, op{typ: opPut, key: key, value: value})
+	return nil
+}
+
+// Delete 暂存删除
+func (wb *WriteBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.ops = append(wb.ops, op{typ: opDelete, key: key})
+	return nil
+}
+
+// Commit 提交暂存的写入，只能在 leader 上调用
+func (wb *WriteBatch) Commit() error {
+	if len(wb.ops) == 0 {
+		return nil
+	}
+	err := wb.node.propose(wb.ops)
+	wb.ops = nil
+	return err
+}
+
+// propose 追加一条日志并等待其应用
+func (n *Node) propose(ops []op) error {
+
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return ErrClosed
+	}
+	if n.state != leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+
+	entry := LogEntry{Index: n.log.lastIndex() + 1, Term: n.currentTerm, Command: encodeCommand(ops)}
+	if err := n.log.append([]LogEntry{entry}); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	p := &proposal{term: entry.Term, done: make(chan error, 1)}
+	n.proposals[entry.Index] = p
+	n.matchIndex[n.config.ID] = entry.Index
+	n.advanceCommitIndex()
+	n.triggerReplication()
+	n.mu.Unlock()
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-time.After(n.config.ApplyTimeout):
+		n.mu.Lock()
+		delete(n.proposals, entry.Index)
+		n.mu.Unlock()
+		return ErrTimeout
+	}
+}
+
+// resetElectionTimer 重新计时并随机选举超时时间（需要持有 n.mu）
+func (n *Node) resetElectionTimer() {
+	n.lastContact = time.Now()
+	n.electionTimeout = n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+}
+
+// quorum 多数派的节点数量
+func (n *Node) quorum() int {
+	return len(n.config.Peers)/2 + 1
+}
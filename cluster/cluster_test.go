@@ -0,0 +1,262 @@
+package cluster
+
+import (
+	"bitcask-go/utils"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testConfig 测试使用的配置，缩短选举超时以加快测试
+func testConfig(id string, peers []string, transport Transport) Config {
+	config := DefaultConfig
+	config.ID = id
+	config.Peers = peers
+	config.Transport = transport
+	config.ElectionTimeout = 150 * time.Millisecond
+	config.HeartbeatInterval = 30 * time.Millisecond
+	config.Dir, _ = os.MkdirTemp("", "bitcask-go-cluster-"+id)
+	return config
+}
+
+// startInmemCluster 在进程内启动一个集群
+func startInmemCluster(t *testing.T, size int, configure func(config *Config)) (*InmemNetwork, []*Node, []Config) {
+
+	network := NewInmemNetwork()
+	var peers []string
+	for i := 0; i < size; i++ {
+		peers = append(peers, fmt.Sprintf("node-%d", i))
+	}
+
+	var nodes []*Node
+	var configs []Config
+	for _, peer := range peers {
+		config := testConfig(peer, peers, network.Transport(peer))
+		if configure != nil {
+			configure(&config)
+		}
+		node, err := Open(config)
+		assert.Nil(t, err)
+		nodes = append(nodes, node)
+		configs = append(configs, config)
+	}
+	return network, nodes, configs
+}
+
+// destroyCluster 关闭所有节点并删除数据目录
+func destroyCluster(nodes []*Node, configs []Config) {
+	for _, node := range nodes {
+		_ = node.Close()
+	}
+	for _, config := range configs {
+		_ = os.RemoveAll(config.Dir)
+	}
+}
+
+// waitForLeader 等待选出 leader，exclude 中的节点不参与
+func waitForLeader(t *testing.T, nodes []*Node, exclude ...*Node) *Node {
+	var leader *Node
+	assert.Eventually(t, func() bool {
+		for _, node := range nodes {
+			excluded := false
+			for _, e := range exclude {
+				excluded = excluded || e == node
+			}
+			if !excluded && node.IsLeader() {
+				leader = node
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+func TestCluster_Replication(t *testing.T) {
+
+	_, nodes, configs := startInmemCluster(t, 3, func(config *Config) {
+		config.FollowerReads = config.ID != "node-0"
+	})
+	defer destroyCluster(nodes, configs)
+
+	leader := waitForLeader(t, nodes)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestValue(32)))
+	}
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, leader.Delete(utils.GetTestKey(i)))
+	}
+	wb := leader.NewWriteBatch()
+	assert.Nil(t, wb.Put([]byte("batch-1"), []byte("value-1")))
+	assert.Nil(t, wb.Put([]byte("batch-2"), []byte("value-2")))
+	assert.Nil(t, wb.Delete([]byte("batch-2")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(50)))
+	assert.Nil(t, wb.Commit())
+
+	// leader 上的读取是线性一致的
+	value, err := leader.Get([]byte("batch-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), value)
+	_, err = leader.Get([]byte("batch-2"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = leader.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// follower 只能写入 leader
+	for _, node := range nodes {
+		if node == leader {
+			continue
+		}
+		assert.Equal(t, ErrNotLeader, node.Put([]byte("key"), []byte("value")))
+		assert.Equal(t, leader.ID(), node.Leader())
+
+		// 开启 FollowerReads 的节点最终读到相同的数据
+		if !node.config.FollowerReads {
+			_, err := node.Get([]byte("batch-1"))
+			assert.Equal(t, ErrNotLeader, err)
+			continue
+		}
+		assert.Eventually(t, func() bool {
+			value, err := node.Get(utils.GetTestKey(99))
+			return err == nil && len(value) > 0
+		}, 5*time.Second, 10*time.Millisecond)
+		_, err := node.Get(utils.GetTestKey(5))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+}
+
+func TestCluster_LeaderFailover(t *testing.T) {
+
+	network, nodes, configs := startInmemCluster(t, 3, func(config *Config) {
+		config.FollowerReads = true
+		config.ApplyTimeout = time.Second
+	})
+	defer destroyCluster(nodes, configs)
+
+	leader := waitForLeader(t, nodes)
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), []byte("before")))
+	}
+
+	// 隔离 leader，剩余的节点选出新的 leader
+	network.Disconnect(leader.ID())
+	newLeader := waitForLeader(t, nodes, leader)
+	assert.NotEqual(t, leader, newLeader)
+
+	// 被隔离的 leader 无法提交写入
+	assert.NotNil(t, leader.Put([]byte("lost"), []byte("value")))
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, newLeader.Put(utils.GetTestKey(i), []byte("after")))
+	}
+	value, err := newLeader.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after"), value)
+
+	// 恢复连接之后旧的 leader 成为 follower 并追上新的数据，没有提交的写入被丢弃
+	network.Reconnect(leader.ID())
+	assert.Eventually(t, func() bool {
+		value, err := leader.Get(utils.GetTestKey(49))
+		return !leader.IsLeader() && err == nil && string(value) == "after"
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = leader.Get([]byte("lost"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestCluster_Snapshot(t *testing.T) {
+
+	network, nodes, configs := startInmemCluster(t, 3, func(config *Config) {
+		config.FollowerReads = true
+		config.SnapshotThreshold = 20
+	})
+	defer func() {
+		destroyCluster(nodes, configs)
+	}()
+
+	leader := waitForLeader(t, nodes)
+	var lagging *Node
+	for _, node := range nodes {
+		if node != leader {
+			lagging = node
+			break
+		}
+	}
+
+	// 落后的节点需要的日志已经被快照丢弃，通过安装快照追上
+	network.Disconnect(lagging.ID())
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestValue(32)))
+	}
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, leader.Delete(utils.GetTestKey(i)))
+	}
+	leader.mu.Lock()
+	assert.Greater(t, leader.log.snapshotIndex, uint64(0))
+	leader.mu.Unlock()
+
+	network.Reconnect(lagging.ID())
+	assert.Eventually(t, func() bool {
+		_, err := lagging.Get(utils.GetTestKey(99))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err := lagging.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	lagging.mu.Lock()
+	assert.Greater(t, lagging.log.snapshotIndex, uint64(0))
+	lagging.mu.Unlock()
+
+	// 重启之后从快照和日志中恢复
+	for i, node := range nodes {
+		if node != lagging {
+			continue
+		}
+		assert.Nil(t, node.Close())
+		reopened, err := Open(configs[i])
+		assert.Nil(t, err)
+		nodes[i] = reopened
+		assert.Eventually(t, func() bool {
+			value, err := reopened.Get(utils.GetTestKey(99))
+			return err == nil && len(value) > 0
+		}, 5*time.Second, 10*time.Millisecond)
+		_, err = reopened.Get(utils.GetTestKey(10))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+}
+
+func TestCluster_TCPTransport(t *testing.T) {
+
+	var transports []*TCPTransport
+	var peers []string
+	for i := 0; i < 3; i++ {
+		transport, err := NewTCPTransport("127.0.0.1:0")
+		assert.Nil(t, err)
+		transports = append(transports, transport)
+		peers = append(peers, transport.Addr())
+	}
+
+	var nodes []*Node
+	var configs []Config
+	for i, transport := range transports {
+		config := testConfig(fmt.Sprintf("tcp-%d", i), peers, transport)
+		config.ID = transport.Addr()
+		node, err := Open(config)
+		assert.Nil(t, err)
+		nodes = append(nodes, node)
+		configs = append(configs, config)
+	}
+	defer destroyCluster(nodes, configs)
+
+	leader := waitForLeader(t, nodes)
+	wb := leader.NewWriteBatch()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestValue(16)))
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 0; i < 10; i++ {
+		_, err := leader.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
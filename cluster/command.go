@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// 日志中一次写入操作的类型
+const (
+	opPut    byte = iota + 1 /* 写入 */
+	opDelete                 /* 删除 */
+)
+
+// op 日志中的一次写入操作，一条日志包含一个或者多个操作，多个操作原子地生效
+type op struct {
+	typ   byte
+	key   []byte
+	value []byte
+}
+
+// errInvalidCommand 日志中的命令无法解析
+var errInvalidCommand = errors.New("invalid command in raft log")
+
+/*
+命令编码：count(uvarint) | op ...
+
+op: type(1) | keySize(uvarint) | key | valueSize(uvarint) | value
+
+空命令表示 leader 当选之后追加的空日志，不修改数据
+*/
+
+// encodeCommand 编码一组写入操作
+func encodeCommand(ops []op) []byte {
+
+	size := binary.MaxVarintLen64
+	for _, o := range ops {
+		size += 1 + 2*binary.MaxVarintLen64 + len(o.key) + len(o.value)
+	}
+
+	buf := make([]byte, size)
+	index := binary.PutUvarint(buf, uint64(len(ops)))
+	for _, o := range ops {
+		buf[index] = o.typ
+		index++
+		index += binary.PutUvarint(buf[index:], uint64(len(o.key)))
+		index += copy(buf[index:], o.key)
+		index += binary.PutUvarint(buf[index:], uint64(len(o.value)))
+		index += copy(buf[index:], o.value)
+	}
+	return buf[:index]
+}
+
+// decodeCommand 解析一组写入操作
+func decodeCommand(buf []byte) ([]op, error) {
+
+	if len(buf) == 0 {
+		return nil, nil
+	}
+
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, errInvalidCommand
+	}
+	index := n
+
+	readBytes := func() ([]byte, error) {
+		size, n := binary.Uvarint(buf[index:])
+		if n <= 0 || uint64(len(buf)-index-n) < size {
+			return nil, errInvalidCommand
+		}
+		index += n
+		b := buf[index : index+int(size)]
+		index += int(size)
+		return b, nil
+	}
+
+	ops := make([]op, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if index >= len(buf) {
+			return nil, errInvalidCommand
+		}
+		o := op{typ: buf[index]}
+		index++
+		var err error
+		if o.key, err = readBytes(); err != nil {
+			return nil, err
+		}
+		if o.value, err = readBytes(); err != nil {
+			return nil, err
+		}
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
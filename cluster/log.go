@@ -0,0 +1,238 @@
+package cluster
+
+import (
+	bitcaskkv "bitcask-go"
+	"encoding/binary"
+	"strconv"
+)
+
+// 日志存储中的 key
+var (
+	entryKeyPrefix = []byte("e/")
+	termKey        = []byte("m/term")
+	voteKey        = []byte("m/vote")
+)
+
+// LogEntry raft 日志中的一条数据
+type LogEntry struct {
+	Index   uint64
+	Term    uint64
+	Command []byte /* 编码之后的写入操作，为空表示 leader 当选时追加的空日志 */
+}
+
+// raftLog 持久化的 raft 日志，存储在一个单独的 bitcask 实例中，内存中缓存快照之后的所有日志
+type raftLog struct {
+	db            *bitcaskkv.DB
+	entries       []LogEntry /* 快照之后的日志，entries[i].Index == snapshotIndex+1+i */
+	snapshotIndex uint64     /* 快照包含的最后一条日志 */
+	snapshotTerm  uint64
+}
+
+// openRaftLog 打开日志存储，丢弃已经包含在快照中的日志
+func openRaftLog(dirPath string, snapshotIndex, snapshotTerm uint64) (*raftLog, error) {
+
+	opts := bitcaskkv.DefaultOptions
+	opts.DirPath = dirPath
+	opts.SyncWrites = true
+	opts.DataFileSize = 64 * 1024 * 1024
+	db, err := bitcaskkv.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &raftLog{db: db, snapshotIndex: snapshotIndex, snapshotTerm: snapshotTerm}
+
+	iterOpts := bitcaskkv.DefaultIteratorOptions
+	iterOpts.Prefix = entryKeyPrefix
+	iterator := db.NewIterator(iterOpts)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		index := binary.BigEndian.Uint64(iterator.Key()[len(entryKeyPrefix):])
+		if index <= snapshotIndex {
+			continue
+		}
+		// 日志不连续说明之后的日志是截断时没有删除干净的数据
+		if index != l.lastIndex()+1 {
+			break
+		}
+		value, err := iterator.Value()
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		l.entries = append(l.entries, LogEntry{
+			Index:   index,
+			Term:    binary.BigEndian.Uint64(value),
+			Command: value[8:],
+		})
+	}
+
+	return l, nil
+}
+
+// close 关闭日志存储
+func (l *raftLog) close() error {
+	return l.db.Close()
+}
+
+// lastIndex 最后一条日志的 index
+func (l *raftLog) lastIndex() uint64 {
+	return l.snapshotIndex + uint64(len(l.entries))
+}
+
+// lastTerm 最后一条日志的任期
+func (l *raftLog) lastTerm() uint64 {
+	if len(l.entries) == 0 {
+		return l.snapshotTerm
+	}
+	return l.entries[len(l.entries)-1].Term
+}
+
+// term 获取 index 对应日志的任期，日志不存在或者已经被快照丢弃时返回 false
+func (l *raftLog) term(index uint64) (uint64, bool) {
+	if index == l.snapshotIndex {
+		return l.snapshotTerm, true
+	}
+	if index < l.snapshotIndex || index > l.lastIndex() {
+		return 0, false
+	}
+	return l.entries[index-l.snapshotIndex-1].Term, true
+}
+
+// entry 获取 index 对应的日志，调用方保证日志存在
+func (l *raftLog) entry(index uint64) LogEntry {
+	return l.entries[index-l.snapshotIndex-1]
+}
+
+// slice 获取 [from, to) 范围内的日志
+func (l *raftLog) slice(from, to uint64) []LogEntry {
+	if from > to {
+		return nil
+	}
+	entries := make([]LogEntry, to-from)
+	copy(entries, l.entries[from-l.snapshotIndex-1:to-l.snapshotIndex-1])
+	return entries
+}
+
+// append 在日志末尾追加并持久化日志
+func (l *raftLog) append(entries []LogEntry) error {
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	wb := l.db.NewWriteBatch(bitcaskkv.WriteBatchOptions{MaxBatchNum: uint(len(entries)), SyncWrites: true})
+	for _, entry := range entries {
+		value := make([]byte, 8+len(entry.Command))
+		binary.BigEndian.PutUint64(value, entry.Term)
+		copy(value[8:], entry.Command)
+		if err := wb.Put(entryKey(entry.Index), value); err != nil {
+			return err
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+
+	l.entries = append(l.entries, entries...)
+	return nil
+}
+
+// truncate 删除 index 以及之后的日志
+func (l *raftLog) truncate(index uint64) error {
+
+	// 快照中的日志已经提交，不会被截断
+	if index <= l.snapshotIndex {
+		index = l.snapshotIndex + 1
+	}
+	if err := l.deleteEntries(index, l.lastIndex()+1); err != nil {
+		return err
+	}
+	l.entries = l.entries[:index-l.snapshotIndex-1]
+	return nil
+}
+
+// compact 丢弃已经包含在快照中的日志，index 之后的日志保持不变
+func (l *raftLog) compact(index, term uint64) error {
+
+	var remain []LogEntry
+	if t, ok := l.term(index); ok && t == term && index <= l.lastIndex() {
+		remain = l.slice(index+1, l.lastIndex()+1)
+	}
+
+	// 与快照冲突的日志全部丢弃
+	end := index + 1
+	if remain == nil {
+		end = l.lastIndex() + 1
+	}
+	if err := l.deleteEntries(l.snapshotIndex+1, end); err != nil {
+		return err
+	}
+
+	l.entries = remain
+	l.snapshotIndex, l.snapshotTerm = index, term
+
+	// 回收被删除的日志占用的空间
+	if err := l.db.Merge(); err != nil && err != bitcaskkv.ErrMergeRatioUnreached {
+		return err
+	}
+	return nil
+}
+
+// deleteEntries 删除 [from, to) 范围内持久化的日志
+func (l *raftLog) deleteEntries(from, to uint64) error {
+	if from >= to {
+		return nil
+	}
+	wb := l.db.NewWriteBatch(bitcaskkv.WriteBatchOptions{MaxBatchNum: uint(to - from), SyncWrites: true})
+	for i := from; i < to; i++ {
+		if err := wb.Delete(entryKey(i)); err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
+// loadState 读取持久化的任期和投票
+func (l *raftLog) loadState() (uint64, string, error) {
+
+	var term uint64
+	value, err := l.db.Get(termKey)
+	if err == nil {
+		if term, err = strconv.ParseUint(string(value), 10, 64); err != nil {
+			return 0, "", err
+		}
+	} else if err != bitcaskkv.ErrKeyNotFound {
+		return 0, "", err
+	}
+
+	vote, err := l.db.Get(voteKey)
+	if err != nil && err != bitcaskkv.ErrKeyNotFound {
+		return 0, "", err
+	}
+	return term, string(vote), nil
+}
+
+// saveState 持久化任期和投票，在响应请求之前调用
+func (l *raftLog) saveState(term uint64, vote string) error {
+	wb := l.db.NewWriteBatch(bitcaskkv.DefaultWriteBatchOptions)
+	if err := wb.Put(termKey, []byte(strconv.FormatUint(term, 10))); err != nil {
+		return err
+	}
+	if vote == "" {
+		if err := wb.Delete(voteKey); err != nil {
+			return err
+		}
+	} else if err := wb.Put(voteKey, []byte(vote)); err != nil {
+		return err
+	}
+	return wb.Commit()
+}
+
+// entryKey 日志在存储中的 key，按照 index 有序
+func entryKey(index uint64) []byte {
+	key := make([]byte, len(entryKeyPrefix)+8)
+	copy(key, entryKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(entryKeyPrefix):], index)
+	return key
+}
@@ -0,0 +1,543 @@
+package cluster
+
+import (
+	bitcaskkv "bitcask-go"
+	"sort"
+	"time"
+)
+
+// tickInterval 检查选举超时的间隔
+const tickInterval = 10 * time.Millisecond
+
+// run 在选举超时之后发起选举
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.closeCh:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if n.state != leader && time.Since(n.lastContact) >= n.electionTimeout {
+			n.startElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// startElection 成为候选人并向其他节点请求投票（需要持有 n.mu）
+func (n *Node) startElection() {
+
+	n.state = candidate
+	n.currentTerm++
+	n.votedFor = n.config.ID
+	n.leaderID = ""
+	if err := n.log.saveState(n.currentTerm, n.votedFor); err != nil {
+		return
+	}
+	n.resetElectionTimer()
+
+	term := n.currentTerm
+	args := &RequestVoteArgs{
+		Term:         term,
+		CandidateID:  n.config.ID,
+		LastLogIndex: n.log.lastIndex(),
+		LastLogTerm:  n.log.lastTerm(),
+	}
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	for _, peer := range n.config.Peers {
+		if peer == n.config.ID {
+			continue
+		}
+		go func(peer string) {
+			reply := &RequestVoteReply{}
+			if err := n.config.Transport.RequestVote(peer, args, reply); err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.closed {
+				return
+			}
+			if reply.Term > n.currentTerm {
+				n.stepDown(reply.Term)
+				return
+			}
+			if n.state != candidate || n.currentTerm != term || !reply.VoteGranted {
+				return
+			}
+			if votes++; votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader 成为 leader，追加一条空日志以提交之前任期的日志（需要持有 n.mu）
+func (n *Node) becomeLeader() {
+
+	n.state = leader
+	n.leaderID = n.config.ID
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.triggers = make(map[string]chan struct{})
+
+	entry := LogEntry{Index: n.log.lastIndex() + 1, Term: n.currentTerm}
+	if err := n.log.append([]LogEntry{entry}); err != nil {
+		n.stepDown(n.currentTerm)
+		return
+	}
+	n.matchIndex[n.config.ID] = entry.Index
+
+	for _, peer := range n.config.Peers {
+		if peer == n.config.ID {
+			continue
+		}
+		n.nextIndex[peer] = entry.Index
+		n.matchIndex[peer] = 0
+		trigger := make(chan struct{}, 1)
+		n.triggers[peer] = trigger
+		n.wg.Add(1)
+		go n.replicate(peer, n.currentTerm, trigger)
+	}
+	n.advanceCommitIndex()
+	n.triggerReplication()
+	n.cond.Broadcast()
+}
+
+// stepDown 成为 follower，term 大于当前任期时更新任期并清空投票（需要持有 n.mu）
+func (n *Node) stepDown(term uint64) {
+	if term > n.currentTerm {
+		n.currentTerm = term
+		n.votedFor = ""
+		n.leaderID = ""
+		_ = n.log.saveState(n.currentTerm, n.votedFor)
+	}
+	if n.state == leader {
+		n.resetElectionTimer()
+	}
+	n.state = follower
+	n.cond.Broadcast()
+}
+
+// triggerReplication 通知所有的复制协程立即发送日志（需要持有 n.mu）
+func (n *Node) triggerReplication() {
+	for _, trigger := range n.triggers {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// replicate leader 向一个节点复制日志，空闲时定期发送心跳，不再是 term 任期的 leader 时退出
+func (n *Node) replicate(peer string, term uint64, trigger chan struct{}) {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.closeCh:
+			return
+		case <-trigger:
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if n.state != leader || n.currentTerm != term {
+			n.mu.Unlock()
+			return
+		}
+
+		// 需要的日志已经被快照丢弃，发送快照
+		if n.nextIndex[peer] <= n.log.snapshotIndex {
+			args, err := n.snapshotArgs()
+			n.mu.Unlock()
+			if err != nil {
+				continue
+			}
+			n.sendSnapshot(peer, term, args)
+			continue
+		}
+
+		next := n.nextIndex[peer]
+		prevTerm, _ := n.log.term(next - 1)
+		end := n.log.lastIndex() + 1
+		if max := next + uint64(n.config.MaxAppendEntries); end > max {
+			end = max
+		}
+		args := &AppendEntriesArgs{
+			Term:         term,
+			LeaderID:     n.config.ID,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  prevTerm,
+			Entries:      n.log.slice(next, end),
+			LeaderCommit: n.commitIndex,
+		}
+		n.mu.Unlock()
+
+		reply := &AppendEntriesReply{}
+		if err := n.config.Transport.AppendEntries(peer, args, reply); err != nil {
+			continue
+		}
+
+		n.mu.Lock()
+		if reply.Term > n.currentTerm {
+			n.stepDown(reply.Term)
+			n.mu.Unlock()
+			return
+		}
+		if n.state != leader || n.currentTerm != term {
+			n.mu.Unlock()
+			return
+		}
+		if reply.Success {
+			match := args.PrevLogIndex + uint64(len(args.Entries))
+			if match > n.matchIndex[peer] {
+				n.matchIndex[peer] = match
+			}
+			n.nextIndex[peer] = n.matchIndex[peer] + 1
+			n.advanceCommitIndex()
+		} else if reply.ConflictIndex > 0 && reply.ConflictIndex < n.nextIndex[peer] {
+			n.nextIndex[peer] = reply.ConflictIndex
+		} else if n.nextIndex[peer] > 1 {
+			n.nextIndex[peer]--
+		}
+
+		// 还有没有发送的日志则继续发送
+		if n.nextIndex[peer] <= n.log.lastIndex() {
+			select {
+			case trigger <- struct{}{}:
+			default:
+			}
+		}
+		n.mu.Unlock()
+	}
+}
+
+// sendSnapshot 向落后的节点发送快照
+func (n *Node) sendSnapshot(peer string, term uint64, args *InstallSnapshotArgs) {
+
+	reply := &InstallSnapshotReply{}
+	if err := n.config.Transport.InstallSnapshot(peer, args, reply); err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reply.Term > n.currentTerm {
+		n.stepDown(reply.Term)
+		return
+	}
+	if n.state != leader || n.currentTerm != term {
+		return
+	}
+	if args.LastIncludedIndex > n.matchIndex[peer] {
+		n.matchIndex[peer] = args.LastIncludedIndex
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.advanceCommitIndex()
+}
+
+// advanceCommitIndex 多数节点复制了当前任期的日志之后提交，并应用已经提交的日志（需要持有 n.mu）
+func (n *Node) advanceCommitIndex() {
+
+	matches := make([]uint64, 0, len(n.config.Peers))
+	for _, peer := range n.config.Peers {
+		matches = append(matches, n.matchIndex[peer])
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i] > matches[j]
+	})
+
+	// 只能通过计数提交当前任期的日志
+	index := matches[n.quorum()-1]
+	if term, ok := n.log.term(index); ok && term == n.currentTerm && index > n.commitIndex {
+		n.commitIndex = index
+		n.applyCommitted()
+		n.triggerReplication()
+	}
+}
+
+// applyCommitted 按顺序将已经提交的日志应用到状态机，并通知等待的写入（需要持有 n.mu）
+func (n *Node) applyCommitted() {
+
+	for n.lastApplied < n.commitIndex {
+		index := n.lastApplied + 1
+		entry := n.log.entry(index)
+		err := n.applyEntry(entry)
+		n.lastApplied = index
+
+		if p, ok := n.proposals[index]; ok {
+			delete(n.proposals, index)
+			if p.term != entry.Term {
+				err = ErrLeadershipLost
+			}
+			p.done <- err
+		}
+	}
+	n.cond.Broadcast()
+
+	if n.config.SnapshotThreshold > 0 && n.lastApplied-n.log.snapshotIndex >= n.config.SnapshotThreshold {
+		_ = n.takeSnapshot()
+	}
+}
+
+// applyEntry 将一条日志应用到状态机，多个写入以原子写的方式生效
+func (n *Node) applyEntry(entry LogEntry) error {
+
+	ops, err := decodeCommand(entry.Command)
+	if err != nil || len(ops) == 0 {
+		return err
+	}
+
+	if len(ops) == 1 {
+		if ops[0].typ == opDelete {
+			return n.db.Delete(ops[0].key)
+		}
+		return n.db.Put(ops[0].key, ops[0].value)
+	}
+
+	// 同一个 key 只保留最后一次写入
+	final := make(map[string]op, len(ops))
+	for _, o := range ops {
+		final[string(o.key)] = o
+	}
+	wb := n.db.NewWriteBatch(bitcaskkv.WriteBatchOptions{MaxBatchNum: uint(len(final)), SyncWrites: false})
+	for _, o := range final {
+		if o.typ == opDelete {
+			err = wb.Delete(o.key)
+		} else {
+			err = wb.Put(o.key, o.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
+// readIndex 确认自己仍然是 leader，并等待确认时刻已经提交的日志全部应用
+func (n *Node) readIndex() error {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// 当前任期的空日志提交之前，commitIndex 可能落后于之前的 leader 已经提交的日志
+	err := n.waitLocked(func() bool {
+		term, _ := n.log.term(n.commitIndex)
+		return n.state != leader || term == n.currentTerm
+	})
+	if err != nil {
+		return err
+	}
+	if n.state != leader {
+		return ErrNotLeader
+	}
+	readIndex, term := n.commitIndex, n.currentTerm
+
+	// 多数节点仍然认可当前任期
+	n.mu.Unlock()
+	ok := n.confirmLeadership(term)
+	n.mu.Lock()
+	if !ok {
+		return ErrNotLeader
+	}
+
+	return n.waitLocked(func() bool {
+		return n.lastApplied >= readIndex
+	})
+}
+
+// confirmLeadership 向所有节点发送心跳，多数节点回复相同的任期时说明仍然是 leader
+func (n *Node) confirmLeadership(term uint64) bool {
+
+	n.mu.Lock()
+	requests := make(map[string]*AppendEntriesArgs)
+	for _, peer := range n.config.Peers {
+		if peer == n.config.ID {
+			continue
+		}
+		prevIndex := n.nextIndex[peer] - 1
+		prevTerm, ok := n.log.term(prevIndex)
+		if !ok {
+			prevIndex, prevTerm = n.log.snapshotIndex, n.log.snapshotTerm
+		}
+		requests[peer] = &AppendEntriesArgs{
+			Term:         term,
+			LeaderID:     n.config.ID,
+			PrevLogIndex: prevIndex,
+			PrevLogTerm:  prevTerm,
+			LeaderCommit: n.commitIndex,
+		}
+	}
+	n.mu.Unlock()
+
+	acks := make(chan bool, len(requests))
+	for peer, args := range requests {
+		go func(peer string, args *AppendEntriesArgs) {
+			reply := &AppendEntriesReply{}
+			err := n.config.Transport.AppendEntries(peer, args, reply)
+			if err == nil && reply.Term > term {
+				n.mu.Lock()
+				if !n.closed && reply.Term > n.currentTerm {
+					n.stepDown(reply.Term)
+				}
+				n.mu.Unlock()
+			}
+			acks <- err == nil && reply.Term == term
+		}(peer, args)
+	}
+
+	votes := 1
+	for i := 0; i < len(requests) && votes < n.quorum(); i++ {
+		if <-acks {
+			votes++
+		}
+	}
+	return votes >= n.quorum()
+}
+
+// waitLocked 等待 cond 成立，超时或者节点关闭时返回错误（需要持有 n.mu）
+func (n *Node) waitLocked(cond func() bool) error {
+
+	var timedOut bool
+	timer := time.AfterFunc(n.config.ApplyTimeout, func() {
+		n.mu.Lock()
+		timedOut = true
+		n.cond.Broadcast()
+		n.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	for !cond() {
+		if n.closed {
+			return ErrClosed
+		}
+		if timedOut {
+			return ErrTimeout
+		}
+		n.cond.Wait()
+	}
+	return nil
+}
+
+// handleRequestVote 处理投票请求
+func (n *Node) handleRequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return ErrClosed
+	}
+	if args.Term > n.currentTerm {
+		n.stepDown(args.Term)
+	}
+	reply.Term = n.currentTerm
+	if args.Term < n.currentTerm {
+		return nil
+	}
+
+	// 候选人的日志至少和自己一样新才投票
+	upToDate := args.LastLogTerm > n.log.lastTerm() ||
+		(args.LastLogTerm == n.log.lastTerm() && args.LastLogIndex >= n.log.lastIndex())
+	if (n.votedFor == "" || n.votedFor == args.CandidateID) && upToDate {
+		if n.votedFor != args.CandidateID {
+			n.votedFor = args.CandidateID
+			if err := n.log.saveState(n.currentTerm, n.votedFor); err != nil {
+				return err
+			}
+		}
+		reply.VoteGranted = true
+		n.resetElectionTimer()
+	}
+	return nil
+}
+
+// handleAppendEntries 处理日志复制和心跳
+func (n *Node) handleAppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return ErrClosed
+	}
+	if args.Term > n.currentTerm || (args.Term == n.currentTerm && n.state != follower) {
+		n.stepDown(args.Term)
+	}
+	reply.Term = n.currentTerm
+	if args.Term < n.currentTerm {
+		return nil
+	}
+	n.leaderID = args.LeaderID
+	n.resetElectionTimer()
+
+	// 检查前一条日志是否匹配
+	if args.PrevLogIndex > n.log.lastIndex() {
+		reply.ConflictIndex = n.log.lastIndex() + 1
+		return nil
+	}
+	if args.PrevLogIndex < n.log.snapshotIndex {
+		reply.ConflictIndex = n.log.snapshotIndex + 1
+		return nil
+	}
+	if term, _ := n.log.term(args.PrevLogIndex); term != args.PrevLogTerm {
+		// 跳过冲突任期的所有日志
+		index := args.PrevLogIndex
+		for index > n.log.snapshotIndex+1 {
+			if t, _ := n.log.term(index - 1); t != term {
+				break
+			}
+			index--
+		}
+		reply.ConflictIndex = index
+		return nil
+	}
+
+	// 删除冲突的日志并追加新的日志
+	for i, entry := range args.Entries {
+		if entry.Index > n.log.lastIndex() {
+			if err := n.log.append(args.Entries[i:]); err != nil {
+				return err
+			}
+			break
+		}
+		if term, _ := n.log.term(entry.Index); term != entry.Term {
+			if err := n.log.truncate(entry.Index); err != nil {
+				return err
+			}
+			if err := n.log.append(args.Entries[i:]); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	reply.Success = true
+
+	lastNew := args.PrevLogIndex + uint64(len(args.Entries))
+	if args.LeaderCommit > n.commitIndex {
+		commitIndex := args.LeaderCommit
+		if lastNew < commitIndex {
+			commitIndex = lastNew
+		}
+		if commitIndex > n.commitIndex {
+			n.commitIndex = commitIndex
+			n.applyCommitted()
+		}
+	}
+	return nil
+}
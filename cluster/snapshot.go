@@ -0,0 +1,255 @@
+package cluster
+
+import (
+	bitcaskkv "bitcask-go"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// snapshotMetaFileName 快照目录中记录快照包含的最后一条日志的文件
+const snapshotMetaFileName = "raft-snapshot"
+
+/*
+快照是状态机的一次热备份（bitcaskkv.Backup），不可变的数据文件以硬链接的方式共享，
+快照目录中额外记录快照包含的最后一条日志的 index 和任期
+
+替换快照时先将旧快照移动到 snapshot.old，新快照移动到 snapshot 之后再删除旧快照，
+任意时刻崩溃之后都可以找到一个完整的快照
+*/
+
+// restoreFromSnapshot 启动时从快照重建状态机，返回快照包含的最后一条日志
+func (n *Node) restoreFromSnapshot() (uint64, uint64, error) {
+
+	snapshotDir := filepath.Join(n.config.Dir, snapshotDirName)
+
+	// 清理没有完成的快照，替换快照时崩溃则恢复旧的快照
+	for _, suffix := range []string{".tmp", ".recv"} {
+		if err := os.RemoveAll(snapshotDir + suffix); err != nil {
+			return 0, 0, err
+		}
+	}
+	if _, err := os.Stat(snapshotDir); os.IsNotExist(err) {
+		if _, err := os.Stat(snapshotDir + ".old"); err == nil {
+			if err := os.Rename(snapshotDir+".old", snapshotDir); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if err := os.RemoveAll(snapshotDir + ".old"); err != nil {
+		return 0, 0, err
+	}
+
+	index, term, err := readSnapshotMeta(snapshotDir)
+	if err != nil || index == 0 {
+		return 0, 0, err
+	}
+	if err := n.restoreDB(snapshotDir); err != nil {
+		return 0, 0, err
+	}
+	return index, term, nil
+}
+
+// restoreDB 使用快照中的数据替换状态机的数据目录，调用方保证状态机已经关闭
+func (n *Node) restoreDB(snapshotDir string) error {
+	dbDir := filepath.Join(n.config.Dir, dbDirName)
+	if err := os.RemoveAll(dbDir); err != nil {
+		return err
+	}
+	return bitcaskkv.Restore(filepath.Join(snapshotDir, bitcaskkv.BackupManifestName), dbDir)
+}
+
+// takeSnapshot 对已经应用的状态生成快照，并丢弃快照包含的日志（需要持有 n.mu）
+func (n *Node) takeSnapshot() error {
+
+	index := n.lastApplied
+	term, ok := n.log.term(index)
+	if !ok || index <= n.log.snapshotIndex {
+		return nil
+	}
+
+	snapshotDir := filepath.Join(n.config.Dir, snapshotDirName)
+	tmpDir := snapshotDir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := n.db.Backup(tmpDir); err != nil {
+		return err
+	}
+	if err := writeSnapshotMeta(tmpDir, index, term); err != nil {
+		return err
+	}
+	if err := replaceSnapshot(snapshotDir, tmpDir); err != nil {
+		return err
+	}
+
+	return n.log.compact(index, term)
+}
+
+// Snapshot 立即对已经应用的状态生成快照
+func (n *Node) Snapshot() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrClosed
+	}
+	return n.takeSnapshot()
+}
+
+// snapshotArgs 读取当前的快照，用于发送给落后的节点（需要持有 n.mu）
+func (n *Node) snapshotArgs() (*InstallSnapshotArgs, error) {
+
+	snapshotDir := filepath.Join(n.config.Dir, snapshotDirName)
+	entries, err := os.ReadDir(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	args := &InstallSnapshotArgs{
+		Term:              n.currentTerm,
+		LeaderID:          n.config.ID,
+		LastIncludedIndex: n.log.snapshotIndex,
+		LastIncludedTerm:  n.log.snapshotTerm,
+	}
+	for _, entry := range entries {
+		buf, err := os.ReadFile(filepath.Join(snapshotDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		args.Files = append(args.Files, SnapshotFile{Name: entry.Name(), Data: buf})
+	}
+	return args, nil
+}
+
+// handleInstallSnapshot 安装 leader 发送的快照，替换状态机中的所有数据
+func (n *Node) handleInstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return ErrClosed
+	}
+	if args.Term > n.currentTerm || (args.Term == n.currentTerm && n.state != follower) {
+		n.stepDown(args.Term)
+	}
+	reply.Term = n.currentTerm
+	if args.Term < n.currentTerm {
+		return nil
+	}
+	n.leaderID = args.LeaderID
+	n.resetElectionTimer()
+
+	// 已经应用了快照中的所有数据
+	if args.LastIncludedIndex <= n.lastApplied {
+		return nil
+	}
+
+	// 先完整地接收快照
+	snapshotDir := filepath.Join(n.config.Dir, snapshotDirName)
+	recvDir := snapshotDir + ".recv"
+	if err := os.RemoveAll(recvDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(recvDir, os.ModePerm); err != nil {
+		return err
+	}
+	for _, file := range args.Files {
+		if filepath.Base(file.Name) != file.Name {
+			return ErrInvalidSnapshot
+		}
+		if err := writeFileSync(filepath.Join(recvDir, file.Name), file.Data); err != nil {
+			return err
+		}
+	}
+	index, term, err := readSnapshotMeta(recvDir)
+	if err != nil {
+		return err
+	}
+	if index != args.LastIncludedIndex || term != args.LastIncludedTerm {
+		return ErrInvalidSnapshot
+	}
+
+	// 替换快照之后即使崩溃，启动时也会从新的快照重建状态机
+	if err := replaceSnapshot(snapshotDir, recvDir); err != nil {
+		return err
+	}
+
+	// 重建状态机
+	if err := n.db.Close(); err != nil {
+		return err
+	}
+	if err := n.restoreDB(snapshotDir); err != nil {
+		return err
+	}
+	opts := n.config.Options
+	opts.DirPath = filepath.Join(n.config.Dir, dbDirName)
+	if n.db, err = bitcaskkv.Open(opts); err != nil {
+		return err
+	}
+
+	// 保留快照之后仍然匹配的日志
+	if err := n.log.compact(index, term); err != nil {
+		return err
+	}
+	n.lastApplied = index
+	if n.commitIndex < index {
+		n.commitIndex = index
+	}
+	n.applyCommitted()
+	return nil
+}
+
+// replaceSnapshot 使用 newDir 中的快照替换当前的快照
+func replaceSnapshot(snapshotDir, newDir string) error {
+
+	oldDir := snapshotDir + ".old"
+	if err := os.RemoveAll(oldDir); err != nil {
+		return err
+	}
+	if err := os.Rename(snapshotDir, oldDir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(newDir, snapshotDir); err != nil {
+		return err
+	}
+	return os.RemoveAll(oldDir)
+}
+
+// readSnapshotMeta 读取快照包含的最后一条日志，快照不存在时返回 0
+func readSnapshotMeta(dir string) (uint64, uint64, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, snapshotMetaFileName))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var index, term uint64
+	if _, err := fmt.Sscanf(string(buf), "%d %d", &index, &term); err != nil {
+		return 0, 0, ErrInvalidSnapshot
+	}
+	return index, term, nil
+}
+
+// writeSnapshotMeta 记录快照包含的最后一条日志
+func writeSnapshotMeta(dir string, index, term uint64) error {
+	return writeFileSync(filepath.Join(dir, snapshotMetaFileName), []byte(fmt.Sprintf("%d %d", index, term)))
+}
+
+// writeFileSync 写入文件并持久化
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
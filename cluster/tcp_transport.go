@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// tcpRPCTimeout 一次请求的超时时间
+const tcpRPCTimeout = time.Second
+
+// TCPTransport 基于 TCP（net/rpc）的通信，用于在多个进程之间运行节点
+type TCPTransport struct {
+	listener net.Listener
+	server   *rpc.Server
+
+	mu      sync.Mutex
+	clients map[string]*rpc.Client /* 到其他节点的连接，出错之后重新建立 */
+	conns   map[net.Conn]struct{}  /* 其他节点建立的连接 */
+	closed  bool
+}
+
+// NewTCPTransport 在 addr 上监听其他节点的请求，addr 同时作为节点的地址
+func NewTCPTransport(addr string) (*TCPTransport, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPTransport{
+		listener: listener,
+		server:   rpc.NewServer(),
+		clients:  make(map[string]*rpc.Client),
+		conns:    make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Addr 监听的地址
+func (t *TCPTransport) Addr() string {
+	return t.listener.Addr().String()
+}
+
+// raftService 将 rpc 请求转交给节点处理
+type raftService struct {
+	node *Node
+}
+
+func (s *raftService) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	return s.node.handleRequestVote(args, reply)
+}
+
+func (s *raftService) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	return s.node.handleAppendEntries(args, reply)
+}
+
+func (s *raftService) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	return s.node.handleInstallSnapshot(args, reply)
+}
+
+func (t *TCPTransport) Serve(node *Node) error {
+	if err := t.server.RegisterName("Raft", &raftService{node: node}); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := t.listener.Accept()
+			if err != nil {
+				return
+			}
+			t.mu.Lock()
+			if t.closed {
+				t.mu.Unlock()
+				_ = conn.Close()
+				return
+			}
+			t.conns[conn] = struct{}{}
+			t.mu.Unlock()
+
+			go func() {
+				t.server.ServeConn(conn)
+				t.mu.Lock()
+				delete(t.conns, conn)
+				t.mu.Unlock()
+			}()
+		}
+	}()
+	return nil
+}
+
+func (t *TCPTransport) RequestVote(peer string, args *RequestVoteArgs, reply *RequestVoteReply) error {
+	return t.call(peer, "Raft.RequestVote", args, reply)
+}
+
+func (t *TCPTransport) AppendEntries(peer string, args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	return t.call(peer, "Raft.AppendEntries", args, reply)
+}
+
+func (t *TCPTransport) InstallSnapshot(peer string, args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	return t.call(peer, "Raft.InstallSnapshot", args, reply)
+}
+
+// call 向 peer 发送请求，超时或者连接出错时关闭连接，下一次请求重新建立
+func (t *TCPTransport) call(peer, method string, args, reply interface{}) error {
+
+	client, err := t.client(peer)
+	if err != nil {
+		return err
+	}
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-time.After(tcpRPCTimeout):
+		err = ErrPeerUnreachable
+	}
+
+	// 节点处理请求返回的错误不影响连接
+	if _, ok := err.(rpc.ServerError); err != nil && !ok {
+		t.mu.Lock()
+		if t.clients[peer] == client {
+			delete(t.clients, peer)
+		}
+		t.mu.Unlock()
+		_ = client.Close()
+	}
+	return err
+}
+
+// client 获取到 peer 的连接
+func (t *TCPTransport) client(peer string) (*rpc.Client, error) {
+
+	t.mu.Lock()
+	client, ok := t.clients[peer]
+	t.mu.Unlock()
+	if ok {
+		return client, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", peer, tcpRPCTimeout)
+	if err != nil {
+		return nil, ErrPeerUnreachable
+	}
+	client = rpc.NewClient(conn)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		_ = client.Close()
+		return nil, ErrPeerUnreachable
+	}
+	if existing, ok := t.clients[peer]; ok {
+		_ = client.Close()
+		return existing, nil
+	}
+	t.clients[peer] = client
+	return client, nil
+}
+
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	for _, client := range t.clients {
+		_ = client.Close()
+	}
+	t.clients = make(map[string]*rpc.Client)
+	for conn := range t.conns {
+		_ = conn.Close()
+	}
+	t.mu.Unlock()
+	return t.listener.Close()
+}
@@ -0,0 +1,162 @@
+package cluster
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPeerUnreachable 无法连接到目标节点
+var ErrPeerUnreachable = errors.New("the peer is unreachable")
+
+// RequestVoteArgs 请求投票
+type RequestVoteArgs struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// RequestVoteReply 投票结果
+type RequestVoteReply struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+// AppendEntriesArgs 复制日志，日志为空时作为心跳
+type AppendEntriesArgs struct {
+	Term         uint64
+	LeaderID     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []LogEntry
+	LeaderCommit uint64
+}
+
+// AppendEntriesReply 复制日志的结果
+type AppendEntriesReply struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64 /* 日志不匹配时 leader 下一次尝试的位置 */
+}
+
+// InstallSnapshotArgs 发送快照，快照较小，一次发送所有的文件
+type InstallSnapshotArgs struct {
+	Term              uint64
+	LeaderID          string
+	LastIncludedIndex uint64
+	LastIncludedTerm  uint64
+	Files             []SnapshotFile
+}
+
+// SnapshotFile 快照中的一个文件
+type SnapshotFile struct {
+	Name string
+	Data []byte
+}
+
+// InstallSnapshotReply 安装快照的结果
+type InstallSnapshotReply struct {
+	Term uint64
+}
+
+// Transport 节点之间的通信，节点使用地址标识
+type Transport interface {
+	// Serve 开始将收到的请求交给 node 处理
+	Serve(node *Node) error
+
+	RequestVote(peer string, args *RequestVoteArgs, reply *RequestVoteReply) error
+	AppendEntries(peer string, args *AppendEntriesArgs, reply *AppendEntriesReply) error
+	InstallSnapshot(peer string, args *InstallSnapshotArgs, reply *InstallSnapshotReply) error
+
+	// Close 停止接收请求并释放连接
+	Close() error
+}
+
+// InmemNetwork 进程内的网络，用于在同一个进程中运行多个节点，可以模拟网络分区
+type InmemNetwork struct {
+	mu           sync.RWMutex
+	nodes        map[string]*Node
+	disconnected map[string]bool
+}
+
+// NewInmemNetwork 创建进程内的网络
+func NewInmemNetwork() *InmemNetwork {
+	return &InmemNetwork{
+		nodes:        make(map[string]*Node),
+		disconnected: make(map[string]bool),
+	}
+}
+
+// Transport 创建地址为 addr 的节点使用的通信
+func (n *InmemNetwork) Transport(addr string) Transport {
+	return &inmemTransport{network: n, addr: addr}
+}
+
+// Disconnect 断开节点与其他所有节点的连接
+func (n *InmemNetwork) Disconnect(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.disconnected[addr] = true
+}
+
+// Reconnect 恢复节点的连接
+func (n *InmemNetwork) Reconnect(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.disconnected, addr)
+}
+
+// target 获取可以访问的目标节点
+func (n *InmemNetwork) target(from, to string) (*Node, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	node, ok := n.nodes[to]
+	if !ok || n.disconnected[from] || n.disconnected[to] {
+		return nil, ErrPeerUnreachable
+	}
+	return node, nil
+}
+
+// inmemTransport 进程内网络中一个节点的通信
+type inmemTransport struct {
+	network *InmemNetwork
+	addr    string
+}
+
+func (t *inmemTransport) Serve(node *Node) error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	t.network.nodes[t.addr] = node
+	return nil
+}
+
+func (t *inmemTransport) RequestVote(peer string, args *RequestVoteArgs, reply *RequestVoteReply) error {
+	node, err := t.network.target(t.addr, peer)
+	if err != nil {
+		return err
+	}
+	return node.handleRequestVote(args, reply)
+}
+
+func (t *inmemTransport) AppendEntries(peer string, args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	node, err := t.network.target(t.addr, peer)
+	if err != nil {
+		return err
+	}
+	return node.handleAppendEntries(args, reply)
+}
+
+func (t *inmemTransport) InstallSnapshot(peer string, args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	node, err := t.network.target(t.addr, peer)
+	if err != nil {
+		return err
+	}
+	return node.handleInstallSnapshot(args, reply)
+}
+
+func (t *inmemTransport) Close() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	delete(t.network.nodes, t.addr)
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	bitcaskkv "bitcask-go"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 数据目录的离线运维工具
+//
+//	bitcask check <dir>
+//	bitcask repair [-quarantine dir] [-key hex -key-id id] <dir>
+//	bitcask export [-o file] [-index type] [-key hex -key-id id] <dir>
+//	bitcask import [-i file] [-index type] [-batch n] [-skip-existing] [-key hex -key-id id] <dir>
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "check":
+		err = runCheck(os.Args[2:])
+	case "repair":
+		err = runRepair(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  bitcask check <dir>")
+	fmt.Fprintln(os.Stderr, "  bitcask repair [-quarantine dir] [-key hex -key-id id] <dir>")
+	fmt.Fprintln(os.Stderr, "  bitcask export [-o file] [-index type] [-key hex -key-id id] <dir>")
+	fmt.Fprintln(os.Stderr, "  bitcask import [-i file] [-index type] [-batch n] [-skip-existing] [-key hex -key-id id] <dir>")
+}
+
+// runCheck 检查数据目录，有问题时返回非 0
+func runCheck(args []string) error {
+
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	report, err := bitcaskkv.Check(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.TrimSpace(report.String()))
+	if !report.Healthy() {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// runRepair 修复数据目录
+func runRepair(args []string) error {
+
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	quarantine := fs.String("quarantine", "", "directory to keep corrupt data, empty to drop it")
+	key := fs.String("key", "", "hex encoded encryption key, required for encrypted databases")
+	keyId := fs.Uint("key-id", 0, "id of the encryption key")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	opts := bitcaskkv.RepairOptions{QuarantineDir: *quarantine}
+	provider, err := keyProvider(*key, *keyId)
+	if err != nil {
+		return err
+	}
+	opts.Encryption = provider
+
+	report, err := bitcaskkv.Repair(fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	if report.Healthy() {
+		fmt.Println("nothing to repair")
+		return nil
+	}
+	fmt.Print(report.String())
+	fmt.Println("repaired")
+	return nil
+}
+
+// runExport 将数据库导出到文件或者标准输出
+func runExport(args []string) error {
+
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "", "file to write the export to, empty for stdout")
+	indexType := fs.String("index", "btree", "index type of the database: btree, art or bptree")
+	key := fs.String("key", "", "hex encoded encryption key, required for encrypted databases")
+	keyId := fs.Uint("key-id", 0, "id of the encryption key")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	db, err := openDB(fs.Arg(0), *indexType, *key, *keyId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	if *output == "" {
+		return db.Export(os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := db.Export(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runImport 从文件或者标准输入导入数据，数据库不存在时创建
+func runImport(args []string) error {
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	input := fs.String("i", "", "file to read the export from, empty for stdin")
+	indexType := fs.String("index", "btree", "index type of the database: btree, art or bptree")
+	batch := fs.Int("batch", bitcaskkv.DefaultImportOptions.BatchSize, "records written per lock acquisition")
+	skipExisting := fs.Bool("skip-existing", false, "keep the keys that already exist in the database")
+	key := fs.String("key", "", "hex encoded encryption key, required for encrypted databases")
+	keyId := fs.Uint("key-id", 0, "id of the encryption key")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	db, err := openDB(fs.Arg(0), *indexType, *key, *keyId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	r := io.Reader(os.Stdin)
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		r = f
+	}
+
+	opts := bitcaskkv.ImportOptions{BatchSize: *batch, SkipExisting: *skipExisting}
+	return db.Import(r, opts)
+}
+
+// openDB 打开数据目录
+func openDB(dir, indexType, key string, keyId uint) (*bitcaskkv.DB, error) {
+
+	opts := bitcaskkv.DefaultOptions
+	opts.DirPath = dir
+	switch indexType {
+	case "btree":
+		opts.IndexType = bitcaskkv.BTree
+	case "art":
+		opts.IndexType = bitcaskkv.ART
+	case "bptree":
+		opts.IndexType = bitcaskkv.BPTree
+	default:
+		return nil, fmt.Errorf("unknown index type: %s", indexType)
+	}
+
+	provider, err := keyProvider(key, keyId)
+	if err != nil {
+		return nil, err
+	}
+	opts.Encryption = provider
+
+	return bitcaskkv.Open(opts)
+}
+
+// keyProvider 根据命令行参数构造密钥，key 为空表示数据库没有加密
+func keyProvider(key string, keyId uint) (bitcaskkv.KeyProvider, error) {
+
+	if key == "" {
+		return nil, nil
+	}
+	keyBytes, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return &bitcaskkv.StaticKeyProvider{
+		CurrentID: uint32(keyId),
+		Keys:      map[uint32][]byte{uint32(keyId): keyBytes},
+	}, nil
+}
@@ -0,0 +1,431 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FileStat 单个数据文件的统计信息
+type FileStat struct {
+	Fid           uint32
+	Size          int64 /* 文件大小 */
+	LiveSize      int64 /* 有效数据的大小 */
+	DeadSize      int64 /* 无效数据的大小，包括删除标识 */
+	TombstoneSize int64 /* 删除标识的大小，删除标识需要屏蔽更早文件中的数据，只能在全量 merge 时清理 */
+}
+
+// fileStat 数据文件中无效数据的统计
+type fileStat struct {
+	deadSize      int64
+	tombstoneSize int64
+}
+
+// FileStats 返回每个数据文件中有效和无效数据的大小，按照文件 id 排序
+// 统计在写入、删除以及启动加载索引时更新，B+ 树索引不扫描数据文件，只包含打开之后的变化
+func (db *DB) FileStats() ([]FileStat, error) {
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var dataFiles []*data.DataFile
+	if db.activeFile != nil {
+		dataFiles = append(dataFiles, db.activeFile)
+	}
+	for _, dataFile := range db.olderFiles {
+		dataFiles = append(dataFiles, dataFile)
+	}
+
+	stats := make([]FileStat, 0, len(dataFiles))
+	for _, dataFile := range dataFiles {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		stat := FileStat{Fid: dataFile.FileId, Size: size}
+		if s, ok := db.fileStats[dataFile.FileId]; ok {
+			stat.DeadSize, stat.TombstoneSize = s.deadSize, s.tombstoneSize
+		}
+		if stat.LiveSize = size - stat.DeadSize; stat.LiveSize < 0 {
+			stat.LiveSize = 0
+		}
+		stats = append(stats, stat)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Fid < stats[j].Fid
+	})
+	return stats, nil
+}
+
+// Compact 只重写无效数据（不包括删除标识）占比达到 CompactionGarbageRatio 的数据文件
+// 重写的开销只与无效数据所在的文件有关，而不是整个数据库的大小
+func (db *DB) Compact() error {
+	fids, err := db.pickCompactionFiles()
+	if err != nil || len(fids) == 0 {
+		return err
+	}
+	return db.CompactFiles(fids)
+}
+
+// pickCompactionFiles 选出可以回收的无效数据占比达到阈值的封存文件
+func (db *DB) pickCompactionFiles() ([]uint32, error) {
+
+	stats, err := db.FileStats()
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var fids []uint32
+	for _, stat := range stats {
+		if db.activeFile != nil && stat.Fid == db.activeFile.FileId {
+			continue
+		}
+		if stat.Size == 0 {
+			continue
+		}
+		garbage := stat.DeadSize - stat.TombstoneSize
+		if float32(garbage)/float32(stat.Size) >= db.options.CompactionGarbageRatio {
+			fids = append(fids, stat.Fid)
+		}
+	}
+	return fids, nil
+}
+
+// CompactFiles 重写指定的封存文件，将其中有效的数据追加到活跃文件，然后删除这些文件
+// 文件开头是跨文件事务的一部分时，会一并重写前一个数据文件，保证事务的完整
+// 仍然有效的删除标识同样会被重写，继续屏蔽更早文件中的数据
+// 重写期间不阻塞读写，被快照、迭代器引用的文件在解除引用之后删除
+func (db *DB) CompactFiles(fids []uint32) error {
+
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(fids) == 0 {
+		return nil
+	}
+
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	compactFiles, err := db.expandCompactionFiles(fids)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	db.isMerging = true
+	db.mu.Unlock()
+
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+
+	for _, dataFile := range compactFiles {
+		if err := db.rewriteDataFile(dataFile); err != nil {
+			return err
+		}
+	}
+
+	return db.installCompaction(compactFiles)
+}
+
+// expandCompactionFiles 校验需要重写的文件，并补充事务所需的前一个文件，按照文件 id 排序（需要持有 db.mu）
+func (db *DB) expandCompactionFiles(fids []uint32) ([]*data.DataFile, error) {
+
+	selected := make(map[uint32]*data.DataFile)
+	for _, fid := range fids {
+		if db.activeFile != nil && fid == db.activeFile.FileId {
+			return nil, ErrCompactActiveFile
+		}
+		dataFile, ok := db.olderFiles[fid]
+		if !ok {
+			return nil, ErrDataFileNoFound
+		}
+		selected[fid] = dataFile
+	}
+
+	var olderFids []uint32
+	for fid := range db.olderFiles {
+		olderFids = append(olderFids, fid)
+	}
+	sort.Slice(olderFids, func(i, j int) bool {
+		return olderFids[i] < olderFids[j]
+	})
+
+	// 事务的数据是连续写入的，文件开头的记录属于事务则说明该事务可能从前一个文件开始
+	// 只重写后面的文件会丢失事务完成标识，导致前面文件中已经提交的数据在重启之后失效
+	// 事务可能跨越多个文件，一直向前补充到开头的记录不属于事务的文件
+	for i := len(olderFids) - 1; i > 0; i-- {
+		if _, ok := selected[olderFids[i]]; !ok {
+			continue
+		}
+		for j := i; j > 0; j-- {
+			inTxn, err := startsInTransaction(db.olderFiles[olderFids[j]])
+			if err != nil {
+				return nil, err
+			}
+			if !inTxn {
+				break
+			}
+			selected[olderFids[j-1]] = db.olderFiles[olderFids[j-1]]
+		}
+	}
+
+	compactFiles := make([]*data.DataFile, 0, len(selected))
+	for _, dataFile := range selected {
+		compactFiles = append(compactFiles, dataFile)
+	}
+	sort.Slice(compactFiles, func(i, j int) bool {
+		return compactFiles[i].FileId < compactFiles[j].FileId
+	})
+	return compactFiles, nil
+}
+
+// startsInTransaction 数据文件开头的记录是否属于事务
+func startsInTransaction(dataFile *data.DataFile) (bool, error) {
+	logRecord, _, err := dataFile.ReadLogRecord(0)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	_, seqNo := parseLogRecordKey(logRecord.Key)
+	return seqNo != nonTransactionSeqNo, nil
+}
+
+// rewriteDataFile 将数据文件中仍然有效的记录追加到活跃文件
+func (db *DB) rewriteDataFile(dataFile *data.DataFile) error {
+
+	var offset int64 = 0
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		// 事务完成标识在事务的数据重写之后不再需要
+		if logRecord.Type != data.LogRecordTxnFinished {
+			if err := db.rewriteLogRecord(logRecord, dataFile.FileId, offset, size); err != nil {
+				return err
+			}
+		}
+		offset += size
+	}
+	return nil
+}
+
+// rewriteLogRecord 如果记录仍然有效，则以非事务的方式追加到活跃文件并更新索引
+// 重写的记录保留最初写入的位置，回放事件时据此跳过已经回放过的数据
+func (db *DB) rewriteLogRecord(logRecord *data.LogRecord, fid uint32, offset, size int64) error {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 已经删除的命名空间中的数据直接丢弃
+	idx := db.namespaceIndex(logRecord.Namespace)
+	if idx == nil {
+		return nil
+	}
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+	pos := idx.Get(realKey)
+	if logRecord.Origin == 0 {
+		logRecord.Origin = eventSeq(fid, offset+size)
+	}
+
+	// 写入删除标识
+	writeTombstone := func() error {
+		tombstonePos, err := db.writeLogRecordSilently(&data.LogRecord{
+			Key:       logRecordKeyWithSeq(realKey, nonTransactionSeqNo),
+			Type:      data.LogRecordDeleted,
+			Namespace: logRecord.Namespace,
+			Origin:    logRecord.Origin,
+		}, false)
+		if err != nil {
+			return err
+		}
+		db.addTombstoneSize(tombstonePos)
+		return nil
+	}
+
+	switch {
+	case logRecord.Type == data.LogRecordDeleted:
+		// key 仍然不存在，删除标识需要保留
+		if pos != nil {
+			return nil
+		}
+		return writeTombstone()
+
+	case pos == nil || pos.Fid != fid || pos.Offset != offset:
+		// 已经被覆盖或删除的数据
+		return nil
+
+	case isExpired(pos, time.Now().UnixNano()):
+		// 已经过期的数据改写为删除标识
+		if err := writeTombstone(); err != nil {
+			return err
+		}
+		idx.Delete(realKey)
+		db.addReclaimSize(pos)
+		return nil
+
+	default:
+		logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+		newPos, err := db.writeLogRecordSilently(logRecord, false)
+		if err != nil {
+			return err
+		}
+		idx.Put(realKey, newPos)
+		db.addReclaimSize(pos)
+		return nil
+	}
+}
+
+// installCompaction 持久化重写的数据，然后下线被重写的文件
+func (db *DB) installCompaction(compactFiles []*data.DataFile) error {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 重写的数据持久化之后才能删除旧文件
+	if err := db.syncActiveFiles(); err != nil {
+		return err
+	}
+
+	removed := make(map[uint32]bool, len(compactFiles))
+	for _, dataFile := range compactFiles {
+		removed[dataFile.FileId] = true
+	}
+	if err := db.removeHintIndexEntries(removed); err != nil {
+		return err
+	}
+
+	for _, dataFile := range compactFiles {
+		db.retireDataFile(dataFile)
+	}
+	db.resetReclaimSize()
+
+	return nil
+}
+
+// removeHintIndexEntries 从 hint 索引文件中去掉指向被删除文件的索引（需要持有 db.mu）
+// 新的 hint 文件先写入 merge 临时目录再替换，中途崩溃时临时目录在启动时被清理
+func (db *DB) removeHintIndexEntries(removed map[uint32]bool) error {
+
+	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
+	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	hintFile.Encryptor = db.encryptor
+	defer func() {
+		_ = hintFile.Close()
+	}()
+
+	tmpPath := db.getMergePath()
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmpPath, os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpPath)
+	}()
+	newHintFile, err := data.OpenHintFile(tmpPath)
+	if err != nil {
+		return err
+	}
+	newHintFile.Encryptor = db.encryptor
+	defer func() {
+		_ = newHintFile.Close()
+	}()
+
+	var changed bool
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		offset += size
+
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if removed[pos.Fid] {
+			changed = true
+			continue
+		}
+		if err := newHintFile.WritHintRecord(logRecord.Key, logRecord.Namespace, pos); err != nil {
+			return err
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if err := newHintFile.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(tmpPath, data.HintFileName), hintFileName)
+}
+
+// retireDataFile 下线一个封存的数据文件，仍然被引用的文件在解除引用之后删除（需要持有 db.mu）
+func (db *DB) retireDataFile(dataFile *data.DataFile) {
+	delete(db.olderFiles, dataFile.FileId)
+	delete(db.fileStats, dataFile.FileId)
+	if db.pinnedFiles[dataFile.FileId] > 0 {
+		db.retiredFiles[dataFile.FileId] = dataFile
+		return
+	}
+	db.removeDataFile(dataFile)
+}
+
+// addReclaimSize 记录一条失效的数据（需要持有 db.mu）
+func (db *DB) addReclaimSize(pos *data.LogRecordPos) {
+	db.reclaimSize += int64(pos.Size)
+	db.getFileStat(pos.Fid).deadSize += int64(pos.Size)
+}
+
+// addTombstoneSize 记录一条删除标识，删除标识本身也是无效数据（需要持有 db.mu）
+func (db *DB) addTombstoneSize(pos *data.LogRecordPos) {
+	db.addReclaimSize(pos)
+	db.getFileStat(pos.Fid).tombstoneSize += int64(pos.Size)
+}
+
+// resetReclaimSize 根据每个文件的统计重新计算可以回收的数据量（需要持有 db.mu）
+func (db *DB) resetReclaimSize() {
+	db.reclaimSize = 0
+	for _, stat := range db.fileStats {
+		db.reclaimSize += stat.deadSize
+	}
+}
+
+// getFileStat 获取数据文件的统计信息，不存在则创建（需要持有 db.mu）
+func (db *DB) getFileStat(fid uint32) *fileStat {
+	stat, ok := db.fileStats[fid]
+	if !ok {
+		stat = &fileStat{}
+		db.fileStats[fid] = stat
+	}
+	return stat
+}
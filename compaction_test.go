@@ -0,0 +1,353 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_FileStats(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-stats")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	assert.Greater(t, len(stats), 2)
+	for _, stat := range stats {
+		assert.Equal(t, int64(0), stat.DeadSize)
+		assert.Equal(t, stat.Size, stat.LiveSize)
+	}
+
+	// 覆盖和删除第一个文件中的数据
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	for i := 100; i < 150; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	stats, err = db.FileStats()
+	assert.Nil(t, err)
+	assert.Greater(t, stats[0].DeadSize, int64(0))
+	assert.Equal(t, stats[0].Size, stats[0].LiveSize+stats[0].DeadSize)
+	assert.Greater(t, stats[len(stats)-1].TombstoneSize, int64(0))
+
+	var deadSize int64
+	for _, stat := range stats {
+		deadSize += stat.DeadSize
+	}
+	assert.Equal(t, db.Stat().ReclaimableSize, deadSize)
+
+	// 重启之后的统计与运行时一致
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	reopened, err := db.FileStats()
+	assert.Nil(t, err)
+	assert.Equal(t, stats, reopened)
+}
+
+// 批量写入中的删除同样统计删除标识的大小
+func TestDB_FileStatsBatchDelete(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-stats-batch")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, wb.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commit())
+
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	var tombstoneSize, deadSize int64
+	for _, stat := range stats {
+		tombstoneSize += stat.TombstoneSize
+		deadSize += stat.DeadSize
+	}
+	assert.Greater(t, tombstoneSize, int64(0))
+	assert.Equal(t, db.Stat().ReclaimableSize, deadSize)
+
+	// 重启之后的统计与运行时一致
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	reopened, err := db.FileStats()
+	assert.Nil(t, err)
+	assert.Equal(t, stats, reopened)
+}
+
+func TestDB_Compact(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	// 第一个文件中的数据大部分被覆盖
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	for i := 0; i < 300; i++ {
+		if i%10 != 0 {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new")))
+		}
+	}
+	keys := db.ListKeys()
+
+	assert.Nil(t, db.Compact())
+
+	// 只有第一个文件被重写，其余文件保持不变
+	_, err = os.Stat(data.GetDataFileName(dir, stats[0].Fid))
+	assert.True(t, os.IsNotExist(err))
+	for _, stat := range stats[1:] {
+		_, err = os.Stat(data.GetDataFileName(dir, stat.Fid))
+		assert.Nil(t, err)
+	}
+	newStats, err := db.FileStats()
+	assert.Nil(t, err)
+	for _, stat := range newStats {
+		if stat.Fid != db.activeFile.FileId {
+			assert.Less(t, float32(stat.DeadSize)/float32(stat.Size), opts.CompactionGarbageRatio)
+		}
+	}
+
+	check := func() {
+		assert.Equal(t, keys, db.ListKeys())
+		for i := 0; i < 300; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			if i%10 != 0 {
+				assert.Equal(t, []byte("new"), val)
+			} else {
+				assert.NotEqual(t, []byte("new"), val)
+			}
+		}
+	}
+	check()
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check()
+
+	// 活跃文件和不存在的文件不能重写
+	assert.Equal(t, ErrCompactActiveFile, db.CompactFiles([]uint32{db.activeFile.FileId}))
+	assert.Equal(t, ErrDataFileNoFound, db.CompactFiles([]uint32{stats[0].Fid}))
+}
+
+// 重写的文件中的删除标识需要继续屏蔽更早文件中的数据，跨文件的事务保持完整
+func TestDB_CompactTombstonesAndTxn(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact-txn")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	// 跨越多个文件的事务
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1500; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 2000; i < 2300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	// 重写除第一个文件之外所有包含事务完成标识或删除标识的封存文件
+	// 事务完成标识位于事务数据所在的最后一个文件中
+	var markerFid uint32
+	for i := 1000; i < 1500; i++ {
+		if pos := db.index.Get(utils.GetTestKey(i)); pos.Fid > markerFid {
+			markerFid = pos.Fid
+		}
+	}
+	tombstoneFid := db.activeFile.FileId
+	for fid := range db.olderFiles {
+		if fid < tombstoneFid && fid > markerFid {
+			tombstoneFid = fid
+		}
+	}
+	assert.Nil(t, db.CompactFiles([]uint32{markerFid, tombstoneFid}))
+
+	check := func() {
+		for i := 0; i < 100; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+		for i := 100; i < 200; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+		for i := 1000; i < 1500; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+	}
+	check()
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check()
+}
+
+// merge 之后的文件由 hint 索引文件加载，重写之后 hint 中对应的索引需要删除
+func TestDB_CompactMergedFiles(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact-merged")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	assert.Nil(t, db.Merge())
+
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	mergedFid := stats[0].Fid
+	for i := 0; i < 1000; i++ {
+		if pos := db.index.Get(utils.GetTestKey(i)); pos.Fid == mergedFid && i%2 == 0 {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new")))
+		}
+	}
+	assert.Nil(t, db.CompactFiles([]uint32{mergedFid}))
+	keys := db.ListKeys()
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keys, db.ListKeys())
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+// 跨越三个以上文件的事务，只选择包含事务完成标识的文件时补充事务所在的全部文件
+func TestDB_CompactTxnAcrossFiles(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact-txn-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.GetTestValue(128)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1800; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 2000; i < 2300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	firstFid, markerFid := db.index.Get(utils.GetTestKey(1000)).Fid, uint32(0)
+	for i := 1000; i < 1800; i++ {
+		pos := db.index.Get(utils.GetTestKey(i))
+		if pos.Fid < firstFid {
+			firstFid = pos.Fid
+		}
+		if pos.Fid > markerFid {
+			markerFid = pos.Fid
+		}
+	}
+	assert.GreaterOrEqual(t, markerFid-firstFid, uint32(2))
+
+	db.mu.Lock()
+	compactFiles, err := db.expandCompactionFiles([]uint32{markerFid})
+	db.mu.Unlock()
+	assert.Nil(t, err)
+	var fids []uint32
+	for _, dataFile := range compactFiles {
+		fids = append(fids, dataFile.FileId)
+	}
+	for fid := firstFid; fid <= markerFid; fid++ {
+		assert.Contains(t, fids, fid)
+	}
+
+	assert.Nil(t, db.CompactFiles([]uint32{markerFid}))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 1000; i < 1800; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
@@ -0,0 +1,123 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bytes"
+	"os"
+	"path/filepath"
+)
+
+// comparatorKey 比较规则文件中记录的 key
+const comparatorKey = "comparator"
+
+/*
+自定义比较规则决定索引、迭代器以及事务迭代器中 key 的顺序，上下界和 Seek 都按照比较规则判断。
+BTree 索引直接按照比较规则排列；ART 和 B+ 树索引只能按照字节序存储，遍历时取出范围内的 key 再按照比较规则排序。
+前缀只有在字节序下才对应一段连续的范围，自定义比较规则时前缀在遍历时逐个过滤
+
+比较规则的名称记录在数据目录的 comparator 文件中，没有该文件表示按照字节序，使用不同的比较规则打开时返回 ErrComparatorMismatch
+*/
+
+// Comparator key 的比较规则
+type Comparator = index.Comparator
+
+// BytewiseComparator 默认的比较规则，按照字节序比较
+var BytewiseComparator = index.BytewiseComparator
+
+// NewComparator 根据名称和比较函数创建比较规则，名称用于校验重新打开时的比较规则是否一致
+func NewComparator(name string, compare func(a, b []byte) int) Comparator {
+	return index.NewComparator(name, compare)
+}
+
+// comparator 当前使用的比较规则
+func (db *DB) comparator() Comparator {
+	return optionsComparator(db.options)
+}
+
+// optionsComparator 配置项中的比较规则，nil 表示按照字节序
+func optionsComparator(options Options) Comparator {
+	if options.Comparator == nil {
+		return BytewiseComparator
+	}
+	return options.Comparator
+}
+
+// isBytewiseComparator 比较规则是否为字节序
+func isBytewiseComparator(cmp Comparator) bool {
+	return cmp.Name() == BytewiseComparator.Name()
+}
+
+// checkComparator 校验比较规则与数据目录中记录的是否一致，第一次使用自定义的比较规则时记录下来
+func (db *DB) checkComparator() error {
+
+	name := db.comparator().Name()
+	fileName := filepath.Join(db.options.DirPath, data.ComparatorFileName)
+	_, err := os.Stat(fileName)
+	if err == nil {
+		recorded, err := readComparatorName(db.options.DirPath)
+		if err != nil {
+			return err
+		}
+		if recorded != name {
+			return ErrComparatorMismatch
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	// 没有记录时已有的数据按照字节序写入
+	if isBytewiseComparator(db.comparator()) {
+		return nil
+	}
+	fileIds, err := listDataFileIds(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if len(fileIds) > 0 {
+		return ErrComparatorMismatch
+	}
+	if db.options.ReadOnly {
+		return nil
+	}
+
+	encRecord, _, err := data.EncodeEncryptedLogRecord(&data.LogRecord{
+		Key:   []byte(comparatorKey),
+		Value: []byte(name),
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	// 先写入临时文件再替换，避免留下不完整的文件
+	tmpName := fileName + ".tmp"
+	if err := os.WriteFile(tmpName, encRecord, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// readComparatorName 读取数据目录中记录的比较规则名称
+func readComparatorName(dirPath string) (string, error) {
+
+	comparatorFile, err := data.OpenComparatorFile(dirPath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = comparatorFile.Close()
+	}()
+
+	record, _, err := comparatorFile.ReadLogRecord(0)
+	if err != nil {
+		return "", err
+	}
+	return string(record.Value), nil
+}
+
+// hasPrefix 自定义比较规则时前缀没有下推到索引迭代器中，需要逐个判断
+func (opts IteratorOptions) hasPrefix(cmp Comparator, key []byte) bool {
+	return isBytewiseComparator(cmp) || bytes.HasPrefix(key, opts.Prefix)
+}
@@ -0,0 +1,113 @@
+package bitcaskkv
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reverseComparator 按照字节序倒序比较
+var reverseComparator = NewComparator("test.reverse", func(a, b []byte) int {
+	return bytes.Compare(b, a)
+})
+
+// iteratorKeys 取出迭代器中所有的 key
+func iteratorKeys(it *Iterator) []string {
+	var keys []string
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	it.Close()
+	return keys
+}
+
+func TestDB_Comparator(t *testing.T) {
+
+	for _, indexType := range []IndexerType{BTree, ART} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-comparator")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		opts.Comparator = reverseComparator
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		for _, key := range []string{"a1", "a2", "b1", "b2", "c1"} {
+			assert.Nil(t, db.Put([]byte(key), []byte(key)))
+		}
+
+		// 按照比较规则排列
+		assert.Equal(t, []string{"c1", "b2", "b1", "a2", "a1"}, iteratorKeys(db.NewIterator(DefaultIteratorOptions)))
+		assert.Equal(t, []string{"a1", "a2", "b1", "b2", "c1"}, iteratorKeys(db.NewIterator(IteratorOptions{Reverse: true})))
+
+		// 前缀逐个过滤，上下界按照比较规则判断
+		assert.Equal(t, []string{"b2", "b1"}, iteratorKeys(db.NewIterator(IteratorOptions{Prefix: []byte("b")})))
+		assert.Equal(t, []string{"b2", "b1"}, iteratorKeys(db.NewIterator(IteratorOptions{
+			LowerBound: []byte("b2"),
+			UpperBound: []byte("a2"),
+		})))
+
+		it := db.NewIterator(IteratorOptions{Prefix: []byte("a")})
+		it.Seek([]byte("a3"))
+		assert.True(t, it.Valid())
+		assert.Equal(t, []byte("a2"), it.Key())
+		it.Close()
+
+		// 事务内的写入同样按照比较规则合并
+		txn, err := db.Begin(false)
+		assert.Nil(t, err)
+		assert.Nil(t, txn.Put([]byte("b3"), []byte("b3")))
+		assert.Nil(t, txn.Delete([]byte("a2")))
+		var keys []string
+		ti := txn.NewIterator(DefaultIteratorOptions)
+		for ti.Rewind(); ti.Valid(); ti.Next() {
+			keys = append(keys, string(ti.Key()))
+		}
+		ti.Close()
+		assert.Equal(t, []string{"c1", "b3", "b2", "b1", "a1"}, keys)
+		assert.Nil(t, txn.Rollback())
+
+		assert.Nil(t, db.Close())
+
+		// 使用不同的比较规则打开失败
+		opts.Comparator = nil
+		_, err = Open(opts)
+		assert.Equal(t, ErrComparatorMismatch, err)
+
+		opts.Comparator = reverseComparator
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"c1", "b2", "b1", "a2", "a1"}, iteratorKeys(db.NewIterator(DefaultIteratorOptions)))
+
+		/* 销毁创建的临时 DB 以及临时文件 */
+		assert.Nil(t, destroyDB(db))
+	}
+}
+
+func TestDB_ComparatorExistingData(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-comparator-existing")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	// 已有按照字节序写入的数据，不能改用自定义的比较规则
+	opts.Comparator = reverseComparator
+	_, err = Open(opts)
+	assert.Equal(t, ErrComparatorMismatch, err)
+
+	opts.Comparator = BytewiseComparator
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,46 @@
+package bitcaskkv
+
+import "bitcask-go/data"
+
+// Codec 自定义的压缩算法
+type Codec = data.Codec
+
+// RegisterCodec 注册自定义的压缩算法，之后可以在 Options.Compression 中使用该 id
+// 读取数据时根据记录中的 id 查找算法，因此打开包含该算法数据的数据库之前都需要先注册
+func RegisterCodec(id CompressionType, codec Codec) error {
+	return data.RegisterCodec(id, codec)
+}
+
+// compressLogRecord 按照配置压缩 value，返回实际写入的记录（需要持有 db.mu）
+// 原记录不会被修改，调用方仍然可以使用未压缩的 value
+func (db *DB) compressLogRecord(logRecord *data.LogRecord) (*data.LogRecord, error) {
+
+	if db.options.Compression == CompressionNone ||
+		logRecord.Type != data.LogRecordNormal ||
+		len(logRecord.Value) < db.options.CompressionMinSize {
+		return logRecord, nil
+	}
+
+	value, codec, err := data.CompressValue(db.options.Compression, logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	db.rawValueSize += int64(len(logRecord.Value))
+	db.compressedValueSize += int64(len(value))
+
+	if codec == CompressionNone {
+		return logRecord, nil
+	}
+	return &data.LogRecord{
+		Key:    logRecord.Key,
+		Value:  value,
+		Type:   logRecord.Type,
+		Expire: logRecord.Expire,
+		Codec:  codec,
+
+		Namespace: logRecord.Namespace,
+		Blob:      logRecord.Blob,
+		Origin:    logRecord.Origin,
+	}, nil
+}
@@ -0,0 +1,81 @@
+package bitcaskkv
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Compression(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+	opts.DirPath = dir
+	opts.Compression = CompressionSnappy
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	value := bytes.Repeat([]byte(`{"name":"bitcask-go","tags":["kv","log"]}`), 100)
+	small := []byte("small value")
+
+	assert.Nil(t, db.Put([]byte("large"), value))
+	assert.Nil(t, db.Put([]byte("small"), small))
+
+	val, err := db.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	stat := db.Stat()
+	assert.Equal(t, int64(len(value)), stat.RawValueSize)
+	assert.Less(t, stat.CompressionRatio, 0.5)
+
+	// 更换压缩算法后重启，不同压缩算法的数据可以同时读取
+	assert.Nil(t, db.Close())
+	opts.Compression = CompressionZstd
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("zstd"), value))
+
+	for _, key := range []string{"large", "zstd"} {
+		val, err = db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	val, err = db.Get([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, small, val)
+
+	// merge 之后通过 hint 文件加载，数据依然正确
+	assert.Nil(t, db.Put([]byte("large"), value))
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	opts.Compression = CompressionNone
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for _, key := range []string{"large", "zstd"} {
+		val, err = db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	assert.Equal(t, float64(1), db.Stat().CompressionRatio)
+}
+
+func TestOpen_UnknownCompression(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression-unknown")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.Compression = 250
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+}
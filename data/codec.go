@@ -0,0 +1,131 @@
+package data
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	ErrUnknownCodec    = errors.New("unknown compression codec")
+	ErrCodecRegistered = errors.New("compression codec already registered")
+)
+
+// CompressionType value 使用的压缩算法，记录在每条 LogRecord 的 header 中
+type CompressionType = byte
+
+const (
+	CompressionNone   CompressionType = iota /* 不压缩 */
+	CompressionSnappy                        /* snappy */
+	CompressionZstd                          /* zstd */
+)
+
+// Codec 压缩算法的实现，需要是并发安全的
+type Codec interface {
+	// Compress 压缩数据
+	Compress(src []byte) ([]byte, error)
+
+	// Decompress 解压数据
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	codecLock = new(sync.RWMutex)
+	codecs    = map[CompressionType]Codec{
+		CompressionSnappy: snappyCodec{},
+		CompressionZstd:   newZstdCodec(),
+	}
+)
+
+// RegisterCodec 注册自定义的压缩算法，id 一旦写入数据文件就不能再改变含义
+func RegisterCodec(id CompressionType, codec Codec) error {
+
+	if id == CompressionNone || codec == nil {
+		return ErrUnknownCodec
+	}
+
+	codecLock.Lock()
+	defer codecLock.Unlock()
+
+	if _, ok := codecs[id]; ok {
+		return ErrCodecRegistered
+	}
+	codecs[id] = codec
+	return nil
+}
+
+// GetCodec 获取已注册的压缩算法
+func GetCodec(id CompressionType) (Codec, bool) {
+
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+
+	codec, ok := codecs[id]
+	return codec, ok
+}
+
+// CompressValue 使用指定的算法压缩 value
+// 压缩之后没有变小则返回原数据以及 CompressionNone
+func CompressValue(id CompressionType, value []byte) ([]byte, CompressionType, error) {
+
+	if id == CompressionNone {
+		return value, CompressionNone, nil
+	}
+
+	codec, ok := GetCodec(id)
+	if !ok {
+		return nil, CompressionNone, ErrUnknownCodec
+	}
+
+	compressed, err := codec.Compress(value)
+	if err != nil {
+		return nil, CompressionNone, err
+	}
+	if len(compressed) >= len(value) {
+		return value, CompressionNone, nil
+	}
+	return compressed, id, nil
+}
+
+// decompressValue 使用指定的算法解压 value
+func decompressValue(id CompressionType, value []byte) ([]byte, error) {
+
+	codec, ok := GetCodec(id)
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return codec.Decompress(value)
+}
+
+// snappyCodec snappy 压缩
+type snappyCodec struct{}
+
+func (snappyCodec) Compress(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCodec) Decompress(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+// zstdCodec zstd 压缩，encoder 和 decoder 的 EncodeAll/DecodeAll 都是并发安全的
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCodec() *zstdCodec {
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil)
+	return &zstdCodec{encoder: encoder, decoder: decoder}
+}
+
+func (c *zstdCodec) Compress(src []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(src, nil), nil
+}
+
+func (c *zstdCodec) Decompress(src []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(src, nil)
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// trimCodec 测试用的自定义压缩算法
+type trimCodec struct{}
+
+func (trimCodec) Compress(src []byte) ([]byte, error) {
+	return bytes.TrimRight(src, "a"), nil
+}
+
+func (trimCodec) Decompress(src []byte) ([]byte, error) {
+	return append(src, bytes.Repeat([]byte("a"), 64)...), nil
+}
+
+func TestCompressValue(t *testing.T) {
+
+	value := bytes.Repeat([]byte(`{"name":"bitcask-go","tags":["kv","log"]}`), 100)
+
+	for _, id := range []CompressionType{CompressionSnappy, CompressionZstd} {
+		compressed, codec, err := CompressValue(id, value)
+		assert.Nil(t, err)
+		assert.Equal(t, id, codec)
+		assert.Less(t, len(compressed), len(value))
+
+		decompressed, err := decompressValue(codec, compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, value, decompressed)
+	}
+
+	// 压缩之后没有变小则不压缩
+	compressed, codec, err := CompressValue(CompressionSnappy, []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, CompressionNone, codec)
+	assert.Equal(t, []byte("x"), compressed)
+
+	// 未注册的算法
+	_, _, err = CompressValue(200, value)
+	assert.Equal(t, ErrUnknownCodec, err)
+
+	// 注册自定义算法
+	assert.Equal(t, ErrCodecRegistered, RegisterCodec(CompressionZstd, trimCodec{}))
+	assert.Nil(t, RegisterCodec(100, trimCodec{}))
+	compressed, codec, err = CompressValue(100, append([]byte("b"), bytes.Repeat([]byte("a"), 64)...))
+	assert.Nil(t, err)
+	assert.Equal(t, CompressionType(100), codec)
+	assert.Equal(t, []byte("b"), compressed)
+}
+
+func TestDataFile_ReadLogRecord_Compressed(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "bitcask-go-codec")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	value := bytes.Repeat([]byte("bitcask kv go "), 100)
+	compressed, codec, err := CompressValue(CompressionSnappy, value)
+	assert.Nil(t, err)
+
+	// 压缩与不压缩的记录混合存储
+	rec1 := &LogRecord{Key: []byte("name"), Value: compressed, Codec: codec, Expire: 100}
+	res1, size1 := EncodeLogRecord(rec1)
+	assert.Nil(t, dataFile.Write(res1))
+	rec2 := &LogRecord{Key: []byte("raw"), Value: value}
+	res2, size2 := EncodeLogRecord(rec2)
+	assert.Nil(t, dataFile.Write(res2))
+
+	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, size1, readSize1)
+	assert.Equal(t, value, readRec1.Value)
+	assert.Equal(t, int64(100), readRec1.Expire)
+	assert.Equal(t, CompressionNone, readRec1.Codec)
+
+	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, size2, readSize2)
+	assert.Equal(t, value, readRec2.Value)
+}
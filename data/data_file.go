@@ -10,14 +10,20 @@ import (
 )
 
 var (
-	ErrInvalidCRC = errors.New("invalid crc value, log record maybe corrupted")
+	ErrInvalidCRC          = errors.New("invalid crc value, log record maybe corrupted")
+	ErrIncompleteLogRecord = errors.New("incomplete log record, the file maybe torn")
 )
 
 const (
 	DataFileNameSuffix    = ".data"
+	BlobFileNameSuffix    = ".blob"
+	FileHintNameSuffix    = ".hint"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	NamespaceFileName     = "namespace"
+	BloomFilterFileName   = "bloom-filter"
+	ComparatorFileName    = "comparator"
 )
 
 // 数据文件结构体
@@ -25,6 +31,7 @@ type DataFile struct {
 	FileId    uint32        /* 文件对应 id */
 	WriteOff  int64         /* 文件写入对应偏移量 offset */
 	IoManager fio.IOManager /* io 读写管理 */
+	Encryptor *Encryptor    /* 读写加密数据使用，nil 表示不加密 */
 }
 
 // OpenDataFile 打开新的数据文件
@@ -43,6 +50,14 @@ func OpenHintFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// OpenFileHint 打开数据文件对应的 hint 文件
+func OpenFileHint(dirPath string, fileId uint32) (*DataFile, error) {
+
+	// 完整的数据文件名称
+	fileName := GetFileHintName(dirPath, fileId)
+	return newDataFile(fileName, fileId, fio.StandardFIO)
+}
+
 // OpenMergeFinishedFile 打开标识 merge 完成的文件
 func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
 
@@ -59,11 +74,50 @@ func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// OpenNamespaceFile 打开记录命名空间的文件
+func OpenNamespaceFile(dirPath string) (*DataFile, error) {
+
+	// 完整的数据文件名称
+	fileName := filepath.Join(dirPath, NamespaceFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
+// OpenBloomFilterFile 打开布隆过滤器文件
+func OpenBloomFilterFile(dirPath string) (*DataFile, error) {
+
+	// 完整的数据文件名称
+	fileName := filepath.Join(dirPath, BloomFilterFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
+// OpenComparatorFile 打开记录 key 比较规则的文件
+func OpenComparatorFile(dirPath string) (*DataFile, error) {
+
+	// 完整的数据文件名称
+	fileName := filepath.Join(dirPath, ComparatorFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
 // GetDataFileName 获取
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%d%s", fileId, DataFileNameSuffix))
 }
 
+// OpenBlobFile 打开存储分离出来的 value 的 blob 文件
+func OpenBlobFile(dirPath string, fileId uint32) (*DataFile, error) {
+	return newDataFile(GetBlobFileName(dirPath, fileId), fileId, fio.StandardFIO)
+}
+
+// GetBlobFileName 获取 blob 文件的完整名称
+func GetBlobFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%d%s", fileId, BlobFileNameSuffix))
+}
+
+// GetFileHintName 获取数据文件对应的 hint 文件名称
+func GetFileHintName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%d%s", fileId, FileHintNameSuffix))
+}
+
 // newDataFile 打开文件，返回一个 Datafile 实例
 func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 
@@ -83,11 +137,85 @@ func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFi
 // ReadLogRecord 根据 offset 偏移量读取文件中的 LogRecord
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 
-	fileSize, err := df.IoManager.Size()
+	raw, err := df.readRawLogRecord(offset)
 	if err != nil {
+		// 不完整的记录（写入时崩溃导致）视为文件末尾
+		if err == ErrIncompleteLogRecord {
+			return nil, 0, io.EOF
+		}
 		return nil, 0, err
 	}
 
+	header := raw.header
+	keySize := int64(header.keySize)
+	logRecord := &LogRecord{
+		Type:      header.recordType,
+		Expire:    header.expire,
+		Namespace: header.namespace,
+		Blob:      header.blob,
+		Origin:    header.origin,
+	}
+
+	// 加密的记录需要先解密
+	payload := raw.payload
+	if header.encrypted {
+		if df.Encryptor == nil {
+			return nil, 0, ErrEncryptionKeyRequired
+		}
+		payload, err = df.Encryptor.open(header.keyId, raw.payload, raw.headerBuf)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// 解除 key 和 value
+	if len(payload) > 0 {
+		logRecord.Key = payload[:keySize]
+		logRecord.Value = payload[keySize:]
+	}
+
+	// 对压缩过的 value 进行解压
+	if header.codec != CompressionNone {
+		value, err := decompressValue(header.codec, logRecord.Value)
+		if err != nil {
+			return nil, 0, err
+		}
+		logRecord.Value = value
+	}
+
+	return logRecord, raw.size, nil
+}
+
+// CheckLogRecord 只校验 offset 处记录的完整性，不进行解密和解压，返回记录的长度
+// 文件末尾返回 io.EOF，记录不完整返回 ErrIncompleteLogRecord，crc 错误返回 ErrInvalidCRC
+func (df *DataFile) CheckLogRecord(offset int64) (int64, error) {
+
+	raw, err := df.readRawLogRecord(offset)
+	if err != nil {
+		return 0, err
+	}
+	return raw.size, nil
+}
+
+// rawLogRecord 数据文件中实际存储的一条记录
+type rawLogRecord struct {
+	header    *logRecordHeader
+	headerBuf []byte /* header 的编码，不包含 crc */
+	payload   []byte /* 实际存储的 key/value，加密时为密文 */
+	size      int64  /* 记录的总长度 */
+}
+
+// readRawLogRecord 读取 offset 处实际存储的记录并校验 crc
+func (df *DataFile) readRawLogRecord(offset int64) (*rawLogRecord, error) {
+
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	if offset >= fileSize {
+		return nil, io.EOF
+	}
+
 	// 如果读取最大 header 长度已经超过了文件的长度。则只需要读取到文件末尾即可
 	var headerBytes int64 = maxLogRecordHeaderSize
 	if offset+maxLogRecordHeaderSize > fileSize {
@@ -97,44 +225,55 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 先读取 Header 信息
 	headerBuf, err := df.readNBytes(headerBytes, offset)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
 	// 获取头部信息
 	header, headerSize := decodeLogRecordHeader(headerBuf)
 
 	// 当读取到文件末尾，则直接返回
-	if (header == nil) || (header.crc == 0 && header.keySize == 0 && header.valueSize == 0) {
-		return nil, 0, io.EOF
+	if header == nil {
+		if isZeroBytes(headerBuf) {
+			return nil, io.EOF
+		}
+		return nil, ErrIncompleteLogRecord
+	}
+	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
+		return nil, io.EOF
 	}
 
-	// 取出对应 keySize 和 valSize
-	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
-	var recordSize = headerSize + keySize + valueSize
-
-	logRecord := &LogRecord{
-		Type: header.recordType,
+	// 取出对应 keySize 和 valSize，计算实际存储的长度
+	payloadSize := int64(header.keySize) + int64(header.valueSize)
+	if header.encrypted {
+		payloadSize += encryptionOverhead
+	}
+	var recordSize = headerSize + payloadSize
+	if offset+recordSize > fileSize {
+		return nil, ErrIncompleteLogRecord
 	}
 
 	// 读取该条 LogRecord 实际存储的 key/val
-	if keySize > 0 || valueSize > 0 {
-		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
+	var payload []byte
+	if payloadSize > 0 {
+		payload, err = df.readNBytes(payloadSize, offset+headerSize)
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
-
-		// 解除 key 和 value
-		logRecord.Key = kvBuf[:keySize]
-		logRecord.Value = kvBuf[keySize:]
 	}
 
 	// 校验数据 crc 是否正确
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
+	crc := crc32.ChecksumIEEE(headerBuf[crc32.Size:headerSize])
+	crc = crc32.Update(crc, crc32.IEEETable, payload)
 	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+		return nil, ErrInvalidCRC
 	}
 
-	return logRecord, recordSize, nil
+	return &rawLogRecord{
+		header:    header,
+		headerBuf: headerBuf[crc32.Size:headerSize],
+		payload:   payload,
+		size:      recordSize,
+	}, nil
 }
 
 // Writer 数据文件写入方法
@@ -152,16 +291,20 @@ func (df *DataFile) Write(buf []byte) error {
 	return nil
 }
 
-// WritHintRecord 数据 Hint 文件写入方法
-func (df *DataFile) WritHintRecord(key []byte, pos *LogRecordPos) error {
+// WritHintRecord 数据 Hint 文件写入方法，namespace 为 key 所属的命名空间 id
+func (df *DataFile) WritHintRecord(key []byte, namespace uint32, pos *LogRecordPos) error {
 
 	// 构造 logrecord 信息
 	record := &LogRecord{
-		Key:   key,
-		Value: EncodeLogRecordPos(pos),
+		Key:       key,
+		Value:     EncodeLogRecordPos(pos),
+		Namespace: namespace,
 	}
 
-	encRecord, _ := EncodeLogRecord(record)
+	encRecord, _, err := EncodeEncryptedLogRecord(record, df.Encryptor)
+	if err != nil {
+		return err
+	}
 	return df.Write(encRecord)
 }
 
@@ -196,3 +339,13 @@ func (df *DataFile) readNBytes(n int64, offset int64) (b []byte, err error) {
 	_, err = df.IoManager.Read(b, offset)
 	return
 }
+
+// isZeroBytes 判断字节数组是否全部为 0
+func isZeroBytes(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
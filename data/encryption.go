@@ -0,0 +1,116 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrEncryptionKeyRequired = errors.New("log record is encrypted but no encryption key provider is configured")
+	ErrWrongEncryptionKey    = errors.New("failed to decrypt log record, the encryption key is wrong")
+)
+
+// AES-GCM 的 nonce 和认证标签长度，加密后的 key/value 比明文多出的字节数
+const (
+	encryptionNonceSize = 12
+	encryptionTagSize   = 16
+	encryptionOverhead  = encryptionNonceSize + encryptionTagSize
+)
+
+// KeyProvider 提供加密数据使用的密钥
+// 新写入的数据使用 CurrentKeyID 对应的密钥，读取时根据记录中的 key id 查找密钥
+// 轮换密钥时先让 CurrentKeyID 返回新的 id，merge 完成之后旧的密钥才可以下线
+type KeyProvider interface {
+	// CurrentKeyID 当前用于加密的密钥 id
+	CurrentKeyID() uint32
+
+	// Key 获取 id 对应的密钥，长度必须为 16、24 或 32 字节（AES-128/192/256）
+	Key(id uint32) ([]byte, error)
+}
+
+// Encryptor 使用 AES-GCM 对 LogRecord 的 key/value 进行认证加密
+type Encryptor struct {
+	provider KeyProvider
+	mu       *sync.RWMutex
+	aeads    map[uint32]cipher.AEAD /* 已经初始化的密钥 */
+}
+
+// NewEncryptor 创建 Encryptor，provider 为 nil 时返回 nil 表示不加密
+func NewEncryptor(provider KeyProvider) *Encryptor {
+	if provider == nil {
+		return nil
+	}
+	return &Encryptor{
+		provider: provider,
+		mu:       new(sync.RWMutex),
+		aeads:    make(map[uint32]cipher.AEAD),
+	}
+}
+
+// getAEAD 获取 key id 对应的 AEAD
+func (e *Encryptor) getAEAD(keyId uint32) (cipher.AEAD, error) {
+
+	e.mu.RLock()
+	aead, ok := e.aeads[keyId]
+	e.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	key, err := e.provider.Key(keyId)
+	if err != nil {
+		return nil, fmt.Errorf("get encryption key %d: %w", keyId, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key %d: %w", keyId, err)
+	}
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.aeads[keyId] = aead
+	e.mu.Unlock()
+	return aead, nil
+}
+
+// seal 加密 plaintext 并追加到 dst 中，additionalData 一同参与认证
+/* | nonce | ciphertext + tag | */
+func (e *Encryptor) seal(dst []byte, keyId uint32, plaintext, additionalData []byte) ([]byte, error) {
+
+	aead, err := e.getAEAD(keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, encryptionNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, additionalData), nil
+}
+
+// open 解密 seal 得到的数据
+func (e *Encryptor) open(keyId uint32, sealed, additionalData []byte) ([]byte, error) {
+
+	aead, err := e.getAEAD(keyId)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < encryptionOverhead {
+		return nil, ErrWrongEncryptionKey
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:encryptionNonceSize], sealed[encryptionNonceSize:], additionalData)
+	if err != nil {
+		return nil, ErrWrongEncryptionKey
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeyProvider 测试用的 KeyProvider
+type testKeyProvider struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func (p *testKeyProvider) CurrentKeyID() uint32 {
+	return p.current
+}
+
+func (p *testKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return key, nil
+}
+
+func TestDataFile_ReadLogRecord_Encrypted(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	provider := &testKeyProvider{current: 1, keys: map[uint32][]byte{1: bytes.Repeat([]byte("k"), 32)}}
+	dataFile.Encryptor = NewEncryptor(provider)
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask kv go"), Expire: 100}
+	encBytes, size, err := EncodeEncryptedLogRecord(rec, dataFile.Encryptor)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(encBytes, rec.Value))
+	assert.Nil(t, dataFile.Write(encBytes))
+
+	// 正确的密钥
+	readRec, readSize, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, size, readSize)
+	assert.Equal(t, rec.Key, readRec.Key)
+	assert.Equal(t, rec.Value, readRec.Value)
+	assert.Equal(t, rec.Expire, readRec.Expire)
+
+	// 错误的密钥
+	dataFile.Encryptor = NewEncryptor(&testKeyProvider{current: 1, keys: map[uint32][]byte{1: bytes.Repeat([]byte("x"), 32)}})
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrWrongEncryptionKey, err)
+
+	// 没有配置密钥
+	dataFile.Encryptor = nil
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+}
@@ -13,15 +13,43 @@ const (
 	LogRecordTxnFinished
 )
 
-/* crc + type + keySize + valSize */
-/*  4  +  1   +    5    +    5    = 15*/
-const maxLogRecordHeaderSize = (4 + 1) + binary.MaxVarintLen32*2
+/* crc + type + keySize + valSize + expire + codec + namespace + origin + keyId */
+/*  4  +  1   +    5    +    5    +   10   +   1   +     5     +   10   +   5   = 46*/
+const maxLogRecordHeaderSize = (4 + 1) + binary.MaxVarintLen32*4 + binary.MaxVarintLen64*2 + 1
+
+// type 字节的最高位标识 header 中是否携带过期时间，不带过期时间的记录编码与旧格式完全一致
+const logRecordExpireFlag byte = 1 << 7
+
+// type 字节的次高位标识 value 是否经过压缩，压缩算法记录在 header 中
+const logRecordCompressFlag byte = 1 << 6
+
+// type 字节的第 6 位标识 key/value 是否经过加密，加密使用的密钥 id 记录在 header 中
+const logRecordEncryptFlag byte = 1 << 5
+
+// type 字节的第 5 位标识记录属于某个命名空间，命名空间 id 记录在 header 中
+const logRecordNamespaceFlag byte = 1 << 4
+
+// type 字节的第 4 位标识 value 是指向 blob 文件的指针，真正的 value 存储在 blob 文件中
+const logRecordBlobFlag byte = 1 << 3
+
+// type 字节的第 3 位标识记录是由 compaction 或者 blob 回收重写的，最初写入的位置记录在 header 中
+const logRecordRewrittenFlag byte = 1 << 2
+
+// type 字节中所有标识位
+const logRecordFlags = logRecordExpireFlag | logRecordCompressFlag | logRecordEncryptFlag | logRecordNamespaceFlag |
+	logRecordBlobFlag | logRecordRewrittenFlag
 
 // LogRecord 写入到数据文件的记录（数据文件中数据的写入是追加的）
 type LogRecord struct {
-	Key   []byte        /* 数据库存储的键 */
-	Value []byte        /* 数据库存储的值 */
-	Type  LogRecordType /* 该条信息对应的类型 */
+	Key    []byte          /* 数据库存储的键 */
+	Value  []byte          /* 数据库存储的值 */
+	Type   LogRecordType   /* 该条信息对应的类型 */
+	Expire int64           /* 过期时间（UnixNano），0 表示永不过期 */
+	Codec  CompressionType /* Value 使用的压缩算法，读取时已经解压，该字段为 CompressionNone */
+
+	Namespace uint32 /* 记录所属的命名空间 id，0 表示默认命名空间 */
+	Blob      bool   /* Value 是否为指向 blob 文件的指针（编码后的 LogRecordPos） */
+	Origin    uint64 /* 重写的记录最初写入的位置（事件序列号），0 表示不是重写的记录 */
 }
 
 // LogRecordPos 数据内存索引， 主要是描述磁盘上的数据
@@ -29,14 +57,22 @@ type LogRecordPos struct {
 	Fid    uint32 /* Fid 文件标识，表示将数据存储到哪个文件中 */
 	Offset int64  /* Offset 偏移量，表示将数据存储到对应文件的哪个位置（第几行） */
 	Size   uint32 /* 标识数据在磁盘上的大小 */
+	Expire int64  /* 过期时间（UnixNano），0 表示永不过期 */
 }
 
 // LogRecord 的头部信息
 type logRecordHeader struct {
-	crc        uint32        /* crc 校验值 */
-	recordType LogRecordType /* 该条 LogRecord 对应的类型 */
-	keySize    uint32        /* key 对应的长度 */
-	valueSize  uint32        /* value 对应的长度 */
+	crc        uint32          /* crc 校验值 */
+	recordType LogRecordType   /* 该条 LogRecord 对应的类型 */
+	keySize    uint32          /* key 对应的长度 */
+	valueSize  uint32          /* value 对应的长度 */
+	expire     int64           /* 过期时间，仅在 type 带有过期标识时存在 */
+	codec      CompressionType /* value 的压缩算法，仅在 type 带有压缩标识时存在 */
+	namespace  uint32          /* 命名空间 id，仅在 type 带有命名空间标识时存在 */
+	origin     uint64          /* 最初写入的位置，仅在 type 带有重写标识时存在 */
+	blob       bool            /* value 是否为 blob 指针 */
+	encrypted  bool            /* key/value 是否经过加密 */
+	keyId      uint32          /* 加密使用的密钥 id，仅在 type 带有加密标识时存在 */
 }
 
 // TransactionRecord 暂存事务相关的数据
@@ -47,17 +83,33 @@ type TransactionRecord struct {
 
 // EncodeLogRecord 对 LogRecord 结构进行编码，返回字节数组和长度
 /*
-| crc 校验值 | type 类型 |   key size   |  value size  |   key   |  value |
-|    4字节   |  1 字节   | 变长（最大5） | 变长（最大5） |  变长   |  变长  |
+| crc 校验值 | type 类型 |   key size   |  value size  |     expire     |  codec  |   namespace   |     origin     |     key id    |   key   |  value |
+|    4字节   |  1 字节   | 变长（最大5） | 变长（最大5） | 变长（最大10） |  1 字节  | 变长（最大5）  | 变长（最大10） | 变长（最大5）  |  变长   |  变长  |
+expire 仅在 Expire 不为 0 时写入，并在 type 的最高位做标识
+codec 仅在 Codec 不为 CompressionNone 时写入（此时 Value 应当是压缩后的数据），并在 type 的次高位做标识
+namespace 仅在 Namespace 不为 0 时写入，并在 type 的第 5 位做标识
+value 为 blob 指针时在 type 的第 4 位做标识，不占用额外的 header 空间
+origin 仅在 Origin 不为 0 时写入，并在 type 的第 3 位做标识
+key id 仅在加密时写入，此时 key 和 value 作为一个整体加密，存储为 | nonce | 密文 | 认证标签 |
 */
 /* logRecordHeader --> []byte */
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	encBytes, size, _ := EncodeEncryptedLogRecord(logRecord, nil)
+	return encBytes, size
+}
+
+// EncodeEncryptedLogRecord 使用 enc 加密并编码 LogRecord，enc 为 nil 时与 EncodeLogRecord 相同
+// header 同样作为附加数据参与认证，保证过期时间等信息不被篡改
+func EncodeEncryptedLogRecord(logRecord *LogRecord, enc *Encryptor) ([]byte, int64, error) {
 
 	// 初始化 header 部分的字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	// type 的存储
 	header[4] = logRecord.Type
+	if logRecord.Blob {
+		header[4] |= logRecordBlobFlag
+	}
 
 	var index = 5
 
@@ -65,6 +117,53 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
 
+	// 设置了过期时间才写入 expire
+	if logRecord.Expire != 0 {
+		header[4] |= logRecordExpireFlag
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+
+	// value 经过压缩才写入 codec
+	if logRecord.Codec != CompressionNone {
+		header[4] |= logRecordCompressFlag
+		header[index] = logRecord.Codec
+		index++
+	}
+
+	// 属于命名空间的记录才写入命名空间 id
+	if logRecord.Namespace != 0 {
+		header[4] |= logRecordNamespaceFlag
+		index += binary.PutUvarint(header[index:], uint64(logRecord.Namespace))
+	}
+
+	// 重写的记录才写入最初写入的位置
+	if logRecord.Origin != 0 {
+		header[4] |= logRecordRewrittenFlag
+		index += binary.PutUvarint(header[index:], logRecord.Origin)
+	}
+
+	// 加密时写入密钥 id，并将 key 和 value 整体加密
+	if enc != nil {
+		keyId := enc.provider.CurrentKeyID()
+		header[4] |= logRecordEncryptFlag
+		index += binary.PutUvarint(header[index:], uint64(keyId))
+
+		plaintext := make([]byte, len(logRecord.Key)+len(logRecord.Value))
+		copy(plaintext, logRecord.Key)
+		copy(plaintext[len(logRecord.Key):], logRecord.Value)
+
+		encBytes := make([]byte, index, index+len(plaintext)+encryptionOverhead)
+		copy(encBytes, header[:index])
+		encBytes, err := enc.seal(encBytes, keyId, plaintext, encBytes[4:index])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		crc := crc32.ChecksumIEEE(encBytes[4:])
+		binary.LittleEndian.PutUint32(encBytes[:4], crc)
+		return encBytes, int64(len(encBytes)), nil
+	}
+
 	// 计算出该条 LogRecord 的字节大小
 	var size = index + len(logRecord.Key) + len(logRecord.Value)
 
@@ -86,17 +185,20 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 
 	// fmt.Printf("header length : %d, crc : %d\n", index, crc)
 
-	return encBytes, int64(size)
+	return encBytes, int64(size), nil
 }
 
 // EncodeLogRecordPos 对索引信息进行编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
 
-	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
 	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	if pos.Expire != 0 {
+		index += binary.PutVarint(buf[index:], pos.Expire)
+	}
 
 	return buf[:index]
 }
@@ -109,11 +211,19 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(buf[index:])
 	index += n
-	size, _ := binary.Varint(buf[index:])
+	size, n := binary.Varint(buf[index:])
+	index += n
+
+	// 旧格式的索引信息不包含过期时间
+	var expire int64
+	if index < len(buf) {
+		expire, _ = binary.Varint(buf[index:])
+	}
 	return &LogRecordPos{
 		Fid:    uint32(fileId),
 		Offset: offset,
 		Size:   uint32(size),
+		Expire: expire,
 	}
 }
 
@@ -128,19 +238,76 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 
 	header := &logRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] &^ logRecordFlags,
+		blob:       buf[4]&logRecordBlobFlag != 0,
 	}
 
 	// 从字节数组取出对应的 key 和 value
 	var index = 5
 	keySize, n := binary.Varint(buf[index:])
+	if n <= 0 || keySize < 0 {
+		return nil, 0
+	}
 	header.keySize = uint32(keySize)
 	index += n
 
 	valueSize, n := binary.Varint(buf[index:])
+	if n <= 0 || valueSize < 0 {
+		return nil, 0
+	}
 	header.valueSize = uint32(valueSize)
 	index += n
 
+	// 带有过期标识则继续解码过期时间
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.expire = expire
+		index += n
+	}
+
+	// 带有压缩标识则继续解码压缩算法
+	if buf[4]&logRecordCompressFlag != 0 {
+		if index >= len(buf) {
+			return nil, 0
+		}
+		header.codec = buf[index]
+		index++
+	}
+
+	// 带有命名空间标识则继续解码命名空间 id
+	if buf[4]&logRecordNamespaceFlag != 0 {
+		namespace, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.namespace = uint32(namespace)
+		index += n
+	}
+
+	// 带有重写标识则继续解码最初写入的位置
+	if buf[4]&logRecordRewrittenFlag != 0 {
+		origin, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.origin = origin
+		index += n
+	}
+
+	// 带有加密标识则继续解码密钥 id
+	if buf[4]&logRecordEncryptFlag != 0 {
+		keyId, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.encrypted = true
+		header.keyId = uint32(keyId)
+		index += n
+	}
+
 	return header, int64(index)
 }
 
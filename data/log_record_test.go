@@ -109,3 +109,53 @@ func Test_getLogRecordCRC(t *testing.T) {
 	assert.Equal(t, uint32(290887979), crc3)
 
 }
+
+func TestEncodeLogRecord_Expire(t *testing.T) {
+
+	/* 带过期时间的记录 */
+	logRecord := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	encodedBytes, size := EncodeLogRecord(logRecord)
+	assert.NotNil(t, encodedBytes)
+
+	h, headerSize := decodeLogRecordHeader(encodedBytes)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordNormal, h.recordType)
+	assert.Equal(t, logRecord.Expire, h.expire)
+	assert.Equal(t, size, headerSize+int64(h.keySize)+int64(h.valueSize))
+
+	/* 位置索引中的过期时间 */
+	pos := &LogRecordPos{Fid: 1, Offset: 100, Size: 24, Expire: logRecord.Expire}
+	assert.Equal(t, pos, DecodeLogRecordPos(EncodeLogRecordPos(pos)))
+}
+
+func TestEncodeLogRecord_Namespace(t *testing.T) {
+
+	/* 属于命名空间的删除记录 */
+	logRecord := &LogRecord{
+		Key:       []byte("name"),
+		Type:      LogRecordDeleted,
+		Expire:    1700000000000000000,
+		Namespace: 300,
+	}
+	encodedBytes, size := EncodeLogRecord(logRecord)
+	assert.NotNil(t, encodedBytes)
+
+	h, headerSize := decodeLogRecordHeader(encodedBytes)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordDeleted, h.recordType)
+	assert.Equal(t, logRecord.Expire, h.expire)
+	assert.Equal(t, logRecord.Namespace, h.namespace)
+	assert.Equal(t, size, headerSize+int64(h.keySize)+int64(h.valueSize))
+
+	/* 默认命名空间的记录编码不变 */
+	logRecord.Namespace = 0
+	plainBytes, plainSize := EncodeLogRecord(logRecord)
+	assert.Equal(t, size-2, plainSize)
+	h, _ = decodeLogRecordHeader(plainBytes)
+	assert.Equal(t, uint32(0), h.namespace)
+}
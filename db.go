@@ -7,13 +7,13 @@ import (
 	"bitcask-go/utils"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofrs/flock"
 )
@@ -36,23 +36,91 @@ type DB struct {
 	isInitial       bool                      /* 标识是否为第一次初始化存储数据的目录 */
 
 	/* 优化所需 */
-	flieLock    *flock.Flock /* 文件锁，保证多进程之间的互斥 */
-	bytesWrite  uint         /* 记录当前已经写入多少字节 */
-	reclaimSize int64        /* 表示有多少数据是无效的 */
+	flieLock    *flock.Flock         /* 文件锁，保证多进程之间的互斥 */
+	bytesWrite  uint                 /* 记录当前已经写入多少字节 */
+	reclaimSize int64                /* 表示有多少数据是无效的 */
+	fileStats   map[uint32]*fileStat /* 每个数据文件中无效数据的统计 */
+
+	encryptor *data.Encryptor /* 数据加密，nil 表示不加密 */
+
+	activeHints      []hintEntry /* 活跃文件中所有记录的索引信息，文件封存时写入 hint */
+	activeHintsValid bool        /* activeHints 是否完整包含了活跃文件中的记录 */
+
+	commitMu    *sync.Mutex      /* 保护组提交队列 */
+	commitQueue []*commitRequest /* 等待组提交的写入 */
+	committing  bool             /* 是否已经有 leader 在处理组提交 */
+	syncing     bool             /* leader 是否在释放 db.mu 之后持久化，由 db.mu 保护 */
+	heldEvents  [][]Event        /* leader 持久化期间其他写入产生的事件，在该组提交的事件之后发送，由 db.mu 保护 */
+
+	rawValueSize        int64 /* 打开以来写入的 value 压缩前的大小 */
+	compressedValueSize int64 /* 打开以来写入的 value 实际存储的大小 */
+
+	recoveryDuration time.Duration /* 启动时恢复内存索引的耗时 */
+
+	/* 后台自动 merge */
+	autoMergeCloseCh chan struct{} /* 通知后台 merge 协程退出 */
+	autoMergeDoneCh  chan struct{} /* 后台 merge 协程已经退出 */
+	lastAutoMerge    autoMergeStat /* 最近一次自动 merge 的统计信息 */
+
+	pinnedFiles  map[uint32]int            /* 被快照引用的数据文件及其引用计数，引用期间不能删除 */
+	retiredFiles map[uint32]*data.DataFile /* 已经被 merge 替换但仍然被引用的数据文件，解除引用之后删除 */
+
+	watchers  map[uint64]*watcher /* 数据变更事件的订阅者 */
+	watcherId uint64              /* 订阅者 id 分配 */
+
+	writeVersion uint64            /* 用户写入的版本号，每写入一个 key 递增，merge 等重写数据不改变 */
+	keyVersions  map[string]uint64 /* 读写事务进行期间被写入的 key 及其最新版本，用于事务的冲突检测 */
+	activeTxns   map[*Txn]struct{} /* 还没有结束的读写事务 */
+
+	historyFileId  uint32 /* 从该文件开始保留了完整的写入历史，之前的文件由 merge 重写，删除的数据已经被清理 */
+	readOnly       bool   /* 是否拒绝写入，用于复制的从节点 */
+	replicaWriting bool   /* 正在应用复制的数据，只读时也允许写入（需要持有 db.mu） */
+	replicating    int    /* 运行中的复制服务数量，期间不能创建命名空间（需要持有 db.mu） */
+
+	pendingTxnRecords map[uint64][]*data.TransactionRecord /* 只读模式下还没有读到完成标识的事务数据 */
+
+	valueCache *valueCache /* value 缓存，nil 表示不使用 */
+
+	/* key-value 分离相关 */
+	blobFiles      map[uint32]*data.DataFile /* 所有的 blob 文件，包括活跃的 blob 文件 */
+	activeBlobFile *data.DataFile            /* 当前写入的 blob 文件，只读模式下为 nil */
+	blobDirty      bool                      /* 活跃的 blob 文件中是否有没有持久化的写入 */
+	retiredBlobs   map[uint32]*data.DataFile /* 已经被回收但仍然可能被读取的 blob 文件，没有被引用的数据文件之后删除 */
+	isBlobGC       bool                      /* 是否正在回收 blob 文件 */
+
+	namespaces      map[uint32]*Namespace /* 命名空间，按照 id 索引 */
+	nextNamespaceId uint32                /* 下一个命名空间 id，删除的命名空间 id 不会复用 */
 
 	fileIds []int /* 文件 id （方便复用，禁止其余地方使用） */
 }
 
 // Stat 存储存储引擎统计信息
 type Stat struct {
-	KeyNum          uint  /* key 的总数量 */
+	KeyNum          uint  /* 没有过期的 key 的数量，已经过期但尚未回收的 key 不计入 */
 	DataFileNum     uint  /* 数据文件的数量 */
 	ReclaimableSize int64 /* 可以进行 merge 回收的数据量， byte 单位 */
 	DiskSize        int64 /* 数据目录所占磁盘空间大小 */
+
+	LastAutoMergeTime      time.Time     /* 最近一次自动 merge 的开始时间 */
+	LastAutoMergeDuration  time.Duration /* 最近一次自动 merge 的耗时 */
+	LastAutoMergeReclaimed int64         /* 最近一次自动 merge 回收的空间大小， byte 单位 */
+
+	RawValueSize        int64   /* 打开以来写入的 value 压缩前的大小 */
+	CompressedValueSize int64   /* 打开以来写入的 value 实际存储的大小 */
+	CompressionRatio    float64 /* 压缩率，实际存储大小 / 压缩前大小，没有写入时为 1 */
+
+	RecoveryDuration time.Duration /* 启动时从 hint 和数据文件中恢复内存索引的耗时 */
+
+	BlobFileNum uint /* key-value 分离的 blob 文件数量 */
+
+	ValueCacheHits   uint64 /* 打开以来 value 缓存的命中次数 */
+	ValueCacheMisses uint64 /* 打开以来 value 缓存的未命中次数 */
+
+	BloomFalsePositiveRate float64 /* 布隆过滤器当前估计的误判率，没有开启时为 0 */
 }
 
 // 启动存储引擎实例的方法
-func Open(options Options) (*DB, error) {
+func Open(options Options) (_ *DB, err error) {
 
 	// 对用户传入的配置项进行校\验
 	if err := checkOptions(options); err != nil {
@@ -61,8 +129,11 @@ func Open(options Options) (*DB, error) {
 
 	var isInitial bool
 
-	// 判断数据目录是否存在，如果不存在，需要创建目录
+	// 判断数据目录是否存在，如果不存在，需要创建目录（只读模式不创建）
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
@@ -70,15 +141,26 @@ func Open(options Options) (*DB, error) {
 	}
 
 	// 判断当前数据目录是否在正常使用
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
-	if err != nil {
-		return nil, err
-	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
+	// 只读模式不持有文件锁，也不创建锁文件，可以与写入的进程以及其他只读的进程同时打开，写入方也无法感知只读的进程
+	var fileLock *flock.Flock
+	if !options.ReadOnly {
+		fileLock = flock.New(filepath.Join(options.DirPath, fileLockName))
+		hold, err := fileLock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
 	}
 
+	// 启动失败时释放文件锁，允许修正配置（例如密钥）后重新打开
+	defer func() {
+		if err != nil && fileLock != nil {
+			_ = fileLock.Unlock()
+		}
+	}()
+
 	entries, err := os.ReadDir(options.DirPath)
 	if err != nil {
 		return nil, err
@@ -89,28 +171,84 @@ func Open(options Options) (*DB, error) {
 
 	// 初始化 DB 实例数据
 	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndex(options.IndexType, options.DirPath, options.SyncWrites), // 在此出现死锁
-		isInitial:  isInitial,
-		flieLock:   fileLock,
-	}
+		options:      options,
+		mu:           new(sync.RWMutex),
+		olderFiles:   make(map[uint32]*data.DataFile),
+		index:        index.NewIndex(options.IndexType, options.DirPath, options.SyncWrites, options.Comparator), // 在此出现死锁
+		isInitial:    isInitial,
+		flieLock:     fileLock,
+		pinnedFiles:  make(map[uint32]int),
+		retiredFiles: make(map[uint32]*data.DataFile),
+		fileStats:    make(map[uint32]*fileStat),
+		watchers:     make(map[uint64]*watcher),
+		keyVersions:  make(map[string]uint64),
+		activeTxns:   make(map[*Txn]struct{}),
+		encryptor:    data.NewEncryptor(options.Encryption),
+		commitMu:     new(sync.Mutex),
+		readOnly:     options.ReadOnly,
+		valueCache:   newValueCache(options.ValueCacheSize),
+		blobFiles:    make(map[uint32]*data.DataFile),
+		retiredBlobs: make(map[uint32]*data.DataFile),
+	}
+
+	// 启动失败时关闭已经打开的索引和数据文件
+	defer func() {
+		if err != nil {
+			db.closeFiles()
+		}
+	}()
 
-	// 加载 merge 数据目录
-	if err := db.loadMergeFiles(); err != nil {
+	// 校验 key 的比较规则与数据目录中记录的是否一致
+	if err := db.checkComparator(); err != nil {
 		return nil, err
 	}
 
+	// 只读模式不修改数据目录，merge 相关的文件交由写入的进程处理
+	if !options.ReadOnly {
+
+		// 加载 merge 数据目录
+		if err := db.loadMergeFiles(); err != nil {
+			return nil, err
+		}
+
+		// 删除已经被 merge 替换但没有来得及删除的旧数据文件
+		if err := db.removeMergedDataFiles(); err != nil {
+			return nil, err
+		}
+	} else {
+
+		// 记录最近一次 merge，Refresh 时据此判断写入方是否又进行了 merge
+		if db.historyFileId, err = db.mergedHistoryFileId(); err != nil {
+			return nil, err
+		}
+	}
+
 	// 加载数据文件
 	if err := db.loadDataFiles(); err != nil {
 		// fmt.Println("loadDataFiles")
 		return nil, err
 	}
 
+	// 校验加密配置与数据文件是否匹配
+	if err := db.checkEncryption(); err != nil {
+		return nil, err
+	}
+
+	// 加载 key-value 分离的 blob 文件
+	if err := db.loadBlobFiles(); err != nil {
+		return nil, err
+	}
+
+	// 加载命名空间，之后才能将数据恢复到对应的索引中
+	if err := db.loadNamespaces(); err != nil {
+		return nil, err
+	}
+
 	// 如果不为 B+ 树索引才需要加载
 	if options.IndexType != BPTree {
 
+		recoveryStart := time.Now()
+
 		// 从 hint 索引文件中加载索引
 		if err := db.loadIndexFromHintFile(); err != nil {
 			// fmt.Println("loadIndexFromHintFile")
@@ -122,6 +260,7 @@ func Open(options Options) (*DB, error) {
 			// fmt.Println("loadIndexFromDataFiles")
 			return nil, err
 		}
+		db.recoveryDuration = time.Since(recoveryStart)
 
 		// 重置 IO 为标准 IO 类型（mmap 仅对启动 db 时加速）
 		if db.options.MMapAtStartup {
@@ -145,47 +284,74 @@ func Open(options Options) (*DB, error) {
 		}
 	}
 
+	// 加载或者构建布隆过滤器
+	if err := db.loadBloomFilter(); err != nil {
+		return nil, err
+	}
+
+	// 启动后台自动 merge
+	if !options.ReadOnly {
+		db.startAutoMerge()
+	}
+
 	return db, nil
 }
 
 // Close 关闭数据库
 func (db *DB) Close() error {
 
-	// 释放文件锁
+	// 释放文件锁，只读模式没有持有文件锁
 	defer func() {
+		if db.flieLock == nil {
+			return
+		}
 		if err := db.flieLock.Unlock(); err != nil {
 			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
 		}
 	}()
 
+	// 先停止后台自动 merge，避免其与关闭流程争抢锁
+	db.stopAutoMerge()
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	// 终止所有的订阅
+	for id, w := range db.watchers {
+		delete(db.watchers, id)
+		close(w.buf)
+	}
+
+	// 保存布隆过滤器，下次启动时不需要重新构建
+	if err := db.saveBloomFilter(); err != nil {
+		return err
+	}
+
 	// 关闭索引
 	if err := db.index.Close(); err != nil {
 		return err
 	}
+	for _, ns := range db.namespaces {
+		if err := ns.index.Close(); err != nil {
+			return err
+		}
+	}
+
+	// 关闭 blob 文件
+	if err := db.closeBlobFiles(); err != nil {
+		return err
+	}
 
 	// 在此之前一定要先关闭 index，不然如果为 b+ 树索引，会导致 index 对应锁未关闭从而在 index 相关逻辑时阻塞
 	if db.activeFile == nil {
 		return nil
 	}
 
-	// 保存事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
-		return err
-	}
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
-	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encRecord); err != nil {
-		return err
-	}
-	if err := seqNoFile.Sync(); err != nil {
-		return err
+	// 保存事务序列号，只读模式不写入任何文件
+	if !db.options.ReadOnly {
+		if err := db.saveSeqNo(db.options.DirPath, db.seqNo); err != nil {
+			return err
+		}
 	}
 
 	// 关闭数据库活跃文件
@@ -200,9 +366,41 @@ func (db *DB) Close() error {
 		}
 	}
 
+	// 关闭已经被 merge 替换的文件，下次启动时删除
+	for _, file := range db.retiredFiles {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// saveSeqNo 将事务序列号保存到 dirPath 目录的序列号文件中
+func (db *DB) saveSeqNo(dirPath string, seqNo uint64) error {
+
+	seqNoFile, err := data.OpenSeqNoFile(dirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = seqNoFile.Close()
+	}()
+	record := &data.LogRecord{
+		Key:   []byte(seqNoKey),
+		Value: []byte(strconv.FormatUint(seqNo, 10)),
+	}
+	seqNoFile.Encryptor = db.encryptor
+	encRecord, _, err := data.EncodeEncryptedLogRecord(record, db.encryptor)
+	if err != nil {
+		return err
+	}
+	if err := seqNoFile.Write(encRecord); err != nil {
+		return err
+	}
+	return seqNoFile.Sync()
+}
+
 // Sync 持久化数据库文件
 func (db *DB) Sync() error {
 
@@ -212,10 +410,10 @@ func (db *DB) Sync() error {
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.activeFile.Sync()
+	return db.syncActiveFiles()
 }
 
-// Stat 返回数据存储引擎相关的统计信息
+// Stat 返回数据存储引擎相关的统计信息，统计 key 的数量需要遍历一次内存索引
 func (db *DB) Stat() *Stat {
 
 	db.mu.RLock()
@@ -231,19 +429,49 @@ func (db *DB) Stat() *Stat {
 		panic(fmt.Sprintf("failed to get dir size : %v", err))
 	}
 
+	var compressionRatio float64 = 1
+	if db.rawValueSize > 0 {
+		compressionRatio = float64(db.compressedValueSize) / float64(db.rawValueSize)
+	}
+
+	var cacheHits, cacheMisses uint64
+	if db.valueCache != nil {
+		cacheHits, cacheMisses = db.valueCache.stat()
+	}
+
 	return &Stat{
-		KeyNum:          uint(db.index.Size()),
-		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimSize,
-		DiskSize:        dirSize,
+		KeyNum:                 db.liveKeyNum(),
+		DataFileNum:            dataFiles,
+		ReclaimableSize:        db.reclaimSize,
+		DiskSize:               dirSize,
+		LastAutoMergeTime:      db.lastAutoMerge.startTime,
+		LastAutoMergeDuration:  db.lastAutoMerge.duration,
+		LastAutoMergeReclaimed: db.lastAutoMerge.reclaimed,
+		RawValueSize:           db.rawValueSize,
+		CompressedValueSize:    db.compressedValueSize,
+		CompressionRatio:       compressionRatio,
+		RecoveryDuration:       db.recoveryDuration,
+		BlobFileNum:            uint(len(db.blobFiles)),
+		ValueCacheHits:         cacheHits,
+		ValueCacheMisses:       cacheMisses,
+		BloomFalsePositiveRate: db.bloomFalsePositiveRate(),
 	}
 }
 
-// 拷贝数据库（优化 备份）
-func (db *DB) Backup(dir string) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+// liveKeyNum 统计内存索引中没有过期的 key 的数量（需要持有 db.mu）
+func (db *DB) liveKeyNum() uint {
+
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+
+	var keyNum uint
+	now := time.Now().UnixNano()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if !isExpired(iterator.Value(), now) {
+			keyNum++
+		}
+	}
+	return keyNum
 }
 
 // Put 数据存储引擎对外提供的操作方法，以追加的方式将数据写入活跃文件（key 不能为空）
@@ -261,22 +489,13 @@ func (db *DB) Put(key []byte, value []byte) error {
 		Type:  data.LogRecordNormal,
 	}
 
-	// 追加写入到活跃的数据文件
-	pos, err := db.appendLogRecordWithLock(logRecord)
-	if err != nil {
-		return err
-	}
-
-	// fmt.Println("A")
-	// 追加成功则将信息更新到内存索引中
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		// fmt.Println("A")
-		db.reclaimSize += int64(oldPos.Size)
-		// return ErrIndexUpdateFailed
-	}
-	// fmt.Println("A")
-
-	return nil
+	// 追加写入到活跃的数据文件，追加成功则在持有锁的情况下将信息更新到内存索引中
+	_, err := db.appendLogRecordWithLock(logRecord, func(pos *data.LogRecordPos) {
+		if oldPos := db.index.Put(key, pos); oldPos != nil {
+			db.addReclaimSize(oldPos)
+		}
+	})
+	return err
 }
 
 // Delete 根据 key 删除对应的数据
@@ -298,21 +517,21 @@ func (db *DB) Delete(key []byte) error {
 		Type: data.LogRecordDeleted,
 	}
 
-	// 写入该条删除标识数据
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	// 写入该条删除标识数据，并从索引中删除对应 key
+	var ok bool
+	_, err := db.appendLogRecordWithLock(logRecord, func(pos *data.LogRecordPos) {
+		db.addTombstoneSize(pos)
+		var oldPos *data.LogRecordPos
+		if oldPos, ok = db.index.Delete(key); oldPos != nil {
+			db.addReclaimSize(oldPos)
+		}
+	})
 	if err != nil {
 		return err
 	}
-	db.reclaimSize += int64(pos.Size)
-
-	// 从索引中删除对应 key
-	oldPos, ok := db.index.Delete(key)
 	if !ok {
 		return ErrIndexUpdateFailed
 	}
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
-	}
 
 	return nil
 }
@@ -329,45 +548,85 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 		return nil, ErrKeyIsEmpty
 	}
 
+	return db.getFromIndex(db.index, key)
+}
+
+// getFromIndex 根据内存索引读取 key 对应的数据（需要持有 db.mu）
+func (db *DB) getFromIndex(idx index.Indexer, key []byte) ([]byte, error) {
+
 	/* 内存索引 -> 数据文件 -> 根据 offset 获得数据 */
 
 	// 先从内存中取出 key 对应的索引信息
-	logRecordPos := db.index.Get(key)
+	logRecordPos := idx.Get(key)
 
 	// 如果 key 无对应索引信息，则 key 不存在
 	if logRecordPos == nil {
 		return nil, ErrKeyNotFound
 	}
 
+	// 已经过期的 key 直接从索引中移除，等待 merge 回收
+	if isExpired(logRecordPos, time.Now().UnixNano()) {
+		if oldPos, _ := idx.Delete(key); oldPos != nil {
+			db.addReclaimSize(oldPos)
+		}
+		return nil, ErrKeyNotFound
+	}
+
 	return db.getValueByPosition(logRecordPos)
 }
 
 // ListKeys 获取数据库中所有的 Key
 func (db *DB) ListKeys() [][]byte {
+	return listKeys(db.index)
+}
 
-	iterator := db.index.Iterator(false)
+// listKeys 获取内存索引中所有没有过期的 key
+func listKeys(idx index.Indexer) [][]byte {
+
+	iterator := idx.Iterator(false)
 	defer iterator.Close()
-	keys := make([][]byte, db.index.Size())
+	keys := make([][]byte, 0, idx.Size())
 
-	// 通过迭代器遍历 BTree 索引树，然后添加到 []byte 数组
-	var idx int = 0
+	// 通过迭代器遍历 BTree 索引树，然后添加到 []byte 数组（跳过已经过期的 key）
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		if isExpired(iterator.Value(), now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
 
 // Fold 获取所有的数据，并且执行用户指定操作(func 返回 false 中止遍历)
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
+	return db.Scan(nil, nil, fn)
+}
+
+// Scan 按顺序遍历 [start, end) 范围内的数据，start / end 为空表示不限制(func 返回 false 中止遍历)
+func (db *DB) Scan(start, end []byte, fn func(key []byte, value []byte) bool) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	return db.scanIndex(db.index, start, end, fn)
+}
 
-	iterator := db.index.Iterator(false)
+// scanIndex 按顺序遍历内存索引中 [start, end) 范围内的数据（需要持有 db.mu）
+func (db *DB) scanIndex(idx index.Indexer, start, end []byte, fn func(key []byte, value []byte) bool) error {
+
+	iterator := idx.RangeIterator(index.IteratorOptions{
+		LowerBound: start,
+		UpperBound: end,
+	})
 	defer iterator.Close()
 
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
 
+		// 跳过已经过期的 key
+		if isExpired(iterator.Value(), now) {
+			continue
+		}
+
 		value, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
 			return err
@@ -382,18 +641,20 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 // getValueByPosition 根据索引信息获取对应的 value
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
 	// 根据文件 id 找到相应的数据文件
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == logRecordPos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.olderFiles[logRecordPos.Fid]
-	}
+	dataFile := db.getDataFile(logRecordPos.Fid)
 
 	// 如果数据文件为空
 	if dataFile == nil {
 		return nil, ErrDataFileNoFound
 	}
 
+	// 优先从缓存中读取
+	if db.valueCache != nil {
+		if value, ok := db.valueCache.get(logRecordPos); ok {
+			return value, nil
+		}
+	}
+
 	// 根据偏移量读取对应的数据
 	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
 	if err != nil {
@@ -405,18 +666,99 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 		return nil, ErrKeyNotFound
 	}
 
+	// value 存储在 blob 文件中
+	if logRecord.Blob {
+		if logRecord.Value, err = db.readBlobValue(logRecord.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	if db.valueCache != nil {
+		db.valueCache.put(logRecordPos, logRecord.Value)
+	}
 	return logRecord.Value, nil
 }
 
-// appendLogRecord 向活跃文件追加数据
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+// getDataFile 根据文件 id 找到相应的数据文件，包括已经被 merge 替换但仍然被引用的文件
+func (db *DB) getDataFile(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
+	}
+	if dataFile, ok := db.olderFiles[fid]; ok {
+		return dataFile
+	}
+	return db.retiredFiles[fid]
+}
+
+// appendLogRecordWithLock 加锁并向活跃文件追加数据，写入成功之后在释放锁之前调用 apply 更新内存索引，然后通知订阅者
+// 开启 SyncWrites 和 GroupCommit 时交由组提交流程，与并发的写入共用一次持久化
+func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord,
+	apply func(pos *data.LogRecordPos)) (*data.LogRecordPos, error) {
+	if db.options.SyncWrites && db.options.GroupCommit {
+		return db.groupCommit(&commitRequest{record: logRecord, apply: apply})
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return nil, err
+	}
+	apply(pos)
+	db.notifyWrite(logRecord, pos)
+	return pos, nil
+}
+
+// appendCheckedLogRecord 与 appendLogRecordWithLock 相同，但需要写入的数据在持有锁之后由 prepare 生成
+// prepare 可以读取 key 当前的值进行检查，返回错误或者 nil 表示不写入，检查与写入之间不会有其他写入
+func (db *DB) appendCheckedLogRecord(prepare func() (*data.LogRecord, error),
+	apply func(pos *data.LogRecordPos)) (*data.LogRecordPos, error) {
+	if db.options.SyncWrites && db.options.GroupCommit {
+		return db.groupCommit(&commitRequest{prepare: prepare, apply: apply})
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	logRecord, err := prepare()
+	if err != nil || logRecord == nil {
+		return nil, err
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return nil, err
+	}
+	apply(pos)
+	db.notifyWrite(logRecord, pos)
+	return pos, nil
 }
 
 // appendLogRecord 向活跃文件追加数据
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	return db.writeLogRecord(logRecord, db.options.SyncWrites)
+}
+
+// writeLogRecord 向活跃文件追加数据，syncWrites 表示写入之后是否需要立即持久化
+// 订阅者由调用方在更新内存索引之后通知，保证收到事件时已经可以读到该写入
+func (db *DB) writeLogRecord(logRecord *data.LogRecord, syncWrites bool) (*data.LogRecordPos, error) {
+
+	if db.readOnly && !db.replicaWriting {
+		return nil, ErrReadOnly
+	}
+
+	pos, err := db.writeLogRecordSilently(logRecord, syncWrites)
+	if err != nil {
+		return nil, err
+	}
+
+	// 记录 key 的写入版本，用于读写事务的冲突检测
+	if logRecord.Namespace == defaultNamespaceId && logRecord.Type != data.LogRecordTxnFinished {
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		db.recordKeyVersion(realKey)
+	}
+
+	return pos, nil
+}
+
+// writeLogRecordSilently 向活跃文件追加数据，不通知订阅者（用于重写已有的数据）
+func (db *DB) writeLogRecordSilently(logRecord *data.LogRecord, syncWrites bool) (*data.LogRecordPos, error) {
 
 	/* 文件写入前的操作 */
 
@@ -428,18 +770,32 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
-	// 写入数据编码
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	// 写入数据编码，value 达到分离阈值则写入 blob 文件，达到压缩阈值则先进行压缩，配置了密钥则进行加密
+	record, err := db.separateValue(logRecord)
+	if err != nil {
+		return nil, err
+	}
+	record, err = db.compressLogRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	encRecord, size, err := data.EncodeEncryptedLogRecord(record, db.encryptor)
+	if err != nil {
+		return nil, err
+	}
 
 	// 如果当前新的数据文件加上现在写入数据已经大于阈值，
 	// 则将新文件变老，同时创建新的数据文件
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
 
 		// 先持久化数据文件，保证已有数据持久化到磁盘中
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFiles(); err != nil {
 			return nil, err
 		}
 
+		// 为封存的数据文件写入 hint，加速下次启动
+		db.writeFileHint()
+
 		// 将当前活跃文件加入老的数据文件组中
 		db.olderFiles[db.activeFile.FileId] = db.activeFile
 
@@ -461,12 +817,12 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	db.bytesWrite += uint(size)
 
 	// 根据用户配置信息确定是否持久化
-	var needSync = db.options.SyncWrites
+	var needSync = syncWrites
 	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
 		needSync = true
 	}
 	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFiles(); err != nil {
 			return nil, err
 		}
 
@@ -481,6 +837,15 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		Fid:    db.activeFile.FileId,
 		Offset: writeOff,
 		Size:   uint32(size),
+		Expire: logRecord.Expire,
+	}
+	if db.activeHintsValid {
+		db.activeHints = append(db.activeHints, hintEntry{
+			key:       logRecord.Key,
+			typ:       logRecord.Type,
+			namespace: logRecord.Namespace,
+			pos:       pos,
+		})
 	}
 
 	return pos, nil
@@ -497,14 +862,23 @@ func (db *DB) setActiveDataFile() error {
 		initialFileId = db.activeFile.FileId + 1
 	}
 
+	return db.setActiveDataFileWithId(initialFileId)
+}
+
+// setActiveDataFileWithId 使用指定的文件 id 打开新的活跃文件
+// 需要加锁
+func (db *DB) setActiveDataFileWithId(fileId uint32) error {
+
 	// 打开新的数据文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fileId, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	dataFile.Encryptor = db.encryptor
 
 	// 设置新的数据文件
 	db.activeFile = dataFile
+	db.activeHints, db.activeHintsValid = nil, true
 
 	return nil
 }
@@ -560,6 +934,7 @@ func (db *DB) loadDataFiles() error {
 			// fmt.Println("OpenDataFile")
 			return err
 		}
+		dataFile.Encryptor = db.encryptor
 
 		// 最后一个文件是 活跃文件
 		if i == len(fileIds)-1 {
@@ -592,106 +967,105 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
-	updataIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-
-		// 如果类型为删除，则从内存索引中删除
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
-		} else {
-			oldPos = db.index.Put(key, pos)
-		}
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
-			// panic("failed to update index at startup")
-		}
-	}
-
 	// 暂存事务数据
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
 	var currentSeqNo = nonTransactionSeqNo
 
-	// 遍历所有文件 id，处理文件中的记录
-	for i, fid := range db.fileIds {
-
-		// 获取对应数据文件
+	// 需要加载的数据文件，小于最近未参加 merge 的文件 id 的已经从 hint 文件加载
+	var dataFiles []*data.DataFile
+	for _, fid := range db.fileIds {
 		var fileId = uint32(fid)
-		var dataFile *data.DataFile
-
-		// 如果比最近未参加 merge 的文件 id 小， 则说明已经从 hint 文件加载
 		if hasMerge && fileId < nonMergeFileId {
 			continue
 		}
-
 		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
+			dataFiles = append(dataFiles, db.activeFile)
 		} else {
-			dataFile = db.olderFiles[fileId]
+			dataFiles = append(dataFiles, db.olderFiles[fileId])
 		}
+	}
 
-		// 循环处理，将数据文件内容加入内存索引
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
-			}
-
-			// 构建内存索引
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
-				Size:   uint32(size),
-			}
-
-			// 解析 Key
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-
-				// 非事务操作，直接更新内存索引
-				updataIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-
-				// 事务完成，对应
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updataIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNo)
-				} else {
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
-				}
-			}
-
-			// 更新事务序列号
-			if seqNo > currentSeqNo {
+	// 并行解码数据文件，按照文件 id 的顺序更新内存索引
+	err := db.decodeDataFiles(dataFiles, func(dataFile *data.DataFile, result *decodedFile) {
+		for _, entry := range result.entries {
+			if seqNo := db.loadIndexEntry(entry, transactionRecords); seqNo > currentSeqNo {
 				currentSeqNo = seqNo
 			}
-
-			// 递增 offset
-			offset += size
 		}
 
-		// 如果当前文件是活跃文件，则需要更新文件的 WriteOff
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		// 如果当前文件是活跃文件，则需要更新文件的 WriteOff，并记录索引信息用于封存时写入 hint
+		if dataFile == db.activeFile {
+			db.activeFile.WriteOff = result.size
+			db.activeHints, db.activeHintsValid = result.entries, true
 		}
+	})
+	if err != nil {
+		return err
 	}
 
 	// 更新事务序列号
 	db.seqNo = currentSeqNo
 
+	// 只读模式下写入方可能正在提交事务，剩余的事务数据在 Refresh 时继续处理
+	if db.options.ReadOnly {
+		db.pendingTxnRecords = transactionRecords
+	}
+
 	return nil
 }
 
+// loadIndexEntry 根据数据文件中的一条记录更新内存索引，返回记录的事务序列号
+// 事务数据暂存在 transactionRecords 中，读到事务完成标识之后才更新索引
+func (db *DB) loadIndexEntry(entry hintEntry, transactionRecords map[uint64][]*data.TransactionRecord) uint64 {
+
+	// 解析 Key
+	realKey, seqNo := parseLogRecordKey(entry.key)
+	if seqNo == nonTransactionSeqNo {
+
+		// 非事务操作，直接更新内存索引
+		db.updateIndexOnLoad(entry.namespace, realKey, entry.typ, entry.pos)
+		return seqNo
+	}
+
+	// 事务完成，对应
+	if entry.typ == data.LogRecordTxnFinished {
+		for _, txnRecord := range transactionRecords[seqNo] {
+			record := txnRecord.Record
+			db.updateIndexOnLoad(record.Namespace, record.Key, record.Type, txnRecord.Pos)
+		}
+		delete(transactionRecords, seqNo)
+	} else {
+		transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+			Record: &data.LogRecord{Key: realKey, Type: entry.typ, Namespace: entry.namespace},
+			Pos:    entry.pos,
+		})
+	}
+	return seqNo
+}
+
+// updateIndexOnLoad 将加载的一条记录更新到所属命名空间的内存索引中
+func (db *DB) updateIndexOnLoad(namespace uint32, key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+
+	// 已经删除的命名空间中的数据都是无效数据
+	idx := db.namespaceIndex(namespace)
+	if idx == nil {
+		db.addReclaimSize(pos)
+		return
+	}
+
+	// 如果类型为删除，则从内存索引中删除
+	var oldPos *data.LogRecordPos
+	if typ == data.LogRecordDeleted {
+		oldPos, _ = idx.Delete(key)
+		db.addTombstoneSize(pos)
+	} else {
+		oldPos = idx.Put(key, pos)
+	}
+	if oldPos != nil {
+		db.addReclaimSize(oldPos)
+	}
+}
+
 // checkOptions 检查配置项是否合理
 func checkOptions(options Options) error {
 
@@ -709,6 +1083,41 @@ func checkOptions(options Options) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
+	if options.CompactionGarbageRatio < 0 || options.CompactionGarbageRatio > 1 {
+		return errors.New("invalid compaction garbage ratio, must between 0 and 1")
+	}
+
+	// 自动 merge 的间隔和时间窗口
+	if options.AutoMergeInterval < 0 {
+		return errors.New("auto merge interval must not be negative")
+	}
+	if options.AutoMergeStartHour < 0 || options.AutoMergeStartHour > 23 ||
+		options.AutoMergeEndHour < 0 || options.AutoMergeEndHour > 23 {
+		return errors.New("invalid auto merge window, hour must between 0 and 23")
+	}
+
+	// B+ 树索引文件只能由一个进程打开
+	if options.ReadOnly && options.IndexType == BPTree {
+		return errors.New("read-only mode does not support the b+ tree index")
+	}
+
+	if options.ValueThreshold < 0 {
+		return errors.New("value threshold must not be negative")
+	}
+	if options.BlobGarbageRatio < 0 || options.BlobGarbageRatio > 1 {
+		return errors.New("invalid blob garbage ratio, must between 0 and 1")
+	}
+
+	if options.BloomFilter && (options.BloomFalsePositiveRate <= 0 || options.BloomFalsePositiveRate >= 1) {
+		return errors.New("invalid bloom filter false positive rate, must between 0 and 1")
+	}
+
+	// 压缩算法需要已经注册
+	if options.Compression != CompressionNone {
+		if _, ok := data.GetCodec(options.Compression); !ok {
+			return data.ErrUnknownCodec
+		}
+	}
 
 	return nil
 }
@@ -727,8 +1136,12 @@ func (db *DB) loadSeqNo() error {
 	if err != nil {
 		return err
 	}
+	seqNoFile.Encryptor = db.encryptor
 
 	record, _, err := seqNoFile.ReadLogRecord(0)
+	if err != nil {
+		return err
+	}
 	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
 	if err != nil {
 		return err
@@ -0,0 +1,72 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"io"
+)
+
+// KeyProvider 提供数据加密使用的密钥
+type KeyProvider = data.KeyProvider
+
+// StaticKeyProvider 使用固定密钥集合的 KeyProvider
+type StaticKeyProvider struct {
+	CurrentID uint32            /* 新数据使用的密钥 id */
+	Keys      map[uint32][]byte /* 所有可用的密钥 */
+}
+
+// CurrentKeyID 当前用于加密的密钥 id
+func (p *StaticKeyProvider) CurrentKeyID() uint32 {
+	return p.CurrentID
+}
+
+// Key 获取 id 对应的密钥
+func (p *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := p.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: key id %d", ErrEncryptionKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// RotateEncryptionKey 使用 KeyProvider 当前的密钥重写所有数据，不受 DataFileMergeRatio 的限制
+// 与 Merge 相同，重写的数据立即生效，旧的数据文件在不再被快照、迭代器引用之后删除，此后旧的密钥才可以下线
+// 未加密的数据库配置密钥之后也可以通过该方法将已有数据全部加密
+func (db *DB) RotateEncryptionKey() error {
+	_, err := db.merge(true)
+	return err
+}
+
+// checkEncryption 读取第一个数据文件的第一条记录，校验密钥是否正确
+// 避免 B+ 树索引等不加载数据文件的场景在读取时才发现密钥错误
+func (db *DB) checkEncryption() error {
+
+	dataFile := db.activeFile
+	if len(db.fileIds) > 0 {
+		if older, ok := db.olderFiles[uint32(db.fileIds[0])]; ok {
+			dataFile = older
+		}
+	}
+	if dataFile == nil {
+		return nil
+	}
+
+	// 数据损坏交由加载索引的流程处理，这里只关心密钥
+	_, _, err := dataFile.ReadLogRecord(0)
+	if err != nil && err != io.EOF && err != data.ErrInvalidCRC {
+		return err
+	}
+	return nil
+}
+
+// closeFiles 关闭索引以及所有的数据文件，用于启动失败时的清理
+func (db *DB) closeFiles() {
+	_ = db.index.Close()
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.olderFiles {
+		_ = file.Close()
+	}
+	_ = db.closeBlobFiles()
+}
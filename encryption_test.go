@@ -0,0 +1,102 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertNoPlaintext 校验目录下的文件中不包含明文
+func assertNoPlaintext(t *testing.T, dir string, plaintext []byte) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		assert.False(t, bytes.Contains(content, plaintext), path)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestDB_Encryption(t *testing.T) {
+
+	provider := &StaticKeyProvider{
+		CurrentID: 1,
+		Keys:      map[uint32][]byte{1: bytes.Repeat([]byte("1"), 32)},
+	}
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DirPath = dir
+	opts.Encryption = provider
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	secret := []byte("top-secret-value")
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), secret))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch"), secret))
+	assert.Nil(t, wb.Commit())
+
+	// 备份的数据同样是加密的
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-encryption-backup")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.Backup(backupDir))
+	assertNoPlaintext(t, backupDir, secret)
+	assertNoPlaintext(t, backupDir, []byte("bitcask-go-key"))
+
+	// 错误的密钥或者没有密钥无法打开
+	assert.Nil(t, db.Close())
+	opts.Encryption = &StaticKeyProvider{
+		CurrentID: 1,
+		Keys:      map[uint32][]byte{1: bytes.Repeat([]byte("x"), 32)},
+	}
+	_, err = Open(opts)
+	assert.Equal(t, ErrWrongEncryptionKey, err)
+	opts.Encryption = nil
+	_, err = Open(opts)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+
+	// 轮换密钥，已有数据使用新密钥重写
+	provider.CurrentID = 2
+	provider.Keys[2] = bytes.Repeat([]byte("2"), 32)
+	opts.Encryption = provider
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.RotateEncryptionKey())
+	assert.Nil(t, db.Close())
+
+	// 重写的数据生效之后旧的密钥可以下线
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	delete(provider.Keys, 1)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, secret, val)
+	}
+	val, err := db.Get([]byte("batch"))
+	assert.Nil(t, err)
+	assert.Equal(t, secret, val)
+	assertNoPlaintext(t, dir, secret)
+}
@@ -1,6 +1,9 @@
 package bitcaskkv
 
-import "errors"
+import (
+	"bitcask-go/data"
+	"errors"
+)
 
 var (
 	ErrKeyIsEmpty             = errors.New("the key is empty")
@@ -13,4 +16,29 @@ var (
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
 	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrMergeFileIdExhausted   = errors.New("the merged data exceeds the file ids reserved for merge")
+	ErrCompactActiveFile      = errors.New("cannot compact the active data file")
+	ErrSnapshotReleased       = errors.New("the snapshot has been released")
+	ErrTxnConflict            = errors.New("transaction conflict, the keys it read have been changed")
+	ErrTxnReadOnly            = errors.New("cannot write in a read-only transaction")
+	ErrTxnClosed              = errors.New("the transaction has been committed or rolled back")
+	ErrValueMismatch          = errors.New("the current value does not match the expected value")
+	ErrKeyExists              = errors.New("the key already exists")
+	ErrWatchCancelled         = errors.New("the watch has been cancelled")
+	ErrEncryptionKeyNotFound  = errors.New("the encryption key is not found")
+	ErrDirNotEmpty            = errors.New("the directory is not empty")
+	ErrBackupCorrupted        = errors.New("the backup is corrupted")
+	ErrImportCorrupted        = errors.New("the import stream is corrupted")
+	ErrInvalidImportBatchSize = errors.New("the import batch size must be positive")
+	ErrReadOnly               = errors.New("the database is read-only")
+	ErrReplicationProtocol    = errors.New("unexpected replication message")
+	ErrNamespaceNameEmpty     = errors.New("the namespace name is empty")
+	ErrNamespaceExists        = errors.New("the namespace already exists")
+	ErrNamespaceNotFound      = errors.New("the namespace is not found")
+	ErrNamespaceUnsupported   = errors.New("namespaces are not supported by the b+ tree index")
+	ErrNamespaceNotReplicated = errors.New("namespaces are not carried by replication or export")
+	ErrComparatorMismatch     = errors.New("comparator does not match the one recorded in the data directory")
+	ErrBlobGCIsProgress       = errors.New("blob garbage collection is in progress, try again later")
+	ErrWrongEncryptionKey     = data.ErrWrongEncryptionKey
+	ErrEncryptionKeyRequired  = data.ErrEncryptionKeyRequired
 )
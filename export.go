@@ -0,0 +1,281 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+)
+
+/*
+导出格式与数据文件、索引类型以及文件大小无关，可以在不同配置的实例之间迁移数据：
+
+	magic(8) | version(2) | record ... | end
+
+record: crc(4) | keySize(uvarint) | valueSize(uvarint) | expire(varint) | key | value
+end:    crc(4) | 0(uvarint) | count(uvarint)
+
+crc 校验其后的所有字段，key 不能为空，因此 keySize 为 0 表示结束标识，count 为导出的记录数
+*/
+
+// exportMagic 导出数据流的起始标识
+var exportMagic = []byte("BITCASKX")
+
+// exportVersion 导出格式的版本
+const exportVersion uint16 = 1
+
+// Export 将当前时刻所有未过期的数据以可移植的格式写入 w，导出基于快照，不阻塞读写
+// 导出只包含默认命名空间，存在命名空间时返回 ErrNamespaceNotReplicated
+func (db *DB) Export(w io.Writer) error {
+
+	db.mu.Lock()
+	if len(db.namespaces) > 0 {
+		db.mu.Unlock()
+		return ErrNamespaceNotReplicated
+	}
+	snapshot, err := db.newSnapshot()
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = snapshot.Release()
+	}()
+
+	bw := bufio.NewWriter(w)
+
+	header := make([]byte, len(exportMagic)+2)
+	copy(header, exportMagic)
+	binary.BigEndian.PutUint16(header[len(exportMagic):], exportVersion)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	iterator := snapshot.index.Iterator(false)
+	defer iterator.Close()
+
+	var count uint64
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+
+		logRecordPos := iterator.Value()
+		if isExpired(logRecordPos, snapshot.readTime) {
+			continue
+		}
+
+		db.mu.RLock()
+		value, err := db.getValueByPosition(logRecordPos)
+		db.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		if _, err := bw.Write(encodeExportRecord(iterator.Key(), value, logRecordPos.Expire)); err != nil {
+			return err
+		}
+		count++
+	}
+
+	// 结束标识，用于发现被截断的数据流
+	buf := make([]byte, crc32.Size+2*binary.MaxVarintLen64)
+	index := crc32.Size
+	index += binary.PutUvarint(buf[index:], 0)
+	index += binary.PutUvarint(buf[index:], count)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[crc32.Size:index]))
+	if _, err := bw.Write(buf[:index]); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// encodeExportRecord 编码一条导出记录
+func encodeExportRecord(key, value []byte, expire int64) []byte {
+
+	header := make([]byte, crc32.Size+3*binary.MaxVarintLen64)
+	index := crc32.Size
+	index += binary.PutUvarint(header[index:], uint64(len(key)))
+	index += binary.PutUvarint(header[index:], uint64(len(value)))
+	index += binary.PutVarint(header[index:], expire)
+
+	buf := make([]byte, index+len(key)+len(value))
+	copy(buf, header[:index])
+	copy(buf[index:], key)
+	copy(buf[index+len(key):], value)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[crc32.Size:]))
+
+	return buf
+}
+
+// Import 从 r 中批量导入 Export 导出的数据，按批次持有写锁并且只在结束时持久化一次
+// 导入的数据覆盖已有的同名 key（ImportOptions.SkipExisting 时跳过），导出之后已经过期的数据不会写入
+// 数据流损坏时返回 ErrImportCorrupted，此前已经导入的数据仍然保留
+func (db *DB) Import(r io.Reader, opts ImportOptions) error {
+
+	if opts.BatchSize <= 0 {
+		return ErrInvalidImportBatchSize
+	}
+
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(exportMagic)+2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return fmt.Errorf("%w: %v", ErrImportCorrupted, err)
+	}
+	if !bytes.Equal(header[:len(exportMagic)], exportMagic) {
+		return fmt.Errorf("%w: invalid magic", ErrImportCorrupted)
+	}
+	if version := binary.BigEndian.Uint16(header[len(exportMagic):]); version != exportVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrImportCorrupted, version)
+	}
+
+	var count uint64
+	batch := make([]*exportRecord, 0, opts.BatchSize)
+	for {
+		record, total, err := readExportRecord(br)
+		if err != nil {
+			return err
+		}
+
+		// 数据流结束，校验记录数
+		if record == nil {
+			if total != count {
+				return fmt.Errorf("%w: expect %d records, got %d", ErrImportCorrupted, total, count)
+			}
+			break
+		}
+		count++
+
+		batch = append(batch, record)
+		if len(batch) == opts.BatchSize {
+			if err := db.importBatch(batch, opts); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := db.importBatch(batch, opts); err != nil {
+		return err
+	}
+
+	return db.Sync()
+}
+
+// exportRecord 一条导出的数据
+type exportRecord struct {
+	key    []byte
+	value  []byte
+	expire int64
+}
+
+// readExportRecord 读取一条导出记录，读到结束标识时返回 nil 以及导出的记录数
+func readExportRecord(br *bufio.Reader) (*exportRecord, uint64, error) {
+
+	crcBuf := make([]byte, crc32.Size)
+	if _, err := io.ReadFull(br, crcBuf); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrImportCorrupted, err)
+	}
+
+	// 记录头部的字段同时参与 crc 校验
+	hash := crc32.NewIEEE()
+	readUvarint := func() (uint64, error) {
+		v, err := binary.ReadUvarint(br)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrImportCorrupted, err)
+		}
+		buf := make([]byte, binary.MaxVarintLen64)
+		_, _ = hash.Write(buf[:binary.PutUvarint(buf, v)])
+		return v, nil
+	}
+
+	keySize, err := readUvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	if keySize == 0 {
+		count, err := readUvarint()
+		if err != nil {
+			return nil, 0, err
+		}
+		if hash.Sum32() != binary.LittleEndian.Uint32(crcBuf) {
+			return nil, 0, fmt.Errorf("%w: invalid crc", ErrImportCorrupted)
+		}
+		return nil, count, nil
+	}
+
+	valueSize, err := readUvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	expire, err := binary.ReadVarint(br)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrImportCorrupted, err)
+	}
+	expireBuf := make([]byte, binary.MaxVarintLen64)
+	_, _ = hash.Write(expireBuf[:binary.PutVarint(expireBuf, expire)])
+
+	// 数据文件中 key 和 value 的长度不超过 uint32，大小被篡改时避免分配过大的内存
+	if keySize > math.MaxUint32 || valueSize > math.MaxUint32 {
+		return nil, 0, fmt.Errorf("%w: invalid record size", ErrImportCorrupted)
+	}
+	kv := make([]byte, keySize+valueSize)
+	if _, err := io.ReadFull(br, kv); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrImportCorrupted, err)
+	}
+	_, _ = hash.Write(kv)
+	if hash.Sum32() != binary.LittleEndian.Uint32(crcBuf) {
+		return nil, 0, fmt.Errorf("%w: invalid crc", ErrImportCorrupted)
+	}
+
+	return &exportRecord{key: kv[:keySize], value: kv[keySize:], expire: expire}, 0, nil
+}
+
+// importBatch 在一次持有写锁期间写入一批数据，不单独持久化
+func (db *DB) importBatch(batch []*exportRecord, opts ImportOptions) error {
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var events []Event
+	now := time.Now().UnixNano()
+	for _, record := range batch {
+
+		if record.expire > 0 && record.expire <= now {
+			continue
+		}
+		if opts.SkipExisting {
+			if oldPos := db.index.Get(record.key); oldPos != nil && !isExpired(oldPos, now) {
+				continue
+			}
+		}
+
+		logRecord := &data.LogRecord{
+			Key:    logRecordKeyWithSeq(record.key, nonTransactionSeqNo),
+			Value:  record.value,
+			Type:   data.LogRecordNormal,
+			Expire: record.expire,
+		}
+		pos, err := db.writeLogRecord(logRecord, false)
+		if err != nil {
+			return err
+		}
+
+		if oldPos := db.index.Put(record.key, pos); oldPos != nil {
+			db.addReclaimSize(oldPos)
+		}
+		if len(db.watchers) > 0 {
+			events = append(events, recordEvent(record.key, logRecord, pos))
+		}
+	}
+
+	// 整批写入更新索引之后再通知订阅者
+	return db.publishEvents(events, false)
+}
@@ -0,0 +1,123 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ExportImport(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-export")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour))
+	assert.Nil(t, db.PutWithTTL([]byte("expired"), []byte("value"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	var buf bytes.Buffer
+	assert.Nil(t, db.Export(&buf))
+
+	// 导入到索引类型和文件大小都不同的实例
+	opts2 := DefaultOptions
+	dir2, _ := os.MkdirTemp("", "bitcask-go-import")
+	opts2.DirPath = dir2
+	opts2.IndexType = BPTree
+	opts2.DataFileSize = 32 * 1024
+	db2, err := Open(opts2)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db2); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	importOpts := DefaultImportOptions
+	importOpts.BatchSize = 64
+	assert.Nil(t, db2.Import(bytes.NewReader(buf.Bytes()), importOpts))
+
+	for i := 0; i < 1000; i++ {
+		value, err := db2.Get(utils.GetTestKey(i))
+		if i < 100 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		expected, _ := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, expected, value)
+	}
+	ttl, err := db2.TTL([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Hour)
+	_, err = db2.Get([]byte("expired"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, uint(901), db2.Stat().KeyNum)
+
+	// 跳过已经存在的 key
+	assert.Nil(t, db2.Put(utils.GetTestKey(500), []byte("local")))
+	importOpts.SkipExisting = true
+	assert.Nil(t, db2.Import(bytes.NewReader(buf.Bytes()), importOpts))
+	value, err := db2.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("local"), value)
+}
+
+func TestDB_ImportCorrupted(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-import-corrupted")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(16)))
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, db.Export(&buf))
+	stream := buf.Bytes()
+
+	// 数据被修改
+	modified := append([]byte(nil), stream...)
+	modified[len(modified)/2] ^= 0xff
+	err = db.Import(bytes.NewReader(modified), DefaultImportOptions)
+	assert.True(t, errors.Is(err, ErrImportCorrupted))
+
+	// 数据流被截断
+	err = db.Import(bytes.NewReader(stream[:len(stream)-3]), DefaultImportOptions)
+	assert.True(t, errors.Is(err, ErrImportCorrupted))
+
+	// 不是导出的数据
+	err = db.Import(bytes.NewReader([]byte("not an export stream")), DefaultImportOptions)
+	assert.True(t, errors.Is(err, ErrImportCorrupted))
+
+	// 完整的数据流
+	assert.Nil(t, db.Import(bytes.NewReader(stream), DefaultImportOptions))
+}
@@ -0,0 +1,138 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+)
+
+// 文件级 hint 的结束标识，记录对应数据文件的大小，用于判断 hint 是否完整、是否与数据文件匹配
+const fileHintFinishedKey = "hint.finished"
+
+// hintEntry 数据文件中一条记录的索引信息
+type hintEntry struct {
+	key       []byte             /* 带有事务序列号的 key */
+	typ       data.LogRecordType /* 记录的类型 */
+	namespace uint32             /* 记录所属的命名空间 id */
+	pos       *data.LogRecordPos /* 记录的位置 */
+}
+
+// writeFileHint 为即将封存的活跃文件写入 hint 文件（需要持有 db.mu）
+// hint 只是启动加速手段，写入失败时删除 hint，启动时回退为扫描数据文件
+func (db *DB) writeFileHint() {
+
+	entries, valid := db.activeHints, db.activeHintsValid
+	db.activeHints, db.activeHintsValid = nil, false
+	if !valid || db.activeFile == nil {
+		return
+	}
+
+	fileId := db.activeFile.FileId
+	if err := db.doWriteFileHint(fileId, entries); err != nil {
+		_ = os.Remove(data.GetFileHintName(db.options.DirPath, fileId))
+	}
+}
+
+func (db *DB) doWriteFileHint(fileId uint32, entries []hintEntry) error {
+
+	// 清理可能残留的同名 hint
+	hintName := data.GetFileHintName(db.options.DirPath, fileId)
+	if err := os.Remove(hintName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	hintFile, err := data.OpenFileHint(db.options.DirPath, fileId)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		encRecord, _, err := data.EncodeEncryptedLogRecord(&data.LogRecord{
+			Key:       entry.key,
+			Value:     data.EncodeLogRecordPos(entry.pos),
+			Type:      entry.typ,
+			Namespace: entry.namespace,
+		}, db.encryptor)
+		if err != nil {
+			return err
+		}
+		buf.Write(encRecord)
+	}
+
+	// 最后写入结束标识
+	encRecord, _, err := data.EncodeEncryptedLogRecord(&data.LogRecord{
+		Key:   []byte(fileHintFinishedKey),
+		Value: []byte(strconv.FormatInt(db.activeFile.WriteOff, 10)),
+	}, db.encryptor)
+	if err != nil {
+		return err
+	}
+	buf.Write(encRecord)
+
+	if err := hintFile.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return hintFile.Sync()
+}
+
+// loadFileHint 读取数据文件对应的 hint，hint 不存在、损坏或者与数据文件不匹配时返回 false
+func (db *DB) loadFileHint(dataFile *data.DataFile) ([]hintEntry, bool) {
+
+	hintName := data.GetFileHintName(db.options.DirPath, dataFile.FileId)
+	if _, err := os.Stat(hintName); err != nil {
+		return nil, false
+	}
+	hintFile, err := data.OpenFileHint(db.options.DirPath, dataFile.FileId)
+	if err != nil {
+		return nil, false
+	}
+	defer hintFile.Close()
+	hintFile.Encryptor = db.encryptor
+
+	dataSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, false
+	}
+
+	var entries []hintEntry
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			// 没有读到结束标识就结束，说明 hint 不完整
+			return nil, false
+		}
+		offset += size
+
+		// 最后一条是结束标识，其中记录的大小需要与数据文件一致
+		if string(logRecord.Key) == fileHintFinishedKey {
+			if _, _, err := hintFile.ReadLogRecord(offset); err == io.EOF {
+				recordedSize, err := strconv.ParseInt(string(logRecord.Value), 10, 64)
+				if err != nil || recordedSize != dataSize {
+					return nil, false
+				}
+				return entries, true
+			}
+		}
+
+		entries = append(entries, hintEntry{
+			key:       logRecord.Key,
+			typ:       logRecord.Type,
+			namespace: logRecord.Namespace,
+			pos:       data.DecodeLogRecordPos(logRecord.Value),
+		})
+	}
+}
+
+// removeFileHint 删除数据文件对应的 hint
+func removeFileHint(dirPath string, fileId uint32) error {
+	err := os.Remove(data.GetFileHintName(dirPath, fileId))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_FileHint(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	// 普通写入、删除、事务以及过期时间
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour))
+	for i := 2000; i < 2300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	// 每个封存的数据文件都有对应的 hint
+	dataFileNum := db.Stat().DataFileNum
+	assert.Greater(t, dataFileNum, uint(2))
+	for fid := uint32(0); fid < uint32(dataFileNum-1); fid++ {
+		_, err := os.Stat(data.GetFileHintName(dir, fid))
+		assert.Nil(t, err)
+	}
+	keys := db.ListKeys()
+	assert.Nil(t, db.Close())
+
+	// 通过 hint 加载的结果与扫描数据文件一致
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keys, db.ListKeys())
+	ttl, err := db.TTL([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, time.Minute)
+	assert.Nil(t, db.Close())
+
+	// 破坏数据文件中的 value，有 hint 时启动不需要读取数据文件
+	stat, err := os.Stat(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	corruptFile(t, data.GetDataFileName(dir, 0), stat.Size()-10)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// 损坏的 hint 会回退为扫描数据文件
+	corruptFile(t, data.GetFileHintName(dir, 0), 10)
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+
+	corruptFile(t, data.GetDataFileName(dir, 0), stat.Size()-10)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keys, db.ListKeys())
+}
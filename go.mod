@@ -3,6 +3,8 @@ module bitcask-go
 go 1.23.5
 
 require (
+	github.com/golang/snappy v1.0.0
+	github.com/klauspost/compress v1.18.0
 	github.com/plar/go-adaptive-radix-tree v1.0.7
 	go.etcd.io/bbolt v1.4.0
 )
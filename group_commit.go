@@ -0,0 +1,153 @@
+package bitcaskkv
+
+import "bitcask-go/data"
+
+// commitRequest 一个等待组提交的写入
+type commitRequest struct {
+	record  *data.LogRecord
+	prepare func() (*data.LogRecord, error) /* 不为 nil 时在持有锁的情况下生成需要写入的数据，返回 nil 表示不写入 */
+	apply   func(pos *data.LogRecordPos)    /* 写入之后在持有锁的情况下更新内存索引 */
+	batch   func() ([]Event, error)         /* 不为 nil 时在持有锁的情况下写入一组数据并更新内存索引，返回持久化之后需要通知的事件 */
+	events  []Event                         /* 持久化之后通知订阅者的事件 */
+	pos     *data.LogRecordPos
+	err     error
+	wake    chan bool /* 唤醒等待的写入，true 表示成为下一轮的 leader，false 表示数据已经持久化 */
+}
+
+// groupCommit 将写入加入组提交队列，并在数据持久化之后返回
+// 队列空闲时到达的写入成为 leader，负责将队列中所有的写入追加到活跃文件并只持久化一次，
+// leader 持久化期间到达的写入进入下一轮，由其中第一个写入担任下一轮的 leader
+func (db *DB) groupCommit(req *commitRequest) (*data.LogRecordPos, error) {
+
+	req.wake = make(chan bool, 1)
+
+	db.commitMu.Lock()
+	db.commitQueue = append(db.commitQueue, req)
+	if db.committing {
+		// 已经有 leader，等待数据持久化或者成为下一轮的 leader
+		db.commitMu.Unlock()
+		if lead := <-req.wake; !lead {
+			return req.pos, req.err
+		}
+		db.commitMu.Lock()
+	}
+	db.committing = true
+	requests := db.commitQueue
+	db.commitQueue = nil
+	db.commitMu.Unlock()
+
+	db.commitBatch(requests, req)
+
+	// 将 leader 交给下一轮的第一个写入
+	db.commitMu.Lock()
+	if len(db.commitQueue) > 0 {
+		db.commitQueue[0].wake <- true
+	} else {
+		db.committing = false
+	}
+	db.commitMu.Unlock()
+
+	return req.pos, req.err
+}
+
+// commitBatch 追加一组写入并只持久化一次，然后唤醒除 leader 之外所有等待的写入
+// 写入在持有 db.mu 时追加并更新内存索引，之后的 prepare 可以读到之前的写入；持久化在释放 db.mu 之后进行，期间读取不受阻塞，
+// 订阅者在持久化成功之后才会收到事件；持久化失败时写入返回错误，但已经更新的内存索引不会回滚
+func (db *DB) commitBatch(requests []*commitRequest, leader *commitRequest) {
+
+	defer func() {
+		for _, req := range requests {
+			if req != leader {
+				req.wake <- false
+			}
+		}
+	}()
+
+	db.mu.Lock()
+
+	var written []*commitRequest
+	for _, req := range requests {
+		var ok bool
+		if ok, req.err = db.writeCommit(req); ok {
+			written = append(written, req)
+		}
+	}
+	if len(written) == 0 {
+		db.mu.Unlock()
+		return
+	}
+
+	// 引用活跃文件，持久化期间不会被关闭或者删除，数据文件中的记录可能指向活跃 blob 文件中的 value，因此先持久化 blob 文件
+	dataFile := db.activeFile
+	var blobFile *data.DataFile
+	if db.blobDirty {
+		blobFile = db.activeBlobFile
+		db.blobDirty = false
+	}
+	db.pinnedFiles[dataFile.FileId]++
+	db.syncing = true
+	db.mu.Unlock()
+
+	var err error
+	if blobFile != nil {
+		err = blobFile.Sync()
+	}
+	if err == nil {
+		err = dataFile.Sync()
+	}
+	db.unpinFiles([]uint32{dataFile.FileId})
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.syncing = false
+	if err != nil {
+		db.blobDirty = db.blobDirty || blobFile != nil
+		for _, req := range written {
+			req.pos, req.err = nil, err
+		}
+	} else {
+		db.bytesWrite = 0
+		for _, req := range written {
+			if len(req.events) > 0 {
+				db.notifyWatchers(req.events)
+			}
+		}
+	}
+
+	// 持久化期间其他写入产生的事件序列号更大，在该组提交的事件之后发送
+	heldEvents := db.heldEvents
+	db.heldEvents = nil
+	for _, events := range heldEvents {
+		db.notifyWatchers(events)
+	}
+}
+
+// writeCommit 追加一个写入并更新内存索引，返回是否写入了数据（需要持有 db.mu）
+func (db *DB) writeCommit(req *commitRequest) (bool, error) {
+
+	if req.batch != nil {
+		events, err := req.batch()
+		if err != nil {
+			return false, err
+		}
+		req.events = events
+		return true, nil
+	}
+
+	if req.prepare != nil {
+		logRecord, err := req.prepare()
+		if err != nil || logRecord == nil {
+			return false, err
+		}
+		req.record = logRecord
+	}
+	pos, err := db.writeLogRecord(req.record, false)
+	if err != nil {
+		return false, err
+	}
+	req.pos = pos
+	req.apply(pos)
+	req.events = db.writeEvents(req.record, pos)
+	return true, nil
+}
@@ -0,0 +1,217 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.GroupCommit = true
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	// 并发写入，跨越多个数据文件
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := utils.GetTestKey(g*1000 + i)
+				assert.Nil(t, db.Put(key, utils.GetTestValue(128)))
+				if i%10 == 0 {
+					assert.Nil(t, db.Delete(key))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 8*180, len(db.ListKeys()))
+	assert.Greater(t, db.Stat().DataFileNum, uint(1))
+
+	// 重启之后数据完整
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 8*180, len(db.ListKeys()))
+	for g := 0; g < 8; g++ {
+		_, err := db.Get(utils.GetTestKey(g*1000 + 1))
+		assert.Nil(t, err)
+		_, err = db.Get(utils.GetTestKey(g * 1000))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+}
+
+func TestDB_GroupCommitCheckedWrites(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-checked")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.GroupCommit = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	// 并发的 Update 与普通写入同批提交，计数不会丢失
+	counter := []byte("counter")
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				assert.Nil(t, db.Update(counter, func(old []byte, exists bool) ([]byte, error) {
+					n := 0
+					if exists {
+						n, _ = strconv.Atoi(string(old))
+					}
+					return []byte(strconv.Itoa(n + 1)), nil
+				}))
+				assert.Nil(t, db.Put(utils.GetTestKey(g*1000+i), utils.GetTestValue(16)))
+			}
+		}(g)
+	}
+	wg.Wait()
+	value, err := db.Get(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, "400", string(value))
+
+	// 并发的 PutIfAbsent 只有一个成功
+	var succeeded int32
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.PutIfAbsent([]byte("once"), []byte("v")); err == nil {
+				atomic.AddInt32(&succeeded, 1)
+			} else {
+				assert.Equal(t, ErrKeyExists, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), succeeded)
+
+	// TTL 相关的写入同样经过组提交
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour))
+	assert.Nil(t, db.Persist([]byte("ttl")))
+	ttl, err := db.TTL([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.Equal(t, PersistentTTL, ttl)
+	assert.Equal(t, ErrKeyNotFound, db.ExpireAt([]byte("missing"), time.Now().Add(time.Hour)))
+	assert.Nil(t, db.CompareAndSwap([]byte("ttl"), []byte("v"), []byte("v2")))
+	assert.Nil(t, db.DeleteIfEqual([]byte("ttl"), []byte("v2")))
+
+	// 重启之后数据完整
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	value, err = db.Get(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, "400", string(value))
+	_, err = db.Get([]byte("ttl"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// 原子写和读写事务同样经过组提交，订阅者收到的事件序列号保持递增
+func TestDB_GroupCommitBatches(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-batch")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.GroupCommit = true
+	opts.WatchBufferSize = 4096
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	ch, cancel := db.Watch(nil, 0)
+	defer cancel()
+
+	counter := []byte("counter")
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(g*1000+i), utils.GetTestValue(16)))
+
+				wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+				assert.Nil(t, wb.Put(utils.GetTestKey(g*1000+i+100), utils.GetTestValue(16)))
+				assert.Nil(t, wb.Put(utils.GetTestKey(g*1000+i+200), utils.GetTestValue(16)))
+				assert.Nil(t, wb.Commit())
+
+				// 冲突的事务重试，计数不会丢失
+				for {
+					txn, err := db.Begin(false)
+					assert.Nil(t, err)
+					n := 0
+					if old, err := txn.Get(counter); err == nil {
+						n, _ = strconv.Atoi(string(old))
+					}
+					assert.Nil(t, txn.Put(counter, []byte(strconv.Itoa(n+1))))
+					if err := txn.Commit(); err != ErrTxnConflict {
+						assert.Nil(t, err)
+						break
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	value, err := db.Get(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, "160", string(value))
+	assert.Equal(t, 8*60+1, len(db.ListKeys()))
+
+	// 每个 Put 一个事件，每个原子写两个写入事件和一个提交事件，每个事务一个写入事件和一个提交事件
+	events := receiveEvents(t, ch, 8*20*6)
+	for i := 1; i < len(events); i++ {
+		assert.Greater(t, events[i].Seq, events[i-1].Seq)
+	}
+
+	// 重启之后数据完整
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	value, err = db.Get(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, "160", string(value))
+	assert.Equal(t, 8*60+1, len(db.ListKeys()))
+}
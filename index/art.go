@@ -67,9 +67,35 @@ func (art *AdaptiveRadixTree) Size() int {
 
 // Iterator 索引迭代器
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
+	return art.RangeIterator(IteratorOptions{Reverse: reverse})
+}
+
+// RangeIterator 只遍历指定范围的索引迭代器
+func (art *AdaptiveRadixTree) RangeIterator(opts IteratorOptions) Iterator {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
-	return newARTIterator(art.tree, reverse)
+	return newARTIterator(art.tree, opts)
+}
+
+// Snapshot 获取索引的只读快照（ART 不支持写时复制，需要完整拷贝一份）
+func (art *AdaptiveRadixTree) Snapshot() (Reader, error) {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+
+	tree := goart.New()
+	art.tree.ForEach(func(node goart.Node) bool {
+		tree.Insert(node.Key(), node.Value())
+		return true
+	})
+	return &AdaptiveRadixTree{
+		tree: tree,
+		lock: new(sync.RWMutex),
+	}, nil
+}
+
+// Sync 内存索引不需要持久化
+func (art *AdaptiveRadixTree) Sync() error {
+	return nil
 }
 
 // Close 关闭索引
@@ -85,32 +111,44 @@ type artIterator struct {
 }
 
 // 新建 btreeIterator 结构
-func newARTIterator(tree goart.Tree, reverse bool) *artIterator {
+func newARTIterator(tree goart.Tree, opts IteratorOptions) *artIterator {
 
-	var idx int
-	if reverse {
-		idx = tree.Size() - 1
+	// 不限制范围时可以预先分配好全部空间
+	var values []*Item
+	if opts.LowerBound == nil && opts.UpperBound == nil {
+		values = make([]*Item, 0, tree.Size())
 	}
-	values := make([]*Item, tree.Size())
+
+	// ART 按 key 的顺序遍历，只保存范围内的数据，越过上界之后停止遍历
+	// go-adaptive-radix-tree 不支持定位到下界，ForEachPrefix 也是过滤之后的全量遍历并且不会提前停止，
+	// 因此遍历的开销与小于上界的数据量成正比，需要频繁扫描索引后部范围的场景应当使用 BTree 索引
 	saveValues := func(node goart.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
+		key := node.Key()
+		if opts.aboveUpper(BytewiseComparator, key) {
+			return false
 		}
-		values[idx] = item
-		if reverse {
-			idx--
-		} else {
-			idx++
+		if opts.belowLower(BytewiseComparator, key) {
+			return true
 		}
+		values = append(values, &Item{
+			key: key,
+			pos: node.Value().(*data.LogRecordPos),
+		})
 		return true
 	}
 
 	tree.ForEach(saveValues)
 
+	// 反向遍历则将数组倒置
+	if opts.Reverse {
+		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+
 	return &artIterator{
 		currIndex: 0,
-		reverse:   reverse,
+		reverse:   opts.Reverse,
 		values:    values,
 	}
 }
@@ -2,8 +2,11 @@ package index
 
 import (
 	"bitcask-go/data"
+	"fmt"
+	"sort"
 	"testing"
 
+	goart "github.com/plar/go-adaptive-radix-tree"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -82,3 +85,94 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 	}
 
 }
+
+func TestAdaptiveRadixTree_RangeIterator(t *testing.T) {
+
+	art := NewART()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		art.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+	}
+
+	collect := func(iter Iterator) []string {
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	iter1 := art.RangeIterator(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d")})
+	assert.Equal(t, []string{"b", "c"}, collect(iter1))
+
+	iter2 := art.RangeIterator(IteratorOptions{Reverse: true, LowerBound: []byte("b"), UpperBound: []byte("d")})
+	assert.Equal(t, []string{"c", "b"}, collect(iter2))
+
+	iter3 := art.RangeIterator(IteratorOptions{UpperBound: []byte("bb")})
+	assert.Equal(t, []string{"a", "b"}, collect(iter3))
+}
+
+// countingTree 统计遍历访问的节点数量
+type countingTree struct {
+	goart.Tree
+	visited int
+}
+
+func (ct *countingTree) ForEach(callback goart.Callback, opts ...int) {
+	ct.Tree.ForEach(func(node goart.Node) bool {
+		ct.visited++
+		return callback(node)
+	}, opts...)
+}
+
+// 范围遍历与逐个比较的结果一致，越过上界之后停止遍历
+func TestAdaptiveRadixTree_RangeIteratorBounds(t *testing.T) {
+
+	tree := &countingTree{Tree: goart.New()}
+	var all []string
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		all = append(all, key)
+		tree.Insert([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	for _, key := range []string{"", "k", "key", "key-", "kez", "\xff"} {
+		all = append(all, key)
+		tree.Insert([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 1})
+	}
+	sort.Strings(all)
+
+	bounds := [][]byte{nil, []byte(""), []byte("k"), []byte("key-1"), []byte("key-150"), []byte("key-1500"),
+		[]byte("key-19\xff"), []byte("key-2"), []byte("key-999"), []byte("kez"), []byte("z"), []byte("\xff\xff")}
+	for _, lower := range bounds {
+		for _, upper := range bounds {
+			var expected []string
+			var belowUpper int
+			for _, key := range all {
+				if upper == nil || key < string(upper) {
+					belowUpper++
+					if lower == nil || key >= string(lower) {
+						expected = append(expected, key)
+					}
+				}
+			}
+
+			tree.visited = 0
+			iter := newARTIterator(tree, IteratorOptions{LowerBound: lower, UpperBound: upper})
+			var keys []string
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+			}
+			assert.Equal(t, expected, keys, "lower %q upper %q", lower, upper)
+			assert.LessOrEqual(t, tree.visited, belowUpper+1, "lower %q upper %q", lower, upper)
+
+			iter = newARTIterator(tree, IteratorOptions{LowerBound: lower, UpperBound: upper, Reverse: true})
+			var reversed []string
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				reversed = append(reversed, string(iter.Key()))
+			}
+			for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+				reversed[i], reversed[j] = reversed[j], reversed[i]
+			}
+			assert.Equal(t, expected, reversed, "lower %q upper %q", lower, upper)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// bloomFilterMinCapacity 布隆过滤器的最小容量
+const bloomFilterMinCapacity = 1024
+
+var ErrInvalidBloomFilter = errors.New("invalid bloom filter")
+
+// BloomFilter 布隆过滤器，判断 key 一定不存在或者可能存在
+// 不支持删除，被删除的 key 只会增加误判，不会导致漏判
+type BloomFilter struct {
+	bits     []uint64
+	numBits  uint64  /* 位数组的长度 */
+	numHash  uint32  /* 每个 key 设置的位数 */
+	capacity uint64  /* 达到目标误判率时最多容纳的 key 数量 */
+	count    uint64  /* 已经加入的 key 数量 */
+	fpRate   float64 /* 目标误判率 */
+}
+
+// NewBloomFilter 根据容量和目标误判率初始化布隆过滤器
+func NewBloomFilter(capacity uint64, fpRate float64) *BloomFilter {
+
+	if capacity < bloomFilterMinCapacity {
+		capacity = bloomFilterMinCapacity
+	}
+
+	// m = -n * ln(p) / (ln2)^2，k = m / n * ln2
+	numBits := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	numBits = (numBits + 63) / 64 * 64
+	numHash := uint32(math.Round(float64(numBits) / float64(capacity) * math.Ln2))
+	if numHash == 0 {
+		numHash = 1
+	}
+
+	return &BloomFilter{
+		bits:     make([]uint64, numBits/64),
+		numBits:  numBits,
+		numHash:  numHash,
+		capacity: capacity,
+		fpRate:   fpRate,
+	}
+}
+
+// bloomHash 对 key 计算两个哈希值，通过 h1 + i * h2 得到 k 个位置
+func bloomHash(key []byte) (uint64, uint64) {
+	hash := fnv.New64a()
+	_, _ = hash.Write(key)
+	sum := hash.Sum64()
+	return sum & math.MaxUint32, sum>>32 | 1
+}
+
+// Add 加入 key
+func (bf *BloomFilter) Add(key []byte) {
+	h1, h2 := bloomHash(key)
+	for i := uint64(0); i < uint64(bf.numHash); i++ {
+		bit := (h1 + i*h2) % bf.numBits
+		bf.bits[bit/64] |= 1 << (bit % 64)
+	}
+	bf.count++
+}
+
+// MayContain key 可能存在时返回 true，返回 false 时 key 一定不存在
+func (bf *BloomFilter) MayContain(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	for i := uint64(0); i < uint64(bf.numHash); i++ {
+		bit := (h1 + i*h2) % bf.numBits
+		if bf.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Full 加入的 key 是否已经超过容量
+func (bf *BloomFilter) Full() bool {
+	return bf.count > bf.capacity
+}
+
+// FalsePositiveRate 根据已经加入的 key 数量估计的误判率
+func (bf *BloomFilter) FalsePositiveRate() float64 {
+	return math.Pow(1-math.Exp(-float64(bf.numHash)*float64(bf.count)/float64(bf.numBits)), float64(bf.numHash))
+}
+
+// TargetFalsePositiveRate 初始化时指定的目标误判率
+func (bf *BloomFilter) TargetFalsePositiveRate() float64 {
+	return bf.fpRate
+}
+
+// Encode 编码布隆过滤器
+// +----------+---------+---------+----------+----------+-------+
+// | capacity |  count  | numHash |  fpRate  | numBits  | bits  |
+// +----------+---------+---------+----------+----------+-------+
+//
+//	变长       变长       变长       8 字节      变长
+func (bf *BloomFilter) Encode() []byte {
+	buf := make([]byte, 0, binary.MaxVarintLen64*4+8+len(bf.bits)*8)
+	buf = binary.AppendUvarint(buf, bf.capacity)
+	buf = binary.AppendUvarint(buf, bf.count)
+	buf = binary.AppendUvarint(buf, uint64(bf.numHash))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(bf.fpRate))
+	buf = binary.AppendUvarint(buf, bf.numBits)
+	for _, word := range bf.bits {
+		buf = binary.LittleEndian.AppendUint64(buf, word)
+	}
+	return buf
+}
+
+// DecodeBloomFilter 解码布隆过滤器
+func DecodeBloomFilter(buf []byte) (*BloomFilter, error) {
+
+	var fields [3]uint64
+	for i := range fields {
+		value, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, ErrInvalidBloomFilter
+		}
+		fields[i] = value
+		buf = buf[n:]
+	}
+	if len(buf) < 8 {
+		return nil, ErrInvalidBloomFilter
+	}
+	fpRate := math.Float64frombits(binary.LittleEndian.Uint64(buf))
+	buf = buf[8:]
+	numBits, n := binary.Uvarint(buf)
+	if n <= 0 || numBits == 0 || numBits%64 != 0 || uint64(len(buf)-n) != numBits/8 || fields[2] == 0 {
+		return nil, ErrInvalidBloomFilter
+	}
+	buf = buf[n:]
+
+	bf := &BloomFilter{
+		bits:     make([]uint64, numBits/64),
+		numBits:  numBits,
+		numHash:  uint32(fields[2]),
+		capacity: fields[0],
+		count:    fields[1],
+		fpRate:   fpRate,
+	}
+	for i := range bf.bits {
+		bf.bits[i] = binary.LittleEndian.Uint64(buf[i*8:])
+	}
+	return bf, nil
+}
+
+// BloomIndex 带有布隆过滤器的索引，查询一定不存在的 key 时不访问底层的索引
+// 加入的 key 超过容量时根据底层索引中的 key 重建更大的布隆过滤器
+type BloomIndex struct {
+	Indexer
+	mu     sync.RWMutex
+	filter *BloomFilter
+}
+
+// NewBloomIndex 为索引加上布隆过滤器，filter 为 nil 时根据索引中已有的 key 构建
+func NewBloomIndex(indexer Indexer, filter *BloomFilter, fpRate float64) *BloomIndex {
+	bi := &BloomIndex{Indexer: indexer, filter: filter}
+	if filter == nil {
+		bi.rebuild(fpRate)
+	}
+	return bi
+}
+
+// Put 向索引中存储 key 对应的索引信息
+func (bi *BloomIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+
+	// 先加入布隆过滤器，保证可以查询到的 key 一定在过滤器中
+	bi.mu.Lock()
+	bi.filter.Add(key)
+	bi.mu.Unlock()
+
+	oldPos := bi.Indexer.Put(key, pos)
+
+	// 只有新的 key 才计入数量
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	if oldPos != nil {
+		bi.filter.count--
+	}
+	if bi.filter.Full() {
+		bi.rebuild(bi.filter.fpRate)
+	}
+	return oldPos
+}
+
+// Get 通过 key 取出对应位置的索引信息，布隆过滤器判断不存在时直接返回
+func (bi *BloomIndex) Get(key []byte) *data.LogRecordPos {
+	if !bi.MayContain(key) {
+		return nil
+	}
+	return bi.Indexer.Get(key)
+}
+
+// MayContain key 是否可能存在于索引中
+func (bi *BloomIndex) MayContain(key []byte) bool {
+	bi.mu.RLock()
+	defer bi.mu.RUnlock()
+	return bi.filter.MayContain(key)
+}
+
+// FalsePositiveRate 布隆过滤器当前估计的误判率
+func (bi *BloomIndex) FalsePositiveRate() float64 {
+	bi.mu.RLock()
+	defer bi.mu.RUnlock()
+	return bi.filter.FalsePositiveRate()
+}
+
+// EncodeFilter 编码当前的布隆过滤器
+func (bi *BloomIndex) EncodeFilter() []byte {
+	bi.mu.RLock()
+	defer bi.mu.RUnlock()
+	return bi.filter.Encode()
+}
+
+// rebuild 根据底层索引中的 key 重建布隆过滤器，容量为当前 key 数量的两倍（需要持有 bi.mu）
+func (bi *BloomIndex) rebuild(fpRate float64) {
+	filter := NewBloomFilter(uint64(bi.Indexer.Size())*2, fpRate)
+	iterator := bi.Indexer.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		filter.Add(iterator.Key())
+	}
+	iterator.Close()
+	bi.filter = filter
+}
@@ -0,0 +1,69 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+
+	bf := NewBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		bf.Add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+
+	// 加入的 key 一定可以查询到
+	for i := 0; i < 10000; i++ {
+		assert.True(t, bf.MayContain([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	// 误判率接近目标误判率
+	var falsePositives int
+	for i := 0; i < 10000; i++ {
+		if bf.MayContain([]byte(fmt.Sprintf("missing-%d", i))) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 300)
+	assert.InDelta(t, 0.01, bf.FalsePositiveRate(), 0.005)
+	assert.False(t, bf.Full())
+
+	// 编码之后解码得到相同的布隆过滤器
+	decoded, err := DecodeBloomFilter(bf.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, bf, decoded)
+	_, err = DecodeBloomFilter(bf.Encode()[:100])
+	assert.Equal(t, ErrInvalidBloomFilter, err)
+}
+
+func TestBloomIndex(t *testing.T) {
+
+	bt := NewBTree()
+	bt.Put([]byte("existing"), &data.LogRecordPos{Fid: 1, Offset: 1})
+
+	// 根据已有的 key 构建布隆过滤器
+	bi := NewBloomIndex(bt, nil, 0.01)
+	assert.NotNil(t, bi.Get([]byte("existing")))
+	assert.Nil(t, bi.Get([]byte("missing")))
+
+	// 超过容量之后重建，已经加入的 key 仍然可以查询到
+	for i := 0; i < 5000; i++ {
+		bi.Put([]byte(fmt.Sprintf("key-%d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		bi.Put([]byte(fmt.Sprintf("key-%d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+	}
+	for i := 0; i < 5000; i++ {
+		pos := bi.Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.NotNil(t, pos)
+		assert.Equal(t, uint32(2), pos.Fid)
+	}
+	assert.Equal(t, 5001, bi.Size())
+	assert.Less(t, bi.FalsePositiveRate(), 0.02)
+
+	// 删除之后查询不到
+	_, ok := bi.Delete([]byte("existing"))
+	assert.True(t, ok)
+	assert.Nil(t, bi.Get([]byte("existing")))
+}
@@ -2,6 +2,8 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"fmt"
 	"path/filepath"
 
 	"go.etcd.io/bbolt"
@@ -106,7 +108,35 @@ func (bpt *BPlusTree) Size() int {
 
 // Iterator 索引迭代器
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBptreeIterator(bpt.tree, reverse)
+	return newBptreeIterator(bpt.tree, IteratorOptions{Reverse: reverse})
+}
+
+// RangeIterator 只遍历指定范围的索引迭代器
+func (bpt *BPlusTree) RangeIterator(opts IteratorOptions) Iterator {
+	return newBptreeIterator(bpt.tree, opts)
+}
+
+// Snapshot 获取索引的只读快照
+// 长时间持有 bbolt 只读事务会阻塞写事务扩容文件（事务提交时写入索引会等待快照释放），所以将当前索引完整拷贝到内存 BTree 中，
+// 开销与索引中的数据量成正比
+func (bpt *BPlusTree) Snapshot() (Reader, error) {
+	snapshot := NewBTree()
+	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(indexBucketName).ForEach(func(k, v []byte) error {
+			key := make([]byte, len(k))
+			copy(key, k)
+			snapshot.Put(key, data.DecodeLogRecordPos(v))
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("failed to snapshot bptree: %w", err)
+	}
+	return snapshot, nil
+}
+
+// Sync 将索引文件持久化，NoSync 时写事务不会主动持久化
+func (bpt *BPlusTree) Sync() error {
+	return bpt.tree.Sync()
 }
 
 func (bpt *BPlusTree) Close() error {
@@ -115,9 +145,10 @@ func (bpt *BPlusTree) Close() error {
 
 // B+ 树迭代器
 type bptreeIterator struct {
-	tx      *bbolt.Tx     /* 库所需 */
-	cursor  *bbolt.Cursor /* 库所需 */
-	reverse bool          /* 正反遍历控制项 */
+	tx      *bbolt.Tx       /* 库所需 */
+	cursor  *bbolt.Cursor   /* 库所需 */
+	reverse bool            /* 正反遍历控制项 */
+	opts    IteratorOptions /* 遍历范围 */
 
 	// 方便实现迭代器
 	currKey   []byte
@@ -125,7 +156,7 @@ type bptreeIterator struct {
 }
 
 // 创建 B+ 树迭代器
-func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
+func newBptreeIterator(tree *bbolt.DB, opts IteratorOptions) *bptreeIterator {
 
 	// 手动开启事务
 	tx, err := tree.Begin(false)
@@ -135,7 +166,8 @@ func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
 	bpi := &bptreeIterator{
 		tx:      tx,
 		cursor:  tx.Bucket(indexBucketName).Cursor(),
-		reverse: reverse,
+		reverse: opts.Reverse,
+		opts:    opts,
 	}
 	bpi.Rewind()
 	return bpi
@@ -144,16 +176,42 @@ func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
 // Rewind 重新回到迭代器的起点
 func (bpi *bptreeIterator) Rewind() {
 
+	// 有边界时直接定位到边界
 	if bpi.reverse {
-		bpi.currKey, bpi.currValue = bpi.cursor.Last()
+		if bpi.opts.UpperBound != nil {
+			bpi.seekLessThan(bpi.opts.UpperBound)
+		} else {
+			bpi.currKey, bpi.currValue = bpi.cursor.Last()
+		}
 	} else {
-		bpi.currKey, bpi.currValue = bpi.cursor.First()
+		if bpi.opts.LowerBound != nil {
+			bpi.currKey, bpi.currValue = bpi.cursor.Seek(bpi.opts.LowerBound)
+		} else {
+			bpi.currKey, bpi.currValue = bpi.cursor.First()
+		}
 	}
+	bpi.checkBound()
 }
 
 // Seek 根据传入 key 查找第一个大于（或小于）等于的目标 Key，根据这个 Key 开始遍历
 func (bpi *bptreeIterator) Seek(key []byte) {
+
+	// 超出边界的 key 从边界开始
+	if (bpi.reverse && bpi.opts.aboveUpper(BytewiseComparator, key)) || (!bpi.reverse && bpi.opts.belowLower(BytewiseComparator, key)) {
+		bpi.Rewind()
+		return
+	}
+
 	bpi.currKey, bpi.currValue = bpi.cursor.Seek(key)
+	if bpi.reverse {
+		// 反向遍历需要找到第一个小于等于 key 的位置
+		if bpi.currKey == nil {
+			bpi.currKey, bpi.currValue = bpi.cursor.Last()
+		} else if bytes.Compare(bpi.currKey, key) > 0 {
+			bpi.currKey, bpi.currValue = bpi.cursor.Prev()
+		}
+	}
+	bpi.checkBound()
 }
 
 // Next 跳转到下一个 Key
@@ -163,6 +221,26 @@ func (bpi *bptreeIterator) Next() {
 	} else {
 		bpi.currKey, bpi.currValue = bpi.cursor.Next()
 	}
+	bpi.checkBound()
+}
+
+// seekLessThan 定位到第一个小于 key 的位置
+func (bpi *bptreeIterator) seekLessThan(key []byte) {
+	if k, _ := bpi.cursor.Seek(key); k == nil {
+		bpi.currKey, bpi.currValue = bpi.cursor.Last()
+	} else {
+		bpi.currKey, bpi.currValue = bpi.cursor.Prev()
+	}
+}
+
+// checkBound 越过遍历范围之后迭代器失效
+func (bpi *bptreeIterator) checkBound() {
+	if bpi.currKey == nil {
+		return
+	}
+	if bpi.opts.belowLower(BytewiseComparator, bpi.currKey) || bpi.opts.aboveUpper(BytewiseComparator, bpi.currKey) {
+		bpi.currKey, bpi.currValue = nil, nil
+	}
 }
 
 // Valid 是否有效，即是否已经遍历完所有的 key，用于退出遍历
@@ -160,3 +160,42 @@ func TestBPlusTree_Iterator(t *testing.T) {
 	iter.Close() // 确保迭代器使用完毕后关闭
 
 }
+
+func TestBPlusTree_RangeIterator(t *testing.T) {
+
+	// 创建临时目录
+	dir, err := os.MkdirTemp("", "bptree-range-iter")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// 初始化 B+ 树索引
+	bpt := NewBPlusTree(dir, false)
+	defer bpt.Close()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		bpt.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+	}
+
+	collect := func(iter Iterator) []string {
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		iter.Close()
+		return keys
+	}
+
+	iter1 := bpt.RangeIterator(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d")})
+	assert.Equal(t, []string{"b", "c"}, collect(iter1))
+
+	iter2 := bpt.RangeIterator(IteratorOptions{Reverse: true, LowerBound: []byte("b"), UpperBound: []byte("d")})
+	assert.Equal(t, []string{"c", "b"}, collect(iter2))
+
+	iter3 := bpt.RangeIterator(IteratorOptions{Reverse: true, UpperBound: []byte("cc")})
+	assert.Equal(t, []string{"c", "b", "a"}, collect(iter3))
+
+	// 反向 seek 定位到第一个小于等于目标的 key
+	iter4 := bpt.RangeIterator(IteratorOptions{Reverse: true})
+	iter4.Seek([]byte("cc"))
+	assert.Equal(t, []byte("c"), iter4.Key())
+	iter4.Close()
+}
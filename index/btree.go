@@ -2,7 +2,6 @@ package index
 
 import (
 	"bitcask-go/data"
-	"bytes"
 	"sort"
 	"sync"
 
@@ -12,15 +11,27 @@ import (
 // BTree 索引,主要封装了 google 的 btree kv
 // https:://github.com/google/btree
 type BTree struct {
-	tree *btree.BTree  /* BTree 实例 */
-	lock *sync.RWMutex /* google BTree 多线程 write 不安全，Read 安全，所以需要锁自行加锁 */
+	tree *btree.BTreeG[*Item] /* BTree 实例 */
+	lock *sync.RWMutex        /* google BTree 多线程 write 不安全，读写并发同样不安全，所以需要锁自行加锁 */
+	cmp  Comparator           /* key 的比较规则 */
 }
 
-// 初始化 BTree 索引结构
+// 初始化 BTree 索引结构，按照字节序排列 key
 func NewBTree() *BTree {
+	return NewBTreeWithComparator(BytewiseComparator)
+}
+
+// NewBTreeWithComparator 初始化按照指定比较规则排列 key 的 BTree 索引结构，nil 表示按照字节序
+func NewBTreeWithComparator(cmp Comparator) *BTree {
+	if cmp == nil {
+		cmp = BytewiseComparator
+	}
 	return &BTree{
-		tree: btree.New(32),
+		tree: btree.NewG[*Item](32, func(a, b *Item) bool {
+			return cmp.Compare(a.key, b.key) < 0
+		}),
 		lock: new(sync.RWMutex),
+		cmp:  cmp,
 	}
 }
 
@@ -34,15 +45,15 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	bt.lock.Lock()
 
 	// 调用 BTree 内部提供的 insert 接口存储信息
-	oldItem := bt.tree.ReplaceOrInsert(it)
+	oldItem, ok := bt.tree.ReplaceOrInsert(it)
 
 	bt.lock.Unlock()
 
-	if oldItem == nil {
+	if !ok {
 		return nil
 	}
 
-	return oldItem.(*Item).pos
+	return oldItem.pos
 }
 
 // Get 通过 key 取出对应位置的索引信息
@@ -51,15 +62,17 @@ func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	// 封装 Item
 	it := &Item{key: key}
 
-	// 获取 key 对应的信息
-	btreeItem := bt.tree.Get(it)
+	// 获取 key 对应的信息，读操作与写操作并发时同样需要加锁
+	bt.lock.RLock()
+	btreeItem, ok := bt.tree.Get(it)
+	bt.lock.RUnlock()
 
 	// 如果未找到返回 nil
-	if btreeItem == nil {
+	if !ok {
 		return nil
 	}
 
-	return btreeItem.(*Item).pos
+	return btreeItem.pos
 }
 
 // Delete 通过 key 删除对应位置的索引信息
@@ -70,13 +83,13 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 
 	// 写操作加锁
 	bt.lock.Lock()
-	oldItem := bt.tree.Delete(it)
+	oldItem, ok := bt.tree.Delete(it)
 	bt.lock.Unlock()
 
-	if oldItem == nil {
+	if !ok {
 		return nil, false
 	}
-	return oldItem.(*Item).pos, true
+	return oldItem.pos, true
 }
 
 // Size 返回数据量的多少
@@ -86,12 +99,33 @@ func (bt *BTree) Size() int {
 
 // Iterator 初始化 BTree 迭代器
 func (bt *BTree) Iterator(reverse bool) Iterator {
+	return bt.RangeIterator(IteratorOptions{Reverse: reverse})
+}
+
+// RangeIterator 初始化只遍历指定范围的 BTree 迭代器
+func (bt *BTree) RangeIterator(opts IteratorOptions) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
 	bt.lock.Lock()
 	defer bt.lock.Unlock()
-	return newBTreeIterator(bt.tree, reverse)
+	return newBTreeIterator(bt.tree, bt.cmp, opts)
+}
+
+// Snapshot 获取索引的只读快照（google btree 写时复制，Clone 开销很小）
+func (bt *BTree) Snapshot() (Reader, error) {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+		cmp:  bt.cmp,
+	}, nil
+}
+
+// Sync 内存索引不需要持久化
+func (bt *BTree) Sync() error {
+	return nil
 }
 
 // Close 关闭索引
@@ -101,32 +135,56 @@ func (bt *BTree) Close() error {
 
 // BTree 索引迭代器
 type btreeIterator struct {
-	currIndex int     /* 当前遍历的下标 */
-	reverse   bool    /* 是否是反向遍历 */
-	values    []*Item /* key+位置索引信息 */
+	currIndex int        /* 当前遍历的下标 */
+	reverse   bool       /* 是否是反向遍历 */
+	cmp       Comparator /* key 的比较规则 */
+	values    []*Item    /* key+位置索引信息 */
 }
 
 // 新建 btreeIterator 结构
-func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
+func newBTreeIterator(tree *btree.BTreeG[*Item], cmp Comparator, opts IteratorOptions) *btreeIterator {
 
-	var idx int
-	values := make([]*Item, tree.Len())
+	// 不限制范围时可以预先分配好全部空间
+	var values []*Item
+	if opts.LowerBound == nil && opts.UpperBound == nil {
+		values = make([]*Item, 0, tree.Len())
+	}
 
-	// 将所有的数据存放到数组中
-	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
+	// 将范围内的数据存放到数组中，越过边界之后停止遍历
+	saveValues := func(item *Item) bool {
+		if opts.Reverse {
+			if opts.belowLower(cmp, item.key) {
+				return false
+			}
+			if opts.aboveUpper(cmp, item.key) {
+				return true
+			}
+		} else if opts.aboveUpper(cmp, item.key) {
+			return false
+		}
+		values = append(values, item)
 		return true
 	}
-	if reverse {
-		tree.Descend(saveValues)
+
+	// 直接从边界位置开始遍历
+	if opts.Reverse {
+		if opts.UpperBound != nil {
+			tree.DescendLessOrEqual(&Item{key: opts.UpperBound}, saveValues)
+		} else {
+			tree.Descend(saveValues)
+		}
 	} else {
-		tree.Ascend(saveValues)
+		if opts.LowerBound != nil {
+			tree.AscendGreaterOrEqual(&Item{key: opts.LowerBound}, saveValues)
+		} else {
+			tree.Ascend(saveValues)
+		}
 	}
 
 	return &btreeIterator{
 		currIndex: 0,
-		reverse:   reverse,
+		reverse:   opts.Reverse,
+		cmp:       cmp,
 		values:    values,
 	}
 }
@@ -142,11 +200,11 @@ func (bti *btreeIterator) Seek(key []byte) {
 	var idx int
 	if bti.reverse {
 		idx = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
+			return bti.cmp.Compare(bti.values[i].key, key) <= 0
 		})
 	} else {
 		idx = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
+			return bti.cmp.Compare(bti.values[i].key, key) >= 0
 		})
 	}
 	bti.currIndex = idx
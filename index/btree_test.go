@@ -126,3 +126,38 @@ func TestBTree_Iterator(t *testing.T) {
 	}
 
 }
+
+func TestBTree_RangeIterator(t *testing.T) {
+
+	bt := NewBTree()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		bt.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+	}
+
+	collect := func(iter Iterator) []string {
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	// [b, d)
+	iter1 := bt.RangeIterator(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d")})
+	assert.Equal(t, []string{"b", "c"}, collect(iter1))
+
+	// 反向 [b, d)
+	iter2 := bt.RangeIterator(IteratorOptions{Reverse: true, LowerBound: []byte("b"), UpperBound: []byte("d")})
+	assert.Equal(t, []string{"c", "b"}, collect(iter2))
+
+	// 只有下界
+	iter3 := bt.RangeIterator(IteratorOptions{LowerBound: []byte("cc")})
+	assert.Equal(t, []string{"d", "e"}, collect(iter3))
+
+	// 超出范围的 seek
+	iter4 := bt.RangeIterator(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d")})
+	iter4.Seek([]byte("a"))
+	assert.Equal(t, []byte("b"), iter4.Key())
+	iter4.Seek([]byte("d"))
+	assert.False(t, iter4.Valid())
+}
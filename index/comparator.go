@@ -0,0 +1,135 @@
+package index
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Comparator key 的比较规则，决定索引和迭代器中 key 的顺序
+// Compare 返回 0 当且仅当两个 key 的字节完全相同，ART 与 B+ 树索引仍然按照字节查找 key
+type Comparator interface {
+
+	// Compare a 小于、等于、大于 b 时分别返回负数、0、正数
+	Compare(a, b []byte) int
+
+	// Name 比较规则的名称，记录在数据目录中，重新打开时必须一致
+	Name() string
+}
+
+// BytewiseComparator 默认的比较规则，按照字节序比较
+var BytewiseComparator Comparator = bytewiseComparator{}
+
+// bytewiseComparator 按照字节序比较
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (bytewiseComparator) Name() string {
+	return "bitcask.BytewiseComparator"
+}
+
+// NewComparator 根据名称和比较函数创建比较规则
+func NewComparator(name string, compare func(a, b []byte) int) Comparator {
+	return &funcComparator{name: name, compare: compare}
+}
+
+// funcComparator 由比较函数实现的比较规则
+type funcComparator struct {
+	name    string
+	compare func(a, b []byte) int
+}
+
+func (fc *funcComparator) Compare(a, b []byte) int {
+	return fc.compare(a, b)
+}
+
+func (fc *funcComparator) Name() string {
+	return fc.name
+}
+
+// isBytewise 比较规则是否为字节序，nil 表示默认的字节序
+func isBytewise(cmp Comparator) bool {
+	return cmp == nil || cmp.Name() == BytewiseComparator.Name()
+}
+
+// ComparatorIndex 按照自定义比较规则遍历的索引，用于只能按照字节序存储 key 的 ART 和 B+ 树索引
+// 查找、写入和删除直接使用底层的索引，迭代器取出范围内的 key 之后按照比较规则排序
+type ComparatorIndex struct {
+	Indexer
+	cmp Comparator
+}
+
+// NewComparatorIndex 为索引加上自定义的比较规则
+func NewComparatorIndex(indexer Indexer, cmp Comparator) *ComparatorIndex {
+	return &ComparatorIndex{Indexer: indexer, cmp: cmp}
+}
+
+// Iterator 按照比较规则遍历的索引迭代器
+func (ci *ComparatorIndex) Iterator(reverse bool) Iterator {
+	return ci.RangeIterator(IteratorOptions{Reverse: reverse})
+}
+
+// RangeIterator 按照比较规则只遍历指定范围的索引迭代器
+func (ci *ComparatorIndex) RangeIterator(opts IteratorOptions) Iterator {
+	return newSortedIterator(ci.Indexer, ci.cmp, opts)
+}
+
+// Snapshot 获取索引当前时刻的只读快照，快照同样按照比较规则遍历
+func (ci *ComparatorIndex) Snapshot() (Reader, error) {
+	snapshot, err := ci.Indexer.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &comparatorReader{Reader: snapshot, cmp: ci.cmp}, nil
+}
+
+// comparatorReader 按照自定义比较规则遍历的索引快照
+type comparatorReader struct {
+	Reader
+	cmp Comparator
+}
+
+// Iterator 按照比较规则遍历的索引迭代器
+func (cr *comparatorReader) Iterator(reverse bool) Iterator {
+	return cr.RangeIterator(IteratorOptions{Reverse: reverse})
+}
+
+// RangeIterator 按照比较规则只遍历指定范围的索引迭代器
+func (cr *comparatorReader) RangeIterator(opts IteratorOptions) Iterator {
+	return newSortedIterator(cr.Reader, cr.cmp, opts)
+}
+
+// newSortedIterator 遍历底层索引中的全部 key，取出范围内的 key 并按照比较规则排序
+// 自定义的顺序与字节序无关，范围无法下推到底层索引
+func newSortedIterator(reader Reader, cmp Comparator, opts IteratorOptions) Iterator {
+
+	var values []*Item
+	iterator := reader.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		if opts.belowLower(cmp, key) || opts.aboveUpper(cmp, key) {
+			continue
+		}
+		values = append(values, &Item{
+			key: append([]byte(nil), key...),
+			pos: iterator.Value(),
+		})
+	}
+	iterator.Close()
+
+	sort.Slice(values, func(i, j int) bool {
+		if opts.Reverse {
+			return cmp.Compare(values[i].key, values[j].key) > 0
+		}
+		return cmp.Compare(values[i].key, values[j].key) < 0
+	})
+
+	return &btreeIterator{
+		currIndex: 0,
+		reverse:   opts.Reverse,
+		cmp:       cmp,
+		values:    values,
+	}
+}
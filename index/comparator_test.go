@@ -0,0 +1,112 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reverseComparator 按照字节序倒序比较
+var reverseComparator = NewComparator("test.reverse", func(a, b []byte) int {
+	return bytes.Compare(b, a)
+})
+
+// collectKeys 取出迭代器中所有的 key
+func collectKeys(iterator Iterator) []string {
+	var keys []string
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, string(iterator.Key()))
+	}
+	iterator.Close()
+	return keys
+}
+
+func TestIndex_Comparator(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "bptree-comparator")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	indexes := map[string]Indexer{
+		"btree":  NewIndex(Btree, "", false, reverseComparator),
+		"art":    NewIndex(ART, "", false, reverseComparator),
+		"bptree": NewIndex(BPTree, dir, false, reverseComparator),
+	}
+	for name, idx := range indexes {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"a", "b", "c", "d", "e"} {
+				idx.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 1})
+			}
+			assert.NotNil(t, idx.Get([]byte("c")))
+
+			assert.Equal(t, []string{"e", "d", "c", "b", "a"}, collectKeys(idx.Iterator(false)))
+			assert.Equal(t, []string{"a", "b", "c", "d", "e"}, collectKeys(idx.Iterator(true)))
+
+			// 上下界同样按照比较规则判断
+			assert.Equal(t, []string{"d", "c"}, collectKeys(idx.RangeIterator(IteratorOptions{
+				LowerBound: []byte("d"),
+				UpperBound: []byte("b"),
+			})))
+			assert.Equal(t, []string{"c", "d"}, collectKeys(idx.RangeIterator(IteratorOptions{
+				Reverse:    true,
+				LowerBound: []byte("d"),
+				UpperBound: []byte("b"),
+			})))
+
+			iterator := idx.Iterator(false)
+			iterator.Seek([]byte("cc"))
+			assert.True(t, iterator.Valid())
+			assert.Equal(t, []byte("c"), iterator.Key())
+			iterator.Close()
+
+			snapshot, err := idx.Snapshot()
+
+			assert.Nil(t, err)
+			idx.Put([]byte("f"), &data.LogRecordPos{Fid: 1, Offset: 1})
+			assert.Equal(t, []string{"e", "d", "c", "b", "a"}, collectKeys(snapshot.Iterator(false)))
+			assert.Nil(t, snapshot.Close())
+			assert.Nil(t, idx.Close())
+		})
+	}
+}
+
+func TestBTree_Comparator(t *testing.T) {
+
+	// 大端编码的有符号整数，按照数值比较
+	intComparator := NewComparator("test.int64", func(a, b []byte) int {
+		x, y := int64(binary.BigEndian.Uint64(a)), int64(binary.BigEndian.Uint64(b))
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	})
+	encode := func(v int64) []byte {
+		return binary.BigEndian.AppendUint64(nil, uint64(v))
+	}
+
+	bt := NewBTreeWithComparator(intComparator)
+	for _, v := range []int64{3, -2, 10, 0, -7} {
+		bt.Put(encode(v), &data.LogRecordPos{Fid: 1, Offset: v})
+	}
+
+	var values []int64
+	iterator := bt.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		values = append(values, iterator.Value().Offset)
+	}
+	iterator.Close()
+	assert.Equal(t, []int64{-7, -2, 0, 3, 10}, values)
+
+	pos, ok := bt.Delete(encode(-2))
+	assert.True(t, ok)
+	assert.Equal(t, int64(-2), pos.Offset)
+	assert.Nil(t, bt.Get(encode(-2)))
+}
@@ -2,9 +2,6 @@ package index
 
 import (
 	"bitcask-go/data"
-	"bytes"
-
-	"github.com/google/btree"
 )
 
 // 抽象的索引的接口  后续如果接入其余数据结构科直接使用这个接口
@@ -25,10 +22,38 @@ type Indexer interface {
 	// Iterator 索引迭代器
 	Iterator(reverse bool) Iterator
 
+	// RangeIterator 只遍历指定范围的索引迭代器
+	RangeIterator(opts IteratorOptions) Iterator
+
+	// Snapshot 获取索引当前时刻的只读快照
+	Snapshot() (Reader, error)
+
+	// Sync 将索引持久化到磁盘，内存索引直接返回
+	Sync() error
+
 	// Close 关闭索引
 	Close() error
 }
 
+// Reader 索引的只读视图，快照创建之后索引的修改对其不可见
+type Reader interface {
+
+	// Get 通过 key 取出对应位置的索引信息
+	Get(key []byte) *data.LogRecordPos
+
+	// Size 索引中的数据量
+	Size() int
+
+	// Iterator 索引迭代器
+	Iterator(reverse bool) Iterator
+
+	// RangeIterator 只遍历指定范围的索引迭代器
+	RangeIterator(opts IteratorOptions) Iterator
+
+	// Close 释放快照持有的资源
+	Close() error
+}
+
 // 抽象索引
 type IndexType = int8
 
@@ -38,29 +63,47 @@ const (
 	BPTree                      /* B+ 树索引 */
 )
 
-// NewIndex 根据类型初始化索引
-func NewIndex(typ IndexType, dirPath string, sync bool) Indexer {
+// NewIndex 根据类型初始化索引，cmp 为 key 的比较规则，nil 表示按照字节序
+// ART 和 B+ 树只能按照字节序存储 key，自定义的比较规则通过 ComparatorIndex 在遍历时生效
+func NewIndex(typ IndexType, dirPath string, sync bool, cmp Comparator) Indexer {
+	var indexer Indexer
 	switch typ {
 	case Btree:
-		return NewBTree()
+		return NewBTreeWithComparator(cmp)
 	case ART:
-		return NewART()
+		indexer = NewART()
 	case BPTree:
-		return NewBPlusTree(dirPath, sync)
+		indexer = NewBPlusTree(dirPath, sync)
 	default:
 		panic("unsupported index type")
 	}
+	if isBytewise(cmp) {
+		return indexer
+	}
+	return NewComparatorIndex(indexer, cmp)
 }
 
-// 实现 BTree 中的 Item
+// BTree 中存储的 key 以及位置索引信息
 type Item struct {
 	key []byte
 	pos *data.LogRecordPos
 }
 
-// BTree key 的比较逻辑
-func (ai *Item) Less(bi btree.Item) bool {
-	return bytes.Compare(ai.key, bi.(*Item).key) == -1
+// IteratorOptions 索引迭代器的配置项，遍历范围为 [LowerBound, UpperBound)
+type IteratorOptions struct {
+	Reverse    bool   /* 是否是反向遍历 */
+	LowerBound []byte /* 下界（包含），nil 表示不限制 */
+	UpperBound []byte /* 上界（不包含），nil 表示不限制 */
+}
+
+// belowLower 按照比较规则判断 key 是否小于下界
+func (opts IteratorOptions) belowLower(cmp Comparator, key []byte) bool {
+	return opts.LowerBound != nil && cmp.Compare(key, opts.LowerBound) < 0
+}
+
+// aboveUpper 按照比较规则判断 key 是否大于等于上界
+func (opts IteratorOptions) aboveUpper(cmp Comparator, key []byte) bool {
+	return opts.UpperBound != nil && cmp.Compare(key, opts.UpperBound) >= 0
 }
 
 // 通用索引迭代器接口
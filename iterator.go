@@ -3,6 +3,7 @@ package bitcaskkv
 import (
 	"bitcask-go/index"
 	"bytes"
+	"time"
 )
 
 // Iterator 迭代器
@@ -10,39 +11,63 @@ type Iterator struct {
 	indexIter index.Iterator  /* 索引迭代器 */
 	db        *DB             /* 对应 db */
 	Options   IteratorOptions /* 对应配置项 */
+	readTime  int64           /* 判断过期所用的时间点，0 表示使用当前时间 */
+	fileIds   []uint32        /* 迭代器引用的数据文件，关闭之前不会被 merge 删除 */
+	count     int             /* 已经遍历过的 key 数量，用于 Limit */
 }
 
 // 初始化迭代器
+// 迭代器引用创建时刻的所有数据文件，使用完毕后需要调用 Close
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	it := newIterator(db, db.index, opts, 0)
+	it.fileIds = db.pinFiles()
+	return it
+}
 
-	indexIter := db.index.Iterator(opts.Reverse)
+// newIterator 基于索引（或索引快照）创建迭代器，遍历范围下推到索引迭代器中
+func newIterator(db *DB, reader index.Reader, opts IteratorOptions, readTime int64) *Iterator {
+	lower, upper := opts.bounds(db.comparator())
+	indexIter := reader.RangeIterator(index.IteratorOptions{
+		Reverse:    opts.Reverse,
+		LowerBound: lower,
+		UpperBound: upper,
+	})
 	return &Iterator{
 		db:        db,
 		indexIter: indexIter,
 		Options:   opts,
+		readTime:  readTime,
 	}
 }
 
 // Rewind 重新回到迭代器的起点
 func (it *Iterator) Rewind() {
+	it.count = 0
 	it.indexIter.Rewind()
 	it.skipToNext()
 }
 
 // Seek 根据传入 key 查找第一个大于（或小于）等于的目标 Key，根据这个 Key 开始遍历
 func (it *Iterator) Seek(key []byte) {
+	it.count = 0
 	it.indexIter.Seek(key)
 	it.skipToNext()
 }
 
 // Next 跳转到下一个 Key
 func (it *Iterator) Next() {
+	it.count++
 	it.indexIter.Next()
 	it.skipToNext()
 }
 
-// Valid 是否有效，即是否已经遍历完所有的 key，用于退出遍历
+// Valid 是否有效，即是否已经遍历完所有的 key 或者达到 Limit，用于退出遍历
 func (it *Iterator) Valid() bool {
+	if it.Options.Limit > 0 && it.count >= it.Options.Limit {
+		return false
+	}
 	return it.indexIter.Valid()
 }
 
@@ -64,19 +89,68 @@ func (it *Iterator) Value() ([]byte, error) {
 // Close 关闭迭代器并且释放相关资源
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if it.fileIds != nil {
+		it.db.unpinFiles(it.fileIds)
+		it.fileIds = nil
+	}
 }
 
+// skipToNext 跳过已经过期的 key（范围的过滤已经下推到索引迭代器中，前缀只在字节序下下推）
 func (it *Iterator) skipToNext() {
-	prefixLen := len(it.Options.Prefix)
-	if prefixLen == 0 {
-		return
+	now := it.readTime
+	if now == 0 {
+		now = time.Now().UnixNano()
 	}
 
+	cmp := it.db.comparator()
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
-
-		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(it.Options.Prefix, key[:prefixLen]) == 0 {
+		if it.Options.hasPrefix(cmp, it.indexIter.Key()) && !isExpired(it.indexIter.Value(), now) {
 			break
 		}
 	}
 }
+
+// bounds 将前缀与上下界合并为最终的遍历范围 [lower, upper)，nil 表示不限制
+// 自定义比较规则时前缀不对应连续的范围，只返回上下界
+func (opts IteratorOptions) bounds(cmp Comparator) ([]byte, []byte) {
+	lower, upper := opts.LowerBound, opts.UpperBound
+	if len(opts.Prefix) == 0 || !isBytewiseComparator(cmp) {
+		return lower, upper
+	}
+
+	// 前缀对应的范围为 [prefix, prefix 的后继)
+	if lower == nil || bytes.Compare(opts.Prefix, lower) > 0 {
+		lower = opts.Prefix
+	}
+	if prefixEnd := prefixSuccessor(opts.Prefix); prefixEnd != nil {
+		if upper == nil || bytes.Compare(prefixEnd, upper) < 0 {
+			upper = prefixEnd
+		}
+	}
+	return lower, upper
+}
+
+// inRange 按照比较规则判断 key 是否在遍历范围内
+func (opts IteratorOptions) inRange(cmp Comparator, key []byte) bool {
+	lower, upper := opts.bounds(cmp)
+	if lower != nil && cmp.Compare(key, lower) < 0 {
+		return false
+	}
+	if upper != nil && cmp.Compare(key, upper) >= 0 {
+		return false
+	}
+	return opts.hasPrefix(cmp, key)
+}
+
+// prefixSuccessor 获取大于所有以 prefix 为前缀的 key 的最小值，前缀全为 0xff 时返回 nil
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := make([]byte, i+1)
+			copy(end, prefix[:i+1])
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
@@ -128,3 +128,62 @@ func TestDB_Iterator_Multi_Value(t *testing.T) {
 		assert.Nil(t, err)
 	}
 }
+
+func TestDB_Iterator_Range(t *testing.T) {
+
+	for _, typ := range []IndexerType{BTree, ART, BPTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-iterator-range")
+		opts.DirPath = dir
+		opts.IndexType = typ
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		for _, key := range []string{"aa", "ab", "ba", "bb", "bc", "ca"} {
+			err := db.Put([]byte(key), utils.GetTestValue(10))
+			assert.Nil(t, err)
+		}
+
+		collect := func(iterOpts IteratorOptions) []string {
+			iter := db.NewIterator(iterOpts)
+			defer iter.Close()
+			var keys []string
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+			}
+			return keys
+		}
+
+		/* 上下界 */
+		iterOpts := DefaultIteratorOptions
+		iterOpts.LowerBound = []byte("ab")
+		iterOpts.UpperBound = []byte("bc")
+		assert.Equal(t, []string{"ab", "ba", "bb"}, collect(iterOpts))
+
+		/* 反向 + Limit */
+		iterOpts.Reverse = true
+		iterOpts.Limit = 2
+		assert.Equal(t, []string{"bb", "ba"}, collect(iterOpts))
+
+		/* 前缀与上下界取交集 */
+		iterOpts = DefaultIteratorOptions
+		iterOpts.Prefix = []byte("b")
+		iterOpts.LowerBound = []byte("bb")
+		assert.Equal(t, []string{"bb", "bc"}, collect(iterOpts))
+
+		/* Scan 提前结束 */
+		var scanned []string
+		err = db.Scan([]byte("b"), nil, func(key []byte, value []byte) bool {
+			scanned = append(scanned, string(key))
+			return len(scanned) < 3
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"ba", "bb", "bc"}, scanned)
+
+		/* 销毁创建的临时 DB */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package bitcaskkv
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
 	"bitcask-go/utils"
 	"io"
 	"os"
@@ -9,19 +11,35 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	mergeDirName     = "-Merge"
-	mergeFinishedKey = "Merge.finished"
+	mergeDirName      = "-Merge"
+	mergeFinishedKey  = "Merge.finished"
+	mergeFirstFileKey = "Merge.firstFileId"
 )
 
 // Merge 清理无效数据，生成 Hint 文件
+// 重写数据期间不阻塞读写，完成之后立即生效，不需要重启
 func (db *DB) Merge() error {
+	_, err := db.merge(false)
+	return err
+}
+
+// merge 执行 merge 流程，返回本次 merge 回收的磁盘空间大小
+// force 为 true 时不检查无效数据是否达到阈值
+func (db *DB) merge(force bool) (int64, error) {
+
+	// 只读模式不能修改数据文件
+	if db.options.ReadOnly {
+		return 0, ErrReadOnly
+	}
 
 	// 如果活跃文件为空，则表明 db 为空
 	if db.activeFile == nil {
-		return nil
+		return 0, nil
 	}
 
 	db.mu.Lock()
@@ -29,59 +47,76 @@ func (db *DB) Merge() error {
 	// 该 db 是否已经在 meger
 	if db.isMerging {
 		db.mu.Unlock()
-		return ErrMergeIsProgress
+		return 0, ErrMergeIsProgress
 	}
 
 	// 查看可以 merge 的数据是否达到阈值
 	totalSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
 		db.mu.Unlock()
-		return err
+		return 0, err
 	}
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+	if !force && float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
-		return ErrMergeRatioUnreached
+		return 0, ErrMergeRatioUnreached
 	}
 
 	// 查看甚于空间容量是否可用容纳 merge 之后的数据量
 	availableDiskSize, err := utils.AvailableDiskSize()
 	if err != nil {
 		db.mu.Unlock()
-		return err
+		return 0, err
 	}
 	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
 		db.mu.Unlock()
-		return ErrNoEnoughSpaceForMerge
+		return 0, ErrNoEnoughSpaceForMerge
 	}
 
-	// 修改 isMerging
+	// 修改 isMerging，结束时同样需要持有 db.mu
 	db.isMerging = true
 	defer func() {
+		db.mu.Lock()
 		db.isMerging = false
+		db.mu.Unlock()
 	}()
 
 	/* 先对当前活跃文件进行处理 */
 	// 先将活跃文件持久化
-	if err := db.activeFile.Sync(); err != nil {
+	if err := db.syncActiveFiles(); err != nil {
 		db.mu.Unlock()
-		return err
+		return 0, err
 	}
 
+	// 为封存的数据文件写入 hint
+	db.writeFileHint()
+
 	// 将当前活跃文件转换为旧的数据文件
 	db.olderFiles[db.activeFile.FileId] = db.activeFile
 
-	// 打开新的活跃文件
-	if err := db.setActiveDataFile(); err != nil {
+	// merge 之后的数据文件使用紧接着旧文件的 id，与仍然在使用的旧文件互不冲突
+	// 重写之后的数据不会多于原来的数据，额外预留一个 id 应对文件末尾的空隙
+	firstMergeFileId := db.activeFile.FileId + 1
+	nonMergeFileId := firstMergeFileId + uint32(len(db.olderFiles)) + 1
+
+	// 打开新的活跃文件，跳过预留给 merge 的文件 id
+	if err := db.setActiveDataFileWithId(nonMergeFileId); err != nil {
 		db.mu.Unlock()
-		return nil
+		return 0, err
 	}
 
-	// 记录没有参与 merge 的文件 id（也就是新打开的文件）
-	nonMergeFileId := db.activeFile.FileId
+	// 重写期间使用当前的索引判断数据是否有效，已经删除的命名空间中的数据不再重写
+	indexes := db.namespaceIndexes()
 
 	// 取出所有需要 merge 的文件
 	var mergeFiles []*data.DataFile
+	var totalMergeSize int64
 	for _, file := range db.olderFiles {
+		size, err := file.IoManager.Size()
+		if err != nil {
+			db.mu.Unlock()
+			return 0, err
+		}
+		totalMergeSize += size
 		mergeFiles = append(mergeFiles, file)
 	}
 	db.mu.Unlock()
@@ -96,22 +131,69 @@ func (db *DB) Merge() error {
 	// 如果已经存在目录，则删除
 	if _, err := os.Stat(mergePath); err == nil {
 		if err := os.RemoveAll(mergePath); err != nil {
-			return err
+			return 0, err
 		}
 	}
 	// 新建目录
 	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
-		return err
+		return 0, err
+	}
+	defer func() {
+		_ = os.RemoveAll(mergePath)
+	}()
+
+	entries, err := db.rewriteMergeFiles(mergePath, mergeFiles, indexes, firstMergeFileId, nonMergeFileId)
+	if err != nil {
+		return 0, err
+	}
+
+	// 计算本次 merge 回收的空间大小
+	mergedSize, err := utils.DirSize(mergePath)
+	if err != nil {
+		return 0, err
 	}
 
+	/* 在线安装 merge 的结果 */
+	if err := db.installMergeFiles(mergePath, mergeFiles, entries, nonMergeFileId); err != nil {
+		return 0, err
+	}
+
+	if reclaimed := totalMergeSize - mergedSize; reclaimed > 0 {
+		return reclaimed, nil
+	}
+
+	return 0, nil
+}
+
+// mergedEntry merge 重写的一条数据，记录其重写前后的位置
+type mergedEntry struct {
+	key       []byte
+	namespace uint32
+	oldPos    *data.LogRecordPos
+	newPos    *data.LogRecordPos
+}
+
+// rewriteMergeFiles 将参与 merge 的文件中的有效数据重写到 merge 目录中，并生成 hint 文件以及 merge 完成标识
+// 重写后的数据文件 id 从 firstMergeFileId 开始，不能达到 nonMergeFileId，indexes 为各个命名空间的内存索引
+func (db *DB) rewriteMergeFiles(mergePath string, mergeFiles []*data.DataFile, indexes map[uint32]index.Indexer,
+	firstMergeFileId, nonMergeFileId uint32) ([]*mergedEntry, error) {
+
 	/* 新建零时 bitcask */
 	// 打开一个新的零时的 bitcask 实例
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	mergeOptions.AutoMergeInterval = 0
+	mergeOptions.BloomFilter = false /* 临时的 merge DB 不需要布隆过滤器，也不会留下 bloom-filter 文件 */
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer func() {
+		_ = mergeDB.Close()
+	}()
+	if err := mergeDB.setActiveDataFileWithId(firstMergeFileId); err != nil {
+		return nil, err
 	}
 
 	/* 将数据写入 Hint 文件中 */
@@ -119,10 +201,16 @@ func (db *DB) Merge() error {
 	// 打开 Hint 文件存储索引
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	hintFile.Encryptor = db.encryptor
+	defer func() {
+		_ = hintFile.Close()
+	}()
 
 	// 遍历每个数据文件
+	var entries []*mergedEntry
+	now := time.Now().UnixNano()
 	for _, dataFile := range mergeFiles {
 		var offset int64 = 0
 		for {
@@ -131,29 +219,44 @@ func (db *DB) Merge() error {
 				if err == io.EOF {
 					break
 				}
-				return err
+				return nil, err
 			}
 
-			// 解析拿到实际的 key
+			// 解析拿到实际的 key，已经删除的命名空间中的数据直接丢弃
 			realKey, _ := parseLogRecordKey(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
+			var logRecordPos *data.LogRecordPos
+			if idx, ok := indexes[logRecord.Namespace]; ok {
+				logRecordPos = idx.Get(realKey)
+			}
 
-			// 和内存中的索引位置进行比较，如果有效则重写
+			// 和内存中的索引位置进行比较，如果有效且未过期则重写
 			if logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileId &&
-				logRecordPos.Offset == offset {
+				logRecordPos.Offset == offset &&
+				!isExpired(logRecordPos, now) {
 
 				// 清楚事务标记
+				// merge 之后的文件是全量回放的起点，不再关联重写之前的位置
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+				logRecord.Origin = 0
 				pos, err := mergeDB.appendLogRecord(logRecord)
 				if err != nil {
-					return err
+					return nil, err
+				}
+				if pos.Fid >= nonMergeFileId {
+					return nil, ErrMergeFileIdExhausted
 				}
 
 				// 将当前位置索引写道 Hint 文件
-				if err := hintFile.WritHintRecord(realKey, pos); err != nil {
-					return err
+				if err := hintFile.WritHintRecord(realKey, logRecord.Namespace, pos); err != nil {
+					return nil, err
 				}
+				entries = append(entries, &mergedEntry{
+					key:       realKey,
+					namespace: logRecord.Namespace,
+					oldPos:    logRecordPos,
+					newPos:    pos,
+				})
 			}
 			offset += size
 		}
@@ -162,36 +265,166 @@ func (db *DB) Merge() error {
 	/* 持久化数据 */
 	// 对数据进行持久化
 	if err := hintFile.Sync(); err != nil {
-		return err
+		return nil, err
 	}
 	if err := mergeDB.Sync(); err != nil {
-		return err
+		return nil, err
 	}
 
 	/* 追加上 merge 完成标识 */
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	mergeFinRecords := []*data.LogRecord{
+		{Key: []byte(mergeFinishedKey), Value: []byte(strconv.Itoa(int(nonMergeFileId)))},
+		{Key: []byte(mergeFirstFileKey), Value: []byte(strconv.Itoa(int(firstMergeFileId)))},
+	}
+	for _, record := range mergeFinRecords {
+		encRecord, _, err := data.EncodeEncryptedLogRecord(record, db.encryptor)
+		if err != nil {
+			return nil, err
+		}
+		if err := mergeFinishedFile.Write(encRecord); err != nil {
+			return nil, err
+		}
+	}
+	if err := mergeFinishedFile.Sync(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// installMergeFiles 将 merge 目录中的文件移动到数据目录，并将索引指向重写之后的数据
+// merge 完成标识在索引更新并持久化之后最后移动，在此之前崩溃的话下次启动时由 loadMergeFiles 继续完成
+// 参与 merge 的旧文件不再被快照、迭代器引用之后才会删除
+func (db *DB) installMergeFiles(mergePath string, mergeFiles []*data.DataFile,
+	entries []*mergedEntry, nonMergeFileId uint32) error {
+
+	dirEntries, err := os.ReadDir(mergePath)
 	if err != nil {
 		return err
 	}
-	mergeFinRecord := &data.LogRecord{
-		Key:   []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
+
+	// 先打开重写之后的数据文件，移动之后文件句柄仍然有效
+	var mergedFiles []*data.DataFile
+	var moveNames []string
+	closeMergedFiles := func() {
+		for _, dataFile := range mergedFiles {
+			_ = dataFile.Close()
+		}
+	}
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		switch {
+		case !isMergeResultFile(name) || name == data.HintFileName || name == data.MergeFinishedFileName:
+			continue
+		case strings.HasSuffix(name, data.DataFileNameSuffix):
+			fid, err := strconv.Atoi(strings.TrimSuffix(name, data.DataFileNameSuffix))
+			if err != nil {
+				closeMergedFiles()
+				return ErrDataDirectoryCorrupted
+			}
+			dataFile, err := data.OpenDataFile(mergePath, uint32(fid), fio.StandardFIO)
+			if err != nil {
+				closeMergedFiles()
+				return err
+			}
+			dataFile.Encryptor = db.encryptor
+			mergedFiles = append(mergedFiles, dataFile)
+		}
+		moveNames = append(moveNames, name)
+	}
+	moveNames = append(moveNames, data.HintFileName)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 移动重写之后的数据文件以及 hint 文件
+	for _, name := range moveNames {
+		srcPath := filepath.Join(mergePath, name)
+		destPath := filepath.Join(db.options.DirPath, name)
+		if err := os.Rename(srcPath, destPath); err != nil {
+			closeMergedFiles()
+			return err
+		}
 	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
+
+	for _, dataFile := range mergedFiles {
+		db.olderFiles[dataFile.FileId] = dataFile
+	}
+
+	// 索引仍然指向重写前位置的 key 改为指向重写之后的位置，merge 期间被修改的 key 以及被删除的命名空间保持不变
+	for _, entry := range entries {
+		idx := db.namespaceIndex(entry.namespace)
+		var pos *data.LogRecordPos
+		if idx != nil {
+			pos = idx.Get(entry.key)
+		}
+		if pos == nil || pos.Fid != entry.oldPos.Fid || pos.Offset != entry.oldPos.Offset {
+			db.addReclaimSize(entry.newPos)
+			continue
+		}
+		idx.Put(entry.key, entry.newPos)
+	}
+
+	// B+ 树索引保存在磁盘上，必须在 merge 完成标识生效（旧文件可以被删除）之前持久化
+	if err := db.index.Sync(); err != nil {
 		return err
 	}
-	if err := mergeFinishedFile.Sync(); err != nil {
+	if err := os.Rename(filepath.Join(mergePath, data.MergeFinishedFileName),
+		filepath.Join(db.options.DirPath, data.MergeFinishedFileName)); err != nil {
 		return err
 	}
 
+	// 下线参与 merge 的旧文件，其中的无效数据已经被清理
+	for _, dataFile := range mergeFiles {
+		db.retireDataFile(dataFile)
+	}
+	db.resetReclaimSize()
+	db.historyFileId = nonMergeFileId
+
 	return nil
 }
 
+// isMergeResultFile 是否为 merge 产生、需要移动到数据目录中的文件
+// merge 临时目录中的索引、布隆过滤器、命名空间等文件属于临时的 merge DB，不能覆盖数据目录中正在使用的文件
+func isMergeResultFile(name string) bool {
+	return strings.HasSuffix(name, data.DataFileNameSuffix) ||
+		strings.HasSuffix(name, data.FileHintNameSuffix) ||
+		name == data.HintFileName ||
+		name == data.MergeFinishedFileName
+}
+
+// removeDataFile 关闭并删除已经下线的数据文件及其 hint
+// 删除失败的文件会在下次启动时由 removeMergedDataFiles 清理
+func (db *DB) removeDataFile(dataFile *data.DataFile) {
+	_ = dataFile.Close()
+	if db.valueCache != nil {
+		db.valueCache.removeFile(dataFile.FileId)
+	}
+
+	// 只读模式下数据文件由写入的进程删除
+	if db.options.ReadOnly {
+		return
+	}
+	_ = os.Remove(data.GetDataFileName(db.options.DirPath, dataFile.FileId))
+	_ = removeFileHint(db.options.DirPath, dataFile.FileId)
+}
+
 // getMergePath 拿取当前存储数据目录的路径
 func (db *DB) getMergePath() string {
-	dir := path.Dir(path.Clean(db.options.DirPath))
-	base := path.Base(db.options.DirPath)
+	return mergeDirPath(db.options.DirPath)
+}
+
+// mergeDirPath 数据目录对应的 merge 临时目录
+func mergeDirPath(dirPath string) string {
+	dir := path.Dir(path.Clean(dirPath))
+	base := path.Base(dirPath)
 	return filepath.Join(dir, base+mergeDirName)
 }
 
@@ -220,10 +453,7 @@ func (db *DB) loadMergeFiles() error {
 		if entry.Name() == data.MergeFinishedFileName {
 			mergeFinished = true
 		}
-		if entry.Name() == data.SeqNoFileName {
-			continue
-		}
-		if entry.Name() == fileLockName {
+		if !isMergeResultFile(entry.Name()) || entry.Name() == data.MergeFinishedFileName {
 			continue
 		}
 		mergeFileNames = append(mergeFileNames, entry.Name())
@@ -240,19 +470,27 @@ func (db *DB) loadMergeFiles() error {
 		return nil
 	}
 
-	// 删除旧的数据文件
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
+	// 删除旧的数据文件，旧版本 merge 的数据文件 id 从 0 开始，需要删除 nonMergeFileId 之前所有的文件
+	firstMergeFileId, ok, err := db.getFirstMergeFileId(mergePath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		firstMergeFileId = nonMergeFileId
+	}
+	if err := removeDataFilesBefore(db.options.DirPath, firstMergeFileId); err != nil {
+		return err
+	}
+
+	// 将新的数据文件移动到数据目录中，merge 完成标识最后移动
+	for _, fileName := range append(mergeFileNames, data.MergeFinishedFileName) {
+
+		// B+ 树索引在 merge 安装期间崩溃时可能仍然指向已经删除的旧文件
+		if fileName == data.MergeFinishedFileName && db.options.IndexType == BPTree {
+			if err := db.repairMergedIndex(firstMergeFileId); err != nil {
 				return err
 			}
 		}
-	}
-
-	// 将新的数据文件移动到数据目录中
-	for _, fileName := range mergeFileNames {
 
 		srcPath := filepath.Join(mergePath, fileName)
 		destPath := filepath.Join(db.options.DirPath, fileName)
@@ -264,12 +502,65 @@ func (db *DB) loadMergeFiles() error {
 	return nil
 }
 
+// repairMergedIndex 根据 merge 生成的 hint 文件，将 B+ 树索引中指向旧文件（id 小于 firstMergeFileId）的 key 指向重写之后的位置
+// hint 文件中没有的 key 在重写时已经无效（例如已经过期），直接从索引中删除
+func (db *DB) repairMergedIndex(firstMergeFileId uint32) error {
+
+	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
+	hintFile.Encryptor = db.encryptor
+
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		offset += size
+
+		// merge 之后被修改或者删除的 key 保持不变
+		if logRecord.Namespace != defaultNamespaceId {
+			continue
+		}
+		if pos := db.index.Get(logRecord.Key); pos != nil && pos.Fid < firstMergeFileId {
+			db.index.Put(logRecord.Key, data.DecodeLogRecordPos(logRecord.Value))
+		}
+	}
+
+	// 遍历期间持有只读事务，先收集需要删除的 key
+	var staleKeys [][]byte
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().Fid < firstMergeFileId {
+			staleKeys = append(staleKeys, append([]byte(nil), iterator.Key()...))
+		}
+	}
+	iterator.Close()
+	for _, key := range staleKeys {
+		db.index.Delete(key)
+	}
+
+	return db.index.Sync()
+}
+
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
 		return 0, err
 	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	mergeFinishedFile.Encryptor = db.encryptor
 	record, _, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
 		return 0, err
@@ -281,6 +572,86 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	return uint32(nonMergeFileId), nil
 }
 
+// getFirstMergeFileId 拿到 merge 之后第一个数据文件的 id，旧版本的 merge 完成标识中没有该信息
+func (db *DB) getFirstMergeFileId(dirPath string) (uint32, bool, error) {
+
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	mergeFinishedFile.Encryptor = db.encryptor
+
+	// 第一条记录是最近没有参与 merge 的文件 id
+	_, size, err := mergeFinishedFile.ReadLogRecord(0)
+	if err != nil {
+		return 0, false, err
+	}
+	record, _, err := mergeFinishedFile.ReadLogRecord(size)
+	if err == io.EOF {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	firstMergeFileId, err := strconv.Atoi(string(record.Value))
+	if err != nil {
+		return 0, false, err
+	}
+	return uint32(firstMergeFileId), true, nil
+}
+
+// removeMergedDataFiles 删除已经被最近一次 merge 替换的旧数据文件
+// 在线 merge 时仍然被引用的旧文件会在解除引用之后删除，进程在此之前退出的话由启动流程清理
+func (db *DB) removeMergedDataFiles() error {
+
+	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	// 最近一次 merge 之前的写入历史已经被重写
+	nonMergeFileId, err := db.getNonMergeFileId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	db.historyFileId = nonMergeFileId
+
+	firstMergeFileId, ok, err := db.getFirstMergeFileId(db.options.DirPath)
+	if err != nil || !ok {
+		return err
+	}
+	return removeDataFilesBefore(db.options.DirPath, firstMergeFileId)
+}
+
+// removeDataFilesBefore 删除目录中文件 id 小于 fileId 的数据文件及其 hint
+func removeDataFilesBefore(dirPath string, fileId uint32) error {
+
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, data.DataFileNameSuffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.TrimSuffix(name, data.DataFileNameSuffix))
+		if err != nil || uint32(fid) >= fileId {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dirPath, name)); err != nil {
+			return err
+		}
+		if err := removeFileHint(dirPath, uint32(fid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (db *DB) loadIndexFromHintFile() error {
 
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
@@ -293,6 +664,7 @@ func (db *DB) loadIndexFromHintFile() error {
 	if err != nil {
 		return err
 	}
+	hintFile.Encryptor = db.encryptor
 
 	// 读取文件中的索引
 	var offset int64 = 0
@@ -305,9 +677,13 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 
-		// 解码拿到实际的位置索引
+		// 解码拿到实际的位置索引，已经删除的命名空间中的数据都是无效数据
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		if idx := db.namespaceIndex(logRecord.Namespace); idx != nil {
+			idx.Put(logRecord.Key, pos)
+		} else {
+			db.addReclaimSize(pos)
+		}
 		offset += size
 	}
 
@@ -1,10 +1,14 @@
 package bitcaskkv
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
 	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -222,3 +226,293 @@ func TestDB_Merge5(t *testing.T) {
 		assert.Nil(t, err)
 	}
 }
+
+// merge 之后无需重启，数据立即从新的数据文件中读取，旧文件被删除
+func TestDB_OnlineMerge(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-online-merge")
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	for i := 0; i < 2000; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 3000; i < 3100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	assert.Nil(t, wb.Commit())
+
+	oldFiles := db.Stat().DataFileNum
+	diskSize := db.Stat().DiskSize
+	keys := db.ListKeys()
+
+	assert.Nil(t, db.Merge())
+
+	// 旧的数据文件已经被删除，空间立即回收
+	for fid := uint32(0); fid < uint32(oldFiles); fid++ {
+		_, err := os.Stat(data.GetDataFileName(dir, fid))
+		assert.True(t, os.IsNotExist(err))
+	}
+	assert.Less(t, db.Stat().DiskSize, diskSize)
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+
+	// 所有的数据都可以直接读取
+	assert.Equal(t, keys, db.ListKeys())
+	for i := 0; i < 2000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i%2 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.NotNil(t, val)
+		}
+	}
+
+	// merge 之后继续写入，再次 merge
+	for i := 1; i < 2000; i += 4 {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new")))
+	}
+	assert.Nil(t, db.Merge())
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+
+	// 重启之后数据一致
+	keys = db.ListKeys()
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keys, db.ListKeys())
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// merge 之前创建的快照和迭代器在 merge 之后仍然可以读取，解除引用之后旧文件才被删除
+func TestDB_OnlineMergeWithReaders(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-online-merge-readers")
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("v2")))
+	}
+
+	snap, err := db.Snapshot()
+
+	assert.Nil(t, err)
+	iter := db.NewIterator(DefaultIteratorOptions)
+
+	// merge 期间并发写入
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("v3")))
+		}
+	}()
+	assert.Nil(t, db.Merge())
+	wg.Wait()
+
+	// 旧文件仍然被引用，没有被删除
+	_, err = os.Stat(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+
+	val, err := snap.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		_, err := iter.Value()
+		assert.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 1000, count)
+
+	// merge 期间的写入没有被覆盖
+	val, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	val, err = db.Get(utils.GetTestKey(300))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	// 解除所有引用之后旧文件被删除
+	iter.Close()
+	_, err = os.Stat(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Release())
+	_, err = os.Stat(data.GetDataFileName(dir, 0))
+	assert.True(t, os.IsNotExist(err))
+
+	// 快照未释放时关闭，旧文件在下次启动时删除
+	snap, err = db.Snapshot()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	val, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	for _, fid := range snap.fileIds {
+		_, err = os.Stat(data.GetDataFileName(dir, fid))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+// B+ 树索引 merge 之后重启，数据目录中的索引文件不能被 merge 目录中的覆盖
+func TestDB_MergeBPTree(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPTree
+	opts.MMapAtStartup = false
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 2500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2500, len(db.ListKeys()))
+	for i := 0; i < 2500; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_MergeBPTreeCrash(t *testing.T) {
+
+	// 崩溃时已经更新到索引中的数据量
+	for _, applied := range []int{0, 1000} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-merge-bptree-crash")
+		opts.DirPath = dir
+		opts.IndexType = BPTree
+		opts.MMapAtStartup = false
+		opts.DataFileSize = 64 * 1024
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		// 部分数据在 merge 之前过期，不会被重写
+		for i := 0; i < 2500; i++ {
+			if i%5 == 0 {
+				assert.Nil(t, db.PutWithTTL(utils.GetTestKey(i), utils.GetTestValue(128), 50*time.Millisecond))
+			} else {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		// 按照 merge 的流程封存活跃文件并重写数据
+		db.mu.Lock()
+		assert.Nil(t, db.syncActiveFiles())
+		db.olderFiles[db.activeFile.FileId] = db.activeFile
+		firstMergeFileId := db.activeFile.FileId + 1
+		nonMergeFileId := firstMergeFileId + uint32(len(db.olderFiles)) + 1
+		assert.Nil(t, db.setActiveDataFileWithId(nonMergeFileId))
+		var mergeFiles []*data.DataFile
+		for _, dataFile := range db.olderFiles {
+			mergeFiles = append(mergeFiles, dataFile)
+		}
+		indexes := db.namespaceIndexes()
+		db.mu.Unlock()
+		sort.Slice(mergeFiles, func(i, j int) bool {
+			return mergeFiles[i].FileId < mergeFiles[j].FileId
+		})
+
+		mergePath := db.getMergePath()
+		assert.Nil(t, os.MkdirAll(mergePath, os.ModePerm))
+		entries, err := db.rewriteMergeFiles(mergePath, mergeFiles, indexes, firstMergeFileId, nonMergeFileId)
+		assert.Nil(t, err)
+
+		// merge 期间的写入不会被重写之后的位置覆盖
+		assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("after-merge")))
+
+		// 移动数据文件以及 hint 文件、更新部分索引之后，在移动 merge 完成标识之前崩溃
+		dirEntries, err := os.ReadDir(mergePath)
+		assert.Nil(t, err)
+		for _, entry := range dirEntries {
+			if name := entry.Name(); isMergeResultFile(name) && name != data.MergeFinishedFileName {
+				assert.Nil(t, os.Rename(filepath.Join(mergePath, name), filepath.Join(dir, name)))
+			}
+		}
+		for _, entry := range entries[:applied] {
+			if pos := db.index.Get(entry.key); pos.Fid == entry.oldPos.Fid && pos.Offset == entry.oldPos.Offset {
+				db.index.Put(entry.key, entry.newPos)
+			}
+		}
+		db.closeFiles()
+		assert.Nil(t, db.flieLock.Unlock())
+
+		// 重启之后旧文件被删除，所有的数据仍然可以读取
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		_, err = os.Stat(data.GetDataFileName(dir, 0))
+		assert.True(t, os.IsNotExist(err))
+		assert.Equal(t, 2000, len(db.ListKeys()))
+		for i := 0; i < 2500; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			switch {
+			case i%5 == 0:
+				assert.Equal(t, ErrKeyNotFound, err)
+			case i == 1:
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("after-merge"), value)
+			default:
+				assert.Nil(t, err)
+			}
+		}
+
+		/* 销毁创建的临时 DB 以及临时文件 */
+		assert.Nil(t, destroyDB(db))
+	}
+}
@@ -0,0 +1,429 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// defaultNamespaceId 默认命名空间，即直接通过 DB 读写的数据
+const defaultNamespaceId uint32 = 0
+
+/*
+命名空间在同一个 DB 中隔离出多组数据：每个命名空间拥有独立的内存索引，
+数据与默认命名空间共用数据文件、组提交以及 merge，记录的 header 中带有命名空间 id，启动时据此恢复到对应的索引中
+
+命名空间的名称与 id 保存在 namespace 文件中，id 不会复用：
+删除命名空间之后其中的数据不再属于任何索引，视为无效数据，在下一次 merge 或者 compaction 时回收
+
+命名空间中的写入不产生变更事件，不参与导出与主从复制，快照和事务也只包含默认命名空间，
+为了避免数据在从节点或者导出中静默丢失，存在命名空间时不能启动复制和导出，复制期间也不能创建命名空间
+*/
+
+// Namespace 命名空间，提供与 DB 相同的读写接口
+type Namespace struct {
+	db      *DB
+	name    string
+	id      uint32
+	index   index.Indexer /* 命名空间独立的内存索引 */
+	dropped bool          /* 是否已经被删除（需要持有 db.mu） */
+}
+
+// CreateNamespace 创建命名空间，名称已经存在时返回 ErrNamespaceExists
+func (db *DB) CreateNamespace(name string) (*Namespace, error) {
+
+	if len(name) == 0 {
+		return nil, ErrNamespaceNameEmpty
+	}
+
+	// B+ 树索引启动时不扫描数据文件，无法恢复命名空间的索引
+	if db.options.IndexType == BPTree {
+		return nil, ErrNamespaceUnsupported
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.readOnly && !db.replicaWriting {
+		return nil, ErrReadOnly
+	}
+	if db.findNamespace(name) != nil {
+		return nil, ErrNamespaceExists
+	}
+
+	// 命名空间中的数据不会复制到从节点
+	if db.replicating > 0 {
+		return nil, ErrNamespaceNotReplicated
+	}
+
+	ns := db.newNamespace(name, db.nextNamespaceId)
+	db.namespaces[ns.id] = ns
+	db.nextNamespaceId++
+
+	// 命名空间持久化之后才能写入数据
+	if err := db.saveNamespaces(); err != nil {
+		delete(db.namespaces, ns.id)
+		db.nextNamespaceId--
+		return nil, err
+	}
+	return ns, nil
+}
+
+// Namespace 获取已经存在的命名空间
+func (db *DB) Namespace(name string) (*Namespace, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if ns := db.findNamespace(name); ns != nil {
+		return ns, nil
+	}
+	return nil, ErrNamespaceNotFound
+}
+
+// ListNamespaces 获取所有命名空间的名称
+func (db *DB) ListNamespaces() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	names := make([]string, 0, len(db.namespaces))
+	for _, ns := range db.namespaces {
+		names = append(names, ns.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DropNamespace 删除命名空间及其中所有的数据，占用的空间在下一次 merge 或者 compaction 时回收
+// 已经获取的句柄之后的读写返回 ErrNamespaceNotFound
+func (db *DB) DropNamespace(name string) error {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.readOnly && !db.replicaWriting {
+		return ErrReadOnly
+	}
+	ns := db.findNamespace(name)
+	if ns == nil {
+		return ErrNamespaceNotFound
+	}
+
+	delete(db.namespaces, ns.id)
+	if err := db.saveNamespaces(); err != nil {
+		db.namespaces[ns.id] = ns
+		return err
+	}
+	db.dropNamespaceIndex(ns)
+	return nil
+}
+
+// dropNamespaceIndex 将已经删除的命名空间中的数据标记为无效数据（需要持有 db.mu）
+func (db *DB) dropNamespaceIndex(ns *Namespace) {
+
+	// 命名空间中所有的数据都成为无效数据
+	iterator := ns.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		db.addReclaimSize(iterator.Value())
+	}
+	iterator.Close()
+
+	// 进行中的 merge 可能仍然在使用旧的索引，因此替换而不是清空
+	ns.dropped = true
+	ns.index = index.NewIndex(db.options.IndexType, "", false, db.options.Comparator)
+}
+
+// newNamespace 初始化命名空间
+func (db *DB) newNamespace(name string, id uint32) *Namespace {
+	return &Namespace{
+		db:    db,
+		name:  name,
+		id:    id,
+		index: index.NewIndex(db.options.IndexType, "", false, db.options.Comparator),
+	}
+}
+
+// findNamespace 根据名称查找命名空间（需要持有 db.mu）
+func (db *DB) findNamespace(name string) *Namespace {
+	for _, ns := range db.namespaces {
+		if ns.name == name {
+			return ns
+		}
+	}
+	return nil
+}
+
+// namespaceIndex 获取命名空间 id 对应的内存索引，命名空间已经被删除时返回 nil（需要持有 db.mu）
+func (db *DB) namespaceIndex(id uint32) index.Indexer {
+	if id == defaultNamespaceId {
+		return db.index
+	}
+	if ns, ok := db.namespaces[id]; ok {
+		return ns.index
+	}
+	return nil
+}
+
+// namespaceIndexes 获取所有命名空间当前的内存索引，包括默认命名空间（需要持有 db.mu）
+func (db *DB) namespaceIndexes() map[uint32]index.Indexer {
+	indexes := map[uint32]index.Indexer{defaultNamespaceId: db.index}
+	for id, ns := range db.namespaces {
+		indexes[id] = ns.index
+	}
+	return indexes
+}
+
+// namespaceKey 将命名空间 id 与 key 编码为唯一的字符串，用于暂存不同命名空间中的数据
+func namespaceKey(id uint32, key []byte) string {
+	buf := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen32+len(key)), uint64(id))
+	return string(append(buf, key...))
+}
+
+/*
+namespace 文件的格式为一组记录：
+第一条记录的 key 为空，value 为下一个命名空间 id；之后每个命名空间一条记录，key 为名称，记录的命名空间 id 即为其 id
+*/
+
+// loadNamespaces 加载 namespace 文件中记录的命名空间
+func (db *DB) loadNamespaces() error {
+
+	names, nextId, err := db.readNamespaces()
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 && db.options.IndexType == BPTree {
+		return ErrNamespaceUnsupported
+	}
+
+	db.namespaces = make(map[uint32]*Namespace)
+	db.nextNamespaceId = nextId
+	for id, name := range names {
+		db.namespaces[id] = db.newNamespace(name, id)
+	}
+	return nil
+}
+
+// readNamespaces 读取 namespace 文件，返回所有命名空间的名称以及下一个命名空间 id
+func (db *DB) readNamespaces() (map[uint32]string, uint32, error) {
+
+	names := make(map[uint32]string)
+	var nextId = defaultNamespaceId + 1
+
+	fileName := filepath.Join(db.options.DirPath, data.NamespaceFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return names, nextId, nil
+	}
+
+	namespaceFile, err := data.OpenNamespaceFile(db.options.DirPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = namespaceFile.Close()
+	}()
+	namespaceFile.Encryptor = db.encryptor
+
+	var offset int64 = 0
+	for {
+		record, size, err := namespaceFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, 0, err
+		}
+
+		if offset == 0 {
+			id, err := strconv.ParseUint(string(record.Value), 10, 32)
+			if err != nil {
+				return nil, 0, ErrDataDirectoryCorrupted
+			}
+			nextId = uint32(id)
+		} else {
+			names[record.Namespace] = string(record.Key)
+		}
+		offset += size
+	}
+	return names, nextId, nil
+}
+
+// saveNamespaces 原子地重写 namespace 文件（需要持有 db.mu）
+func (db *DB) saveNamespaces() error {
+
+	records := []*data.LogRecord{{Value: []byte(strconv.FormatUint(uint64(db.nextNamespaceId), 10))}}
+	for _, ns := range db.namespaces {
+		records = append(records, &data.LogRecord{Key: []byte(ns.name), Namespace: ns.id})
+	}
+
+	var buf bytes.Buffer
+	for _, record := range records {
+		encRecord, _, err := data.EncodeEncryptedLogRecord(record, db.encryptor)
+		if err != nil {
+			return err
+		}
+		buf.Write(encRecord)
+	}
+
+	tmpName := filepath.Join(db.options.DirPath, data.NamespaceFileName+".tmp")
+	f, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(db.options.DirPath, data.NamespaceFileName))
+}
+
+// Name 命名空间的名称
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Put 向命名空间写入数据（key 不能为空）
+func (ns *Namespace) Put(key []byte, value []byte) error {
+
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	logRecord := &data.LogRecord{
+		Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:     value,
+		Type:      data.LogRecordNormal,
+		Namespace: ns.id,
+	}
+
+	var dropped bool
+	_, err := ns.db.appendLogRecordWithLock(logRecord, func(pos *data.LogRecordPos) {
+		// 写入期间命名空间被删除，写入的数据直接成为无效数据
+		if dropped = ns.dropped; dropped {
+			ns.db.addReclaimSize(pos)
+			return
+		}
+		if oldPos := ns.index.Put(key, pos); oldPos != nil {
+			ns.db.addReclaimSize(oldPos)
+		}
+	})
+	if err == nil && dropped {
+		return ErrNamespaceNotFound
+	}
+	return err
+}
+
+// Delete 删除命名空间中的数据
+func (ns *Namespace) Delete(key []byte) error {
+
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	// 先检查 key 是否存在
+	idx, err := ns.getIndex()
+	if err != nil {
+		return err
+	}
+	if pos := idx.Get(key); pos == nil {
+		return nil
+	}
+
+	logRecord := &data.LogRecord{
+		Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type:      data.LogRecordDeleted,
+		Namespace: ns.id,
+	}
+
+	var dropped bool
+	_, err = ns.db.appendLogRecordWithLock(logRecord, func(pos *data.LogRecordPos) {
+		ns.db.addTombstoneSize(pos)
+		if dropped = ns.dropped; dropped {
+			return
+		}
+		if oldPos, _ := ns.index.Delete(key); oldPos != nil {
+			ns.db.addReclaimSize(oldPos)
+		}
+	})
+	if err == nil && dropped {
+		return ErrNamespaceNotFound
+	}
+	return err
+}
+
+// Get 读取命名空间中的数据
+func (ns *Namespace) Get(key []byte) ([]byte, error) {
+
+	ns.db.mu.Lock()
+	defer ns.db.mu.Unlock()
+
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if ns.dropped {
+		return nil, ErrNamespaceNotFound
+	}
+	return ns.db.getFromIndex(ns.index, key)
+}
+
+// ListKeys 获取命名空间中所有的 key
+func (ns *Namespace) ListKeys() ([][]byte, error) {
+	idx, err := ns.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	return listKeys(idx), nil
+}
+
+// Fold 遍历命名空间中所有的数据，并且执行用户指定操作(func 返回 false 中止遍历)
+func (ns *Namespace) Fold(fn func(key []byte, value []byte) bool) error {
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+	if ns.dropped {
+		return ErrNamespaceNotFound
+	}
+	return ns.db.scanIndex(ns.index, nil, nil, fn)
+}
+
+// NewIterator 创建遍历命名空间的迭代器，使用完毕后需要调用 Close
+func (ns *Namespace) NewIterator(opts IteratorOptions) *Iterator {
+	ns.db.mu.Lock()
+	defer ns.db.mu.Unlock()
+	it := newIterator(ns.db, ns.index, opts, 0)
+	it.fileIds = ns.db.pinFiles()
+	return it
+}
+
+// NewWriteBatch 创建默认写入该命名空间的原子写，同一个原子写可以通过 PutIn / DeleteIn 写入其他命名空间
+func (ns *Namespace) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
+	wb := ns.db.NewWriteBatch(opts)
+	wb.namespace = ns
+	return wb
+}
+
+// getIndex 获取命名空间当前的内存索引
+func (ns *Namespace) getIndex() (index.Indexer, error) {
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+	if ns.dropped {
+		return nil, ErrNamespaceNotFound
+	}
+	return ns.index, nil
+}
+
+// namespaceId 命名空间的 id，nil 表示默认命名空间
+func namespaceId(ns *Namespace) uint32 {
+	if ns == nil {
+		return defaultNamespaceId
+	}
+	return ns.id
+}
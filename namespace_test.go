@@ -0,0 +1,371 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Namespace(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	orders, err := db.CreateNamespace("orders")
+	assert.Nil(t, err)
+	_, err = db.CreateNamespace("users")
+	assert.Equal(t, ErrNamespaceExists, err)
+	_, err = db.CreateNamespace("")
+	assert.Equal(t, ErrNamespaceNameEmpty, err)
+	assert.Equal(t, []string{"orders", "users"}, db.ListNamespaces())
+
+	// 相同的 key 在不同的命名空间中互不影响
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("default")))
+		assert.Nil(t, users.Put(utils.GetTestKey(i), []byte("users")))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, users.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, orders.Put([]byte("order-1"), []byte("value")))
+
+	check := func(db *DB) {
+		users, err := db.Namespace("users")
+		assert.Nil(t, err)
+		orders, err := db.Namespace("orders")
+		assert.Nil(t, err)
+
+		value, err := db.Get(utils.GetTestKey(0))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("default"), value)
+		_, err = users.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrKeyNotFound, err)
+		value, err = users.Get(utils.GetTestKey(100))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("users"), value)
+		_, err = db.Get([]byte("order-1"))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		assert.Equal(t, 500, len(db.ListKeys()))
+		keys, err := users.ListKeys()
+		assert.Nil(t, err)
+		assert.Equal(t, 400, len(keys))
+
+		var count int
+		assert.Nil(t, orders.Fold(func(key []byte, value []byte) bool {
+			count++
+			return true
+		}))
+		assert.Equal(t, 1, count)
+
+		iter := users.NewIterator(IteratorOptions{Prefix: []byte("bitcask-go-key-0000001")})
+		defer iter.Close()
+		count = 0
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			value, err := iter.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("users"), value)
+			count++
+		}
+		assert.Equal(t, 100, count)
+		iter.Seek(utils.GetTestKey(150))
+		assert.True(t, iter.Valid())
+		assert.Equal(t, utils.GetTestKey(150), iter.Key())
+	}
+	check(db)
+
+	// 重启之后从 hint 和数据文件中恢复到各自的索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// merge 之后仍然保持隔离
+	assert.Nil(t, db.Merge())
+	check(db)
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDB_NamespaceWriteBatch(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-batch")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	accounts, err := db.CreateNamespace("accounts")
+	assert.Nil(t, err)
+	logs, err := db.CreateNamespace("logs")
+	assert.Nil(t, err)
+	assert.Nil(t, logs.Put([]byte("old"), []byte("value")))
+
+	// 一个原子写跨越多个命名空间，相同的 key 分别暂存
+	wb := accounts.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("alice"), []byte("100")))
+	assert.Nil(t, wb.PutIn(logs, []byte("alice"), []byte("deposit")))
+	assert.Nil(t, wb.PutIn(nil, []byte("alice"), []byte("default")))
+	assert.Nil(t, wb.DeleteIn(logs, []byte("old")))
+	assert.Nil(t, wb.Commit())
+
+	check := func(db *DB) {
+		accounts, err := db.Namespace("accounts")
+		assert.Nil(t, err)
+		logs, err := db.Namespace("logs")
+		assert.Nil(t, err)
+
+		value, err := accounts.Get([]byte("alice"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("100"), value)
+		value, err = logs.Get([]byte("alice"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("deposit"), value)
+		value, err = db.Get([]byte("alice"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("default"), value)
+		_, err = logs.Get([]byte("old"))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	check(db)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// 原子写中的命名空间被删除之后整个原子写失败
+	logs, err = db.Namespace("logs")
+	assert.Nil(t, err)
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("bob"), []byte("value")))
+	assert.Nil(t, wb.PutIn(logs, []byte("bob"), []byte("value")))
+	assert.Nil(t, db.DropNamespace("logs"))
+	assert.Equal(t, ErrNamespaceNotFound, wb.Commit())
+	_, err = db.Get([]byte("bob"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// 命名空间中的原子写同样经过组提交
+func TestDB_NamespaceWriteBatchGroupCommit(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-group-commit")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.GroupCommit = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	accounts, err := db.CreateNamespace("accounts")
+	assert.Nil(t, err)
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				wb := accounts.NewWriteBatch(DefaultWriteBatchOptions)
+				assert.Nil(t, wb.Put(utils.GetTestKey(g*100+i), []byte("ns")))
+				assert.Nil(t, wb.PutIn(nil, utils.GetTestKey(g*100+i), []byte("default")))
+				assert.Nil(t, wb.Commit())
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	check := func(db *DB) {
+		accounts, err := db.Namespace("accounts")
+		assert.Nil(t, err)
+		keys, err := accounts.ListKeys()
+		assert.Nil(t, err)
+		assert.Equal(t, 160, len(keys))
+		assert.Equal(t, 160, len(db.ListKeys()))
+		value, err := accounts.Get(utils.GetTestKey(719))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("ns"), value)
+	}
+	check(db)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDB_DropNamespace(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-drop-namespace")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	tmp, err := db.CreateNamespace("tmp")
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+		assert.Nil(t, tmp.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+
+	// 删除之后命名空间中的数据都成为无效数据
+	assert.Nil(t, db.DropNamespace("tmp"))
+	assert.Equal(t, ErrNamespaceNotFound, db.DropNamespace("tmp"))
+	_, err = db.Namespace("tmp")
+	assert.Equal(t, ErrNamespaceNotFound, err)
+	_, err = tmp.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrNamespaceNotFound, err)
+	assert.Equal(t, ErrNamespaceNotFound, tmp.Put(utils.GetTestKey(0), []byte("value")))
+	reclaimable := db.Stat().ReclaimableSize
+	assert.Greater(t, reclaimable, int64(0))
+
+	// 重新创建的同名命名空间是空的
+	tmp, err = db.CreateNamespace("tmp")
+	assert.Nil(t, err)
+	_, err = tmp.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后被删除的数据仍然是无效数据
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, db.Stat().ReclaimableSize, reclaimable)
+	tmp, err = db.Namespace("tmp")
+	assert.Nil(t, err)
+	keys, err := tmp.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(keys))
+
+	// merge 回收被删除的数据
+	diskSize := db.Stat().DiskSize
+	assert.Nil(t, db.Merge())
+	assert.Less(t, db.Stat().DiskSize, diskSize*3/4)
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	tmp, err = db.Namespace("tmp")
+	assert.Nil(t, err)
+	_, err = tmp.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_NamespaceBPTree(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	_, err = db.CreateNamespace("users")
+	assert.Equal(t, ErrNamespaceUnsupported, err)
+
+	// 已经存在命名空间的数据目录不能以 B+ 树索引打开
+	nsDir, _ := os.MkdirTemp("", "bitcask-go-namespace-bptree-open")
+	defer func() {
+		_ = os.RemoveAll(nsDir)
+	}()
+	nsOpts := DefaultOptions
+	nsOpts.DirPath = nsDir
+	nsDB, err := Open(nsOpts)
+	assert.Nil(t, err)
+	_, err = nsDB.CreateNamespace("users")
+	assert.Nil(t, err)
+	assert.Nil(t, nsDB.Close())
+
+	nsOpts.IndexType = BPTree
+	_, err = Open(nsOpts)
+	assert.Equal(t, ErrNamespaceUnsupported, err)
+}
+
+func TestDB_NamespaceReplicationExport(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-replication")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	// 复制期间不能创建命名空间，停止复制之后可以
+	server, err := db.StartReplication("127.0.0.1:0")
+	assert.Nil(t, err)
+	_, err = db.CreateNamespace("users")
+	assert.Equal(t, ErrNamespaceNotReplicated, err)
+	assert.Nil(t, server.Close())
+	_ = server.Close() /* 重复关闭不会重复减少复制服务的计数 */
+
+	ns, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	assert.Nil(t, ns.Put(utils.GetTestKey(1), utils.GetTestValue(10)))
+
+	// 存在命名空间时不能启动复制和导出，避免其中的数据静默丢失
+	_, err = db.StartReplication("127.0.0.1:0")
+	assert.Equal(t, ErrNamespaceNotReplicated, err)
+	var buf bytes.Buffer
+	assert.Equal(t, ErrNamespaceNotReplicated, db.Export(&buf))
+
+	// 删除命名空间之后恢复
+	assert.Nil(t, db.DropNamespace("users"))
+	assert.Nil(t, db.Export(&buf))
+	server, err = db.StartReplication("127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Nil(t, server.Close())
+}
@@ -1,22 +1,64 @@
 package bitcaskkv
 
-import "os"
+import (
+	"bitcask-go/data"
+	"os"
+	"time"
+)
 
 // 数据库配置项结构体
 type Options struct {
 	DirPath            string      /* 数据库的数据目录 */
 	DataFileSize       int64       /* activeFile 对应阈值大小 */
 	SyncWrites         bool        /* 每次写数据是否持久化 */
+	GroupCommit        bool        /* SyncWrites 时是否将并发的写入、原子写和事务合并为一次持久化，默认关闭 */
 	BytesPerSync       uint        /* 累计写入多少字节进行持久化 */
-	IndexType          IndexerType /* 内存索引类型 */
+	IndexType          IndexerType /* 内存索引类型，BPTree 不支持命名空间，存在命名空间的数据目录以 BPTree 打开返回 ErrNamespaceUnsupported */
 	MMapAtStartup      bool        /* IO 接口是否使用 MMap */
 	DataFileMergeRatio float32     /* 数据文件合并的阈值 */
+
+	RecoveryConcurrency int /* 启动时并行解码数据文件的 worker 数量，0 表示使用 CPU 核数 */
+
+	CompactionGarbageRatio float32 /* Compact 选择文件的阈值，可以回收的无效数据占文件大小的比例 */
+
+	/* 后台自动 merge 相关 */
+	AutoMergeInterval  time.Duration /* 后台检查是否需要 merge 的间隔，0 表示关闭自动 merge */
+	AutoMergeStartHour int           /* 允许自动 merge 的时间窗口起点（本地时间，小时） */
+	AutoMergeEndHour   int           /* 允许自动 merge 的时间窗口终点（不包含），与起点相同表示全天 */
+
+	WatchBufferSize int /* 每个 Watch 订阅者的事件缓冲区大小，写满时终止该订阅 */
+
+	/* value 压缩相关 */
+	Compression        CompressionType /* value 的压缩算法，默认不压缩 */
+	CompressionMinSize int             /* 小于该大小的 value 不进行压缩 */
+
+	ValueCacheSize int64 /* value 缓存占用内存的上限（字节），0 表示不缓存 */
+
+	/* key-value 分离相关 */
+	ValueThreshold   int     /* 大于等于该大小的 value 写入单独的 blob 文件，0 表示不分离 */
+	BlobGarbageRatio float32 /* GCBlobFiles 回收 blob 文件的阈值，无效 value 占文件大小的比例 */
+
+	/* 布隆过滤器相关 */
+	BloomFilter            bool    /* 是否使用布隆过滤器，查询不存在的 key 时不访问索引（主要用于 B+ 树索引） */
+	BloomFalsePositiveRate float64 /* 布隆过滤器的目标误判率 */
+
+	/* 只读模式相关 */
+	ReadOnly bool /* 以只读模式打开，不持有文件锁，可以与写入的进程共享数据目录，不创建任何文件，通过 Refresh 追上写入的数据 */
+
+	/* key 比较规则相关，名称记录在数据目录中，之后必须使用相同的比较规则打开 */
+	Comparator Comparator /* key 的比较规则，决定索引和迭代器中 key 的顺序，nil 表示按照字节序 */
+
+	/* 静态数据加密相关，轮换密钥后调用 RotateEncryptionKey（或者 Merge）使用新密钥重写已有数据；B+ 树索引文件不加密 */
+	Encryption KeyProvider /* 数据文件、hint 文件、序列号文件以及 merge 完成文件的加密密钥，nil 表示不加密 */
 }
 
 // 迭代器配置项结构体
 type IteratorOptions struct {
-	Prefix  []byte /* 遍历前缀为指定值的 Key, 默认 空 */
-	Reverse bool   /* 是否反向遍历，false 是正向 */
+	Prefix     []byte /* 遍历前缀为指定值的 Key, 默认 空 */
+	Reverse    bool   /* 是否反向遍历，false 是正向 */
+	LowerBound []byte /* 遍历范围的下界（包含），默认 空 表示不限制 */
+	UpperBound []byte /* 遍历范围的上界（不包含），默认 空 表示不限制 */
+	Limit      int    /* 最多遍历的 key 数量，0 表示不限制 */
 }
 
 // 原子写配置项结构体
@@ -25,31 +67,72 @@ type WriteBatchOptions struct {
 	SyncWrites  bool /* 提交时是否需要 Sync 持久化 */
 }
 
+// 离线修复配置项结构体
+type RepairOptions struct {
+	Encryption    KeyProvider /* 数据库使用的密钥，重建 hint 文件时需要读取 key */
+	QuarantineDir string      /* 损坏数据的隔离目录，默认 空 表示直接丢弃 */
+}
+
+// 导入配置项结构体
+type ImportOptions struct {
+	BatchSize    int  /* 每次持有写锁写入的记录数 */
+	SkipExisting bool /* 是否跳过已经存在的 key，默认覆盖 */
+}
+
 type IndexerType = int8
 
 const (
 	BTree  IndexerType = iota + 1 /* BTree 索引 */
-	ART                           /* ART 自适应基数树索引 */
-	BPTree                        /* BPTree B+树索引 */
+	ART                           /* ART 自适应基数树索引，范围遍历需要从最小的 key 扫描到上界 */
+	BPTree                        /* BPTree B+树索引，不支持命名空间 */
 )
 
-var DefaultOptions = Options{
-	DirPath:            os.TempDir(),
-	DataFileSize:       1024 * 1024 * 1024,
-	SyncWrites:         false,
-	BytesPerSync:       64 * 1024 * 1024,
-	IndexType:          BTree,
-	MMapAtStartup:      true,
-	DataFileMergeRatio: 0.5, // 0.5 表示无效数据达到总数据的一半，则进行 merge 操作
+type CompressionType = data.CompressionType
 
+const (
+	CompressionNone   = data.CompressionNone   /* 不压缩 */
+	CompressionSnappy = data.CompressionSnappy /* snappy 压缩 */
+	CompressionZstd   = data.CompressionZstd   /* zstd 压缩 */
+)
+
+var DefaultOptions = Options{
+	DirPath:                os.TempDir(),
+	DataFileSize:           1024 * 1024 * 1024,
+	SyncWrites:             false,
+	GroupCommit:            false,
+	BytesPerSync:           64 * 1024 * 1024,
+	IndexType:              BTree,
+	MMapAtStartup:          true,
+	DataFileMergeRatio:     0.5, // 0.5 表示无效数据达到总数据的一半，则进行 merge 操作
+	RecoveryConcurrency:    0,
+	CompactionGarbageRatio: 0.5,
+	AutoMergeInterval:      0,
+	AutoMergeStartHour:     0,
+	AutoMergeEndHour:       0,
+	WatchBufferSize:        1024,
+	Compression:            CompressionNone,
+	CompressionMinSize:     256,
+	ValueCacheSize:         0,
+	ValueThreshold:         0,
+	BlobGarbageRatio:       0.5,
+	BloomFilter:            false,
+	BloomFalsePositiveRate: 0.01,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
-	Prefix:  nil,
-	Reverse: false,
+	Prefix:     nil,
+	Reverse:    false,
+	LowerBound: nil,
+	UpperBound: nil,
+	Limit:      0,
 }
 
 var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrites:  true,
 }
+
+var DefaultImportOptions = ImportOptions{
+	BatchSize:    1000,
+	SkipExisting: false,
+}
@@ -0,0 +1,273 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+只读模式的 DB 与写入的进程共享数据目录：写入方持有独占的文件锁，只读的进程持有单独的共享锁，
+启动时与写入方一样从 hint 和数据文件中加载索引，但不创建、不修改、不删除任何文件
+
+写入方之后追加的数据通过 Refresh 读取：
+活跃文件中新追加的完整记录以及新的数据文件按照写入顺序更新到内存索引，写入到一半的记录留到下一次 Refresh；
+compaction 先将有效数据追加到活跃文件再删除旧文件，因此同样可以增量地追上；
+merge 会在已经读取的文件之前插入重写之后的文件，检测到 merge 之后重新加载全部索引
+*/
+
+// Refresh 只读模式下读取写入的进程新写入的数据，使内存索引追上写入方；非只读模式下不做任何事
+// 已经被写入方删除的数据文件在不再被快照、迭代器引用之后关闭
+func (db *DB) Refresh() error {
+
+	if !db.options.ReadOnly {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.refreshNamespaces(); err != nil {
+		return err
+	}
+
+	// 先列出 blob 文件：写入方回收 blob 文件之前已经将有效的 value 写入到新的 blob 文件并追加了指向它的记录
+	blobFileIds, err := listBlobFileIds(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+
+	// 再列出数据文件：写入方删除数据文件之前已经将其中有效的数据写入到更新的文件中，之后一定可以读到
+	fileIds, err := listDataFileIds(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+
+	// 发生了 merge 的话重新加载全部索引
+	historyFileId, err := db.mergedHistoryFileId()
+	if err != nil {
+		return err
+	}
+	if historyFileId != db.historyFileId || db.hasMergedFiles(fileIds) {
+		if err := db.reloadIndex(); err != nil {
+			return err
+		}
+		return db.refreshBlobFiles(blobFileIds)
+	}
+
+	// 读取活跃文件中新追加的数据，已经被删除的活跃文件仍然可以通过打开的句柄读取
+	if db.activeFile != nil {
+		if err := db.tailDataFile(db.activeFile); err != nil {
+			return err
+		}
+	}
+
+	// 依次读取新的数据文件，最后一个作为活跃文件
+	present := make(map[uint32]bool, len(fileIds))
+	for _, fid := range fileIds {
+		present[fid] = true
+		if db.activeFile != nil && fid <= db.activeFile.FileId {
+			continue
+		}
+
+		dataFile, err := data.OpenDataFile(db.options.DirPath, fid, fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		dataFile.Encryptor = db.encryptor
+		if db.activeFile != nil {
+			db.olderFiles[db.activeFile.FileId] = db.activeFile
+		}
+		db.activeFile = dataFile
+		if err := db.tailDataFile(dataFile); err != nil {
+			return err
+		}
+	}
+
+	// 关闭已经被写入方删除的数据文件
+	for fid, dataFile := range db.olderFiles {
+		if present[fid] {
+			continue
+		}
+		delete(db.olderFiles, fid)
+		delete(db.fileStats, fid)
+		db.releaseDataFile(dataFile)
+	}
+
+	// 关闭已经被回收的 blob 文件，打开新的 blob 文件
+	return db.refreshBlobFiles(blobFileIds)
+}
+
+// tailDataFile 从 WriteOff 开始读取数据文件中新追加的完整记录并更新内存索引（需要持有 db.mu）
+func (db *DB) tailDataFile(dataFile *data.DataFile) error {
+
+	if db.pendingTxnRecords == nil {
+		db.pendingTxnRecords = make(map[uint64][]*data.TransactionRecord)
+	}
+
+	offset := dataFile.WriteOff
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		entry := hintEntry{
+			key:       logRecord.Key,
+			typ:       logRecord.Type,
+			namespace: logRecord.Namespace,
+			pos: &data.LogRecordPos{
+				Fid:    dataFile.FileId,
+				Offset: offset,
+				Size:   uint32(size),
+				Expire: logRecord.Expire,
+			},
+		}
+		if seqNo := db.loadIndexEntry(entry, db.pendingTxnRecords); seqNo > db.seqNo {
+			db.seqNo = seqNo
+		}
+		offset += size
+	}
+	dataFile.WriteOff = offset
+	return nil
+}
+
+// hasMergedFiles 判断目录中是否出现了 id 小于活跃文件的新数据文件，即 merge 重写之后的文件（需要持有 db.mu）
+func (db *DB) hasMergedFiles(fileIds []uint32) bool {
+	if db.activeFile == nil {
+		return false
+	}
+	for _, fid := range fileIds {
+		if fid >= db.activeFile.FileId {
+			break
+		}
+		if _, ok := db.olderFiles[fid]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadIndex 重新打开所有数据文件并重建内存索引（需要持有 db.mu）
+// 旧的数据文件在不再被快照、迭代器引用之后关闭
+func (db *DB) reloadIndex() error {
+
+	olderFiles, activeFile := db.olderFiles, db.activeFile
+	db.olderFiles = make(map[uint32]*data.DataFile)
+	db.activeFile = nil
+	db.fileStats = make(map[uint32]*fileStat)
+	db.activeHints, db.activeHintsValid = nil, false
+	db.pendingTxnRecords = nil
+	db.index = index.NewIndex(db.options.IndexType, db.options.DirPath, db.options.SyncWrites, db.options.Comparator)
+	for _, ns := range db.namespaces {
+		ns.index = index.NewIndex(db.options.IndexType, "", false, db.options.Comparator)
+	}
+
+	historyFileId, err := db.mergedHistoryFileId()
+	if err != nil {
+		return err
+	}
+	db.historyFileId = historyFileId
+
+	if err := db.loadDataFiles(); err != nil {
+		return err
+	}
+	if err := db.loadIndexFromHintFile(); err != nil {
+		return err
+	}
+	if err := db.loadIndexFromDataFiles(); err != nil {
+		return err
+	}
+	if db.options.MMapAtStartup {
+		if err := db.resetIoType(); err != nil {
+			return err
+		}
+	}
+	if err := db.loadBloomFilter(); err != nil {
+		return err
+	}
+
+	if activeFile != nil {
+		olderFiles[activeFile.FileId] = activeFile
+	}
+	for _, dataFile := range olderFiles {
+		db.releaseDataFile(dataFile)
+	}
+	return nil
+}
+
+// releaseDataFile 关闭只读模式下不再使用的数据文件，被引用的文件在解除引用之后关闭（需要持有 db.mu）
+func (db *DB) releaseDataFile(dataFile *data.DataFile) {
+	if _, ok := db.retiredFiles[dataFile.FileId]; !ok && db.pinnedFiles[dataFile.FileId] > 0 {
+		db.retiredFiles[dataFile.FileId] = dataFile
+		return
+	}
+	db.removeDataFile(dataFile)
+}
+
+// refreshNamespaces 重新读取 namespace 文件，加入新创建的命名空间，标记已经删除的命名空间（需要持有 db.mu）
+func (db *DB) refreshNamespaces() error {
+
+	names, nextId, err := db.readNamespaces()
+	if err != nil {
+		return err
+	}
+
+	for id, ns := range db.namespaces {
+		if _, ok := names[id]; !ok {
+			delete(db.namespaces, id)
+			db.dropNamespaceIndex(ns)
+		}
+	}
+	for id, name := range names {
+		if _, ok := db.namespaces[id]; !ok {
+			db.namespaces[id] = db.newNamespace(name, id)
+		}
+	}
+	db.nextNamespaceId = nextId
+	return nil
+}
+
+// mergedHistoryFileId 读取 merge 完成标识中最近未参加 merge 的文件 id，没有发生过 merge 时返回 0
+func (db *DB) mergedHistoryFileId() (uint32, error) {
+	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); os.IsNotExist(err) {
+		return 0, nil
+	}
+	return db.getNonMergeFileId(db.options.DirPath)
+}
+
+// listDataFileIds 列出目录中所有数据文件的 id，按照从小到大排列
+func listDataFileIds(dirPath string) ([]uint32, error) {
+
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIds []uint32
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, data.DataFileNameSuffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.TrimSuffix(name, data.DataFileNameSuffix))
+		if err != nil {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		fileIds = append(fileIds, uint32(fid))
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	return fileIds, nil
+}
@@ -0,0 +1,174 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ReadOnly(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("before")))
+	}
+
+	// 与写入的进程同时打开，写入方仍然独占
+	readOpts := opts
+	readOpts.ReadOnly = true
+	reader, err := Open(readOpts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = reader.Close()
+	}()
+	_, err = Open(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	value, err := reader.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("before"), value)
+
+	// 只读模式不能修改数据
+	assert.Equal(t, ErrReadOnly, reader.Put([]byte("key"), []byte("value")))
+	assert.Equal(t, ErrReadOnly, reader.Delete(utils.GetTestKey(0)))
+	assert.Equal(t, ErrReadOnly, reader.Merge())
+	assert.Equal(t, ErrReadOnly, reader.CompactFiles([]uint32{0}))
+	_, err = reader.CreateNamespace("users")
+	assert.Equal(t, ErrReadOnly, err)
+
+	// Refresh 之后读到新追加的数据以及新的数据文件
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(64)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch"), []byte("value")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(50)))
+	assert.Nil(t, wb.Commit())
+
+	_, err = reader.Get(utils.GetTestKey(1999))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, reader.Refresh())
+	assert.Greater(t, reader.Stat().DataFileNum, uint(1))
+	assert.Equal(t, len(db.ListKeys()), len(reader.ListKeys()))
+	_, err = reader.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = reader.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	value, err = reader.Get([]byte("batch"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	// 写入方 compaction 以及 merge 之后仍然可以追上
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("after")))
+	}
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, reader.Refresh())
+	value, err = reader.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after"), value)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("merged")))
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Put([]byte("after-merge"), []byte("value")))
+	assert.Nil(t, reader.Refresh())
+	assert.Equal(t, len(db.ListKeys()), len(reader.ListKeys()))
+	value, err = reader.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("merged"), value)
+	value, err = reader.Get(utils.GetTestKey(1999))
+	assert.Nil(t, err)
+	expected, err := db.Get(utils.GetTestKey(1999))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, value)
+	_, err = reader.Get([]byte("after-merge"))
+	assert.Nil(t, err)
+
+	// 命名空间同样在 Refresh 时更新
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	assert.Nil(t, users.Put([]byte("alice"), []byte("value")))
+	assert.Nil(t, reader.Refresh())
+	readerUsers, err := reader.Namespace("users")
+	assert.Nil(t, err)
+	value, err = readerUsers.Get([]byte("alice"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Nil(t, db.DropNamespace("users"))
+	assert.Nil(t, reader.Refresh())
+	_, err = reader.Namespace("users")
+	assert.Equal(t, ErrNamespaceNotFound, err)
+
+	// 只读的进程不写入序列号文件
+	assert.Nil(t, reader.Close())
+	_, err = os.Stat(filepath.Join(dir, data.SeqNoFileName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_ReadOnlyOpen(t *testing.T) {
+
+	// 只读模式不创建数据目录
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-open")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	opts.DirPath = filepath.Join(dir, "missing")
+	opts.ReadOnly = true
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	opts.IndexType = BPTree
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+
+	// 非只读模式下 Refresh 不做任何事
+	opts = DefaultOptions
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Refresh())
+	assert.Nil(t, db.Close())
+
+	// 没有写入的进程时同样可以打开，不创建任何文件，多个只读的进程可以同时打开
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	opts.ReadOnly = true
+	reader, err := Open(opts)
+	assert.Nil(t, err)
+	another, err := Open(opts)
+	assert.Nil(t, err)
+	value, err := reader.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Nil(t, reader.Refresh())
+	assert.Equal(t, uint(1), reader.Stat().DataFileNum)
+	assert.Nil(t, another.Close())
+	assert.Nil(t, reader.Close())
+	after, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), len(after))
+}
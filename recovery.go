@@ -0,0 +1,104 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// decodedFile 一个数据文件解码的结果
+type decodedFile struct {
+	entries []hintEntry /* 文件中所有记录的索引信息，按照写入顺序排列 */
+	size    int64       /* 有效数据的长度 */
+	err     error
+}
+
+// decodeDataFiles 使用有限的 worker 并行解码数据文件，并按照传入的顺序依次交给 apply 处理
+// 已经解码但还没有处理的文件数量不超过 worker 数量，避免占用过多内存
+func (db *DB) decodeDataFiles(dataFiles []*data.DataFile, apply func(*data.DataFile, *decodedFile)) error {
+
+	workers := db.options.RecoveryConcurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	results := make([]chan *decodedFile, len(dataFiles))
+	for i := range results {
+		results[i] = make(chan *decodedFile, 1)
+	}
+
+	// 按顺序分发解码任务，apply 完成之后才释放名额
+	// 返回之前等待所有已经开始的解码结束，出错时调用方会关闭数据文件
+	var wg sync.WaitGroup
+	tokens := make(chan struct{}, workers)
+	stop := make(chan struct{})
+	defer wg.Wait()
+	defer close(stop)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, dataFile := range dataFiles {
+			select {
+			case tokens <- struct{}{}:
+			case <-stop:
+				return
+			}
+			wg.Add(1)
+			go func(i int, dataFile *data.DataFile) {
+				defer wg.Done()
+				results[i] <- db.decodeDataFile(dataFile, dataFile == db.activeFile)
+			}(i, dataFile)
+		}
+	}()
+
+	for i, dataFile := range dataFiles {
+		result := <-results[i]
+		if result.err != nil {
+			return result.err
+		}
+		apply(dataFile, result)
+		<-tokens
+	}
+	return nil
+}
+
+// decodeDataFile 读取一个数据文件中所有记录的索引信息，封存的文件优先使用对应的 hint
+func (db *DB) decodeDataFile(dataFile *data.DataFile, isActive bool) *decodedFile {
+
+	if !isActive {
+		if entries, ok := db.loadFileHint(dataFile); ok {
+			return &decodedFile{entries: entries}
+		}
+	}
+
+	result := &decodedFile{}
+	var offset int64 = 0
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			result.err = err
+			return result
+		}
+
+		result.entries = append(result.entries, hintEntry{
+			key:       logRecord.Key,
+			typ:       logRecord.Type,
+			namespace: logRecord.Namespace,
+			pos: &data.LogRecordPos{
+				Fid:    dataFile.FileId,
+				Offset: offset,
+				Size:   uint32(size),
+				Expire: logRecord.Expire,
+			},
+		})
+
+		// 递增 offset
+		offset += size
+	}
+	result.size = offset
+	return result
+}
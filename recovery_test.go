@@ -0,0 +1,111 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ParallelRecovery(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.RecoveryConcurrency = 1
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 600; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	for i := 0; i < 600; i += 3 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 跨越多个数据文件的事务，事务中后删除的 key 同样需要生效
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1200; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	for i := 1; i < 600; i += 3 {
+		assert.Nil(t, wb.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(64)))
+	}
+
+	dataFileNum := db.Stat().DataFileNum
+	assert.Greater(t, dataFileNum, uint(4))
+	keys := db.ListKeys()
+	seqNo := db.seqNo
+	assert.Nil(t, db.Close())
+
+	// 删除 hint 文件，强制从数据文件中解码
+	for fid := uint32(0); fid < uint32(dataFileNum); fid++ {
+		assert.Nil(t, removeFileHint(dir, fid))
+	}
+
+	// 不同并发度恢复的结果一致
+	var reclaimSize int64 = -1
+	for _, concurrency := range []int{1, 4, 0} {
+		opts.RecoveryConcurrency = concurrency
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, keys, db.ListKeys())
+		assert.Equal(t, seqNo, db.seqNo)
+		if reclaimSize >= 0 {
+			assert.Equal(t, reclaimSize, db.reclaimSize)
+		}
+		reclaimSize = db.reclaimSize
+		assert.Greater(t, db.Stat().RecoveryDuration, time.Duration(0))
+		assert.Nil(t, db.Close())
+	}
+
+	// 恢复之后可以继续在活跃文件中写入
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("after"), []byte("recovery")))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("after"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("recovery"), val)
+	assert.Equal(t, len(keys)+1, len(db.ListKeys()))
+}
+
+func TestDB_ParallelRecoveryCorrupted(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-corrupted")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.RecoveryConcurrency = 2
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 600; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 损坏第一个数据文件并删除其 hint，启动时应当返回错误
+	assert.Nil(t, removeFileHint(dir, 0))
+	corruptFile(t, data.GetDataFileName(dir, 0), 100)
+	db, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	assert.Nil(t, db)
+	assert.Nil(t, os.RemoveAll(dir))
+}
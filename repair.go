@@ -0,0 +1,536 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/flock"
+)
+
+// CorruptRecord 数据文件中 crc 校验失败或者无法解析的一段数据
+type CorruptRecord struct {
+	File   string /* 所在的文件名 */
+	Offset int64  /* 损坏数据的起始位置 */
+	Size   int64  /* 损坏数据的长度，即到下一条有效记录的距离 */
+}
+
+// TornTail 文件末尾不完整的写入，一般由写入时崩溃导致
+type TornTail struct {
+	File   string /* 所在的文件名 */
+	Offset int64  /* 有效数据的结束位置 */
+	Size   int64  /* 末尾无效数据的长度 */
+}
+
+// CheckReport 数据目录的检查结果
+type CheckReport struct {
+	CorruptRecords  []CorruptRecord /* 损坏的记录 */
+	TornTails       []TornTail      /* 末尾不完整的文件 */
+	OrphanMergeDirs []string        /* 没有完成的 merge 临时目录 */
+}
+
+// Healthy 数据目录是否没有任何问题
+func (r *CheckReport) Healthy() bool {
+	return len(r.CorruptRecords) == 0 && len(r.TornTails) == 0 && len(r.OrphanMergeDirs) == 0
+}
+
+// String 输出可读的检查结果
+func (r *CheckReport) String() string {
+	if r.Healthy() {
+		return "ok"
+	}
+	var sb strings.Builder
+	for _, c := range r.CorruptRecords {
+		sb.WriteString(fmt.Sprintf("corrupt record: %s offset %d size %d\n", c.File, c.Offset, c.Size))
+	}
+	for _, t := range r.TornTails {
+		sb.WriteString(fmt.Sprintf("torn tail: %s offset %d size %d\n", t.File, t.Offset, t.Size))
+	}
+	for _, d := range r.OrphanMergeDirs {
+		sb.WriteString(fmt.Sprintf("orphan merge dir: %s\n", d))
+	}
+	return sb.String()
+}
+
+// Check 离线检查数据目录，报告所有损坏的记录、末尾不完整的文件以及未完成的 merge 目录
+// 只校验 crc，不需要密钥；检查期间数据库不应当有写入
+func Check(dir string) (*CheckReport, error) {
+
+	report := &CheckReport{}
+
+	fileNames, err := checkedFileNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileName := range fileNames {
+		corrupts, torn, err := checkFile(dir, fileName)
+		if err != nil {
+			return nil, err
+		}
+		report.CorruptRecords = append(report.CorruptRecords, corrupts...)
+		if torn != nil {
+			report.TornTails = append(report.TornTails, *torn)
+		}
+	}
+
+	// 没有 merge 完成标识的 merge 目录
+	mergePath := mergeDirPath(dir)
+	if _, err := os.Stat(mergePath); err == nil {
+		if _, err := os.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); os.IsNotExist(err) {
+			report.OrphanMergeDirs = append(report.OrphanMergeDirs, mergePath)
+		}
+	}
+
+	return report, nil
+}
+
+// Repair 离线修复数据目录，返回修复之前的检查结果
+// 截断末尾不完整的写入，跳过（或者隔离到 RepairOptions.QuarantineDir）损坏的记录，删除损坏的文件级 hint，
+// 删除未完成的 merge 目录，并根据数据文件重建 hint 文件以及损坏的 namespace 文件
+// 注意被跳过的记录如果属于某个事务，该事务其余的数据仍然有效
+// 只读模式的进程不持有文件锁，修复无法感知，需要调用方保证修复期间没有只读模式的进程打开该目录
+func Repair(dir string, opts RepairOptions) (*CheckReport, error) {
+
+	// 修复期间不允许其他进程打开数据库
+	fileLock := flock.New(filepath.Join(dir, fileLockName))
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrDatabaseIsUsing
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	report, err := Check(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按文件整理需要处理的数据
+	damaged := make(map[string]bool)
+	for _, c := range report.CorruptRecords {
+		damaged[c.File] = true
+	}
+	for _, t := range report.TornTails {
+		damaged[t.File] = true
+	}
+
+	// 修复数据文件，数据文件对应的 hint 随之失效；损坏的 hint 直接删除，启动时会回退为扫描数据文件
+	for fileName := range damaged {
+		switch {
+		case strings.HasSuffix(fileName, data.DataFileNameSuffix):
+			if err := repairDataFile(dir, fileName, report, opts.QuarantineDir); err != nil {
+				return nil, err
+			}
+			hintName := strings.TrimSuffix(fileName, data.DataFileNameSuffix) + data.FileHintNameSuffix
+			if err := os.Remove(filepath.Join(dir, hintName)); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		case strings.HasSuffix(fileName, data.FileHintNameSuffix):
+			if err := os.Remove(filepath.Join(dir, fileName)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 序列号文件或 merge 完成文件损坏则直接删除，退化为从数据文件中加载
+	if damaged[data.SeqNoFileName] {
+		if err := os.Remove(filepath.Join(dir, data.SeqNoFileName)); err != nil {
+			return nil, err
+		}
+	}
+	if damaged[data.MergeFinishedFileName] {
+		if err := os.Remove(filepath.Join(dir, data.MergeFinishedFileName)); err != nil {
+			return nil, err
+		}
+	}
+
+	// namespace 文件损坏则根据完好的记录以及数据文件重建
+	encryptor := data.NewEncryptor(opts.Encryption)
+	if damaged[data.NamespaceFileName] {
+		if err := rebuildNamespaceFile(dir, report, encryptor); err != nil {
+			return nil, err
+		}
+	}
+
+	// 数据文件的内容发生了变化，需要重建 hint 文件
+	if len(damaged) > 0 {
+		if err := rebuildHintFile(dir, encryptor); err != nil {
+			return nil, err
+		}
+	}
+
+	// 删除未完成的 merge 目录
+	for _, mergePath := range report.OrphanMergeDirs {
+		if err := os.RemoveAll(mergePath); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// checkedFileNames 数据目录中需要检查的文件
+func checkedFileNames(dir string) ([]string, error) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var dataFileNames, otherFileNames []string
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, data.DataFileNameSuffix), strings.HasSuffix(name, data.FileHintNameSuffix):
+			dataFileNames = append(dataFileNames, name)
+		case name == data.HintFileName || name == data.MergeFinishedFileName || name == data.SeqNoFileName ||
+			name == data.NamespaceFileName:
+			otherFileNames = append(otherFileNames, name)
+		}
+	}
+	sort.Strings(dataFileNames)
+	return append(dataFileNames, otherFileNames...), nil
+}
+
+// checkFile 检查单个文件中的所有记录
+func checkFile(dir, fileName string) ([]CorruptRecord, *TornTail, error) {
+
+	ioManager, err := fio.NewIOManager(filepath.Join(dir, fileName), fio.StandardFIO)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataFile := &data.DataFile{IoManager: ioManager}
+	defer dataFile.Close()
+
+	fileSize, err := ioManager.Size()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var corrupts []CorruptRecord
+	var offset int64 = 0
+	for offset < fileSize {
+		size, err := dataFile.CheckLogRecord(offset)
+		if err == nil {
+			offset += size
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != data.ErrInvalidCRC && err != data.ErrIncompleteLogRecord {
+			return nil, nil, err
+		}
+
+		// 向后查找下一条有效的记录，找不到说明之后的数据都不完整
+		next := nextValidRecord(dataFile, offset+1, fileSize)
+		if next < 0 {
+			return corrupts, &TornTail{File: fileName, Offset: offset, Size: fileSize - offset}, nil
+		}
+		corrupts = append(corrupts, CorruptRecord{File: fileName, Offset: offset, Size: next - offset})
+		offset = next
+	}
+
+	return corrupts, nil, nil
+}
+
+// nextValidRecord 从 from 开始逐字节查找 crc 校验通过的记录，找不到返回 -1
+func nextValidRecord(dataFile *data.DataFile, from, fileSize int64) int64 {
+	for offset := from; offset < fileSize; offset++ {
+		if _, err := dataFile.CheckLogRecord(offset); err == nil {
+			return offset
+		}
+	}
+	return -1
+}
+
+// repairDataFile 去除数据文件中损坏的部分，需要隔离时将其写入 quarantineDir
+func repairDataFile(dir, fileName string, report *CheckReport, quarantineDir string) error {
+
+	// 需要去除的数据区间
+	type segment struct{ offset, size int64 }
+	var segments []segment
+	for _, c := range report.CorruptRecords {
+		if c.File == fileName {
+			segments = append(segments, segment{c.Offset, c.Size})
+		}
+	}
+	for _, t := range report.TornTails {
+		if t.File == fileName {
+			segments = append(segments, segment{t.Offset, t.Size})
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].offset < segments[j].offset
+	})
+
+	filePath := filepath.Join(dir, fileName)
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	// 隔离损坏的数据
+	if quarantineDir != "" {
+		if err := os.MkdirAll(quarantineDir, os.ModePerm); err != nil {
+			return err
+		}
+		for _, seg := range segments {
+			name := filepath.Join(quarantineDir, fileName+"."+strconv.FormatInt(seg.offset, 10))
+			if err := copyFileRange(name, src, seg.offset, seg.size); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 将有效的数据写入临时文件，再替换原文件
+	tmpPath := filePath + ".repair"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	var offset int64 = 0
+	for _, seg := range append(segments, segment{offset: stat.Size()}) {
+		if seg.offset > offset {
+			if _, err := io.Copy(dst, io.NewSectionReader(src, offset, seg.offset-offset)); err != nil {
+				_ = dst.Close()
+				return err
+			}
+		}
+		offset = seg.offset + seg.size
+	}
+	if err := dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+// copyFileRange 将 src 中 [offset, offset+size) 的数据写入新文件 name
+func copyFileRange(name string, src *os.File, offset, size int64) error {
+	dst, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, io.NewSectionReader(src, offset, size)); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// rebuildHintFile 根据已经 merge 的数据文件重建 hint 文件，没有 merge 过则删除 hint 文件
+func rebuildHintFile(dir string, encryptor *data.Encryptor) error {
+
+	hintPath := filepath.Join(dir, data.HintFileName)
+	if err := os.Remove(hintPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, data.MergeFinishedFileName)); os.IsNotExist(err) {
+		return nil
+	}
+
+	// 读取最近没有参与 merge 的文件 id
+	db := &DB{options: Options{DirPath: dir}, encryptor: encryptor}
+	nonMergeFileId, err := db.getNonMergeFileId(dir)
+	if err != nil {
+		return err
+	}
+
+	// 按顺序加载已经 merge 的数据文件中的索引，不同命名空间中的 key 分别记录
+	type hintRecord struct {
+		key       []byte
+		namespace uint32
+		pos       *data.LogRecordPos
+	}
+	positions := make(map[string]*hintRecord)
+	for fid := uint32(0); fid < nonMergeFileId; fid++ {
+		if _, err := os.Stat(data.GetDataFileName(dir, fid)); os.IsNotExist(err) {
+			continue
+		}
+		dataFile, err := data.OpenDataFile(dir, fid, fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		dataFile.Encryptor = encryptor
+
+		var offset int64 = 0
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				_ = dataFile.Close()
+				return err
+			}
+
+			realKey, _ := parseLogRecordKey(logRecord.Key)
+			key := namespaceKey(logRecord.Namespace, realKey)
+			switch logRecord.Type {
+			case data.LogRecordNormal:
+				positions[key] = &hintRecord{
+					key:       realKey,
+					namespace: logRecord.Namespace,
+					pos: &data.LogRecordPos{
+						Fid:    fid,
+						Offset: offset,
+						Size:   uint32(size),
+						Expire: logRecord.Expire,
+					},
+				}
+			case data.LogRecordDeleted:
+				delete(positions, key)
+			}
+			offset += size
+		}
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+	}
+
+	// 写入新的 hint 文件
+	hintFile, err := data.OpenHintFile(dir)
+	if err != nil {
+		return err
+	}
+	hintFile.Encryptor = encryptor
+	for _, record := range positions {
+		if err := hintFile.WritHintRecord(record.key, record.namespace, record.pos); err != nil {
+			_ = hintFile.Close()
+			return err
+		}
+	}
+	if err := hintFile.Sync(); err != nil {
+		_ = hintFile.Close()
+		return err
+	}
+	return hintFile.Close()
+}
+
+// rebuildNamespaceFile 根据 namespace 文件中完好的记录以及数据文件中出现的命名空间 id 重建 namespace 文件
+// 名称已经丢失的命名空间以 recovered-<id> 命名，其中可能包含已经删除但尚未 merge 的命名空间，可以再次删除
+func rebuildNamespaceFile(dir string, report *CheckReport, encryptor *data.Encryptor) error {
+
+	// 需要跳过的损坏数据，以及有效数据的结束位置
+	skips := make(map[int64]int64)
+	for _, c := range report.CorruptRecords {
+		if c.File == data.NamespaceFileName {
+			skips[c.Offset] = c.Size
+		}
+	}
+	var end int64 = -1
+	for _, t := range report.TornTails {
+		if t.File == data.NamespaceFileName {
+			end = t.Offset
+		}
+	}
+
+	// 读取完好的记录
+	names := make(map[uint32]string)
+	var nextId = defaultNamespaceId + 1
+	namespaceFile, err := data.OpenNamespaceFile(dir)
+	if err != nil {
+		return err
+	}
+	namespaceFile.Encryptor = encryptor
+	var offset int64 = 0
+	for end < 0 || offset < end {
+		if size, ok := skips[offset]; ok {
+			offset += size
+			continue
+		}
+		record, size, err := namespaceFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			_ = namespaceFile.Close()
+			return err
+		}
+		if len(record.Key) == 0 {
+			if id, err := strconv.ParseUint(string(record.Value), 10, 32); err == nil && uint32(id) > nextId {
+				nextId = uint32(id)
+			}
+		} else {
+			names[record.Namespace] = string(record.Key)
+		}
+		offset += size
+	}
+	if err := namespaceFile.Close(); err != nil {
+		return err
+	}
+
+	// 数据文件中出现的命名空间 id
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fid, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix), 10, 32)
+		if err != nil {
+			continue
+		}
+		dataFile, err := data.OpenDataFile(dir, uint32(fid), fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		dataFile.Encryptor = encryptor
+
+		var offset int64 = 0
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				_ = dataFile.Close()
+				return err
+			}
+			if id := logRecord.Namespace; id != defaultNamespaceId {
+				if _, ok := names[id]; !ok {
+					names[id] = "recovered-" + strconv.FormatUint(uint64(id), 10)
+				}
+				if id >= nextId {
+					nextId = id + 1
+				}
+			}
+			offset += size
+		}
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+	}
+
+	// 写入新的 namespace 文件
+	db := &DB{options: Options{DirPath: dir}, encryptor: encryptor, namespaces: make(map[uint32]*Namespace)}
+	db.nextNamespaceId = nextId
+	for id, name := range names {
+		if id >= db.nextNamespaceId {
+			db.nextNamespaceId = id + 1
+		}
+		db.namespaces[id] = &Namespace{db: db, name: name, id: id}
+	}
+	return db.saveNamespaces()
+}
@@ -0,0 +1,202 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// corruptFile 修改文件中 offset 处的一个字节
+func corruptFile(t *testing.T, name string, offset int64) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	defer f.Close()
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, offset)
+	assert.Nil(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, offset)
+	assert.Nil(t, err)
+}
+
+func TestCheckAndRepair(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair")
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll(mergeDirPath(dir))
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(64)))
+	}
+	assert.Nil(t, db.Close())
+
+	report, err := Check(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy())
+
+	// 破坏中间的一条记录，并在末尾追加不完整的写入
+	dataFileName := data.GetDataFileName(dir, 0)
+	stat, err := os.Stat(dataFileName)
+	assert.Nil(t, err)
+	corruptFile(t, dataFileName, stat.Size()/2)
+	f, err := os.OpenFile(dataFileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{1, 2, 3, 4, 0, 20, 20})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+
+	// 留下未完成的 merge 目录
+	assert.Nil(t, os.MkdirAll(mergeDirPath(dir), os.ModePerm))
+
+	report, err = Check(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.Healthy())
+	assert.Equal(t, 1, len(report.CorruptRecords))
+	assert.Equal(t, 1, len(report.TornTails))
+	assert.Equal(t, stat.Size(), report.TornTails[0].Offset)
+	assert.Equal(t, int64(7), report.TornTails[0].Size)
+	assert.Equal(t, []string{mergeDirPath(dir)}, report.OrphanMergeDirs)
+
+	// 修复并隔离损坏的数据
+	quarantineDir, _ := os.MkdirTemp("", "bitcask-go-quarantine")
+	defer os.RemoveAll(quarantineDir)
+	_, err = Repair(dir, RepairOptions{QuarantineDir: quarantineDir})
+	assert.Nil(t, err)
+	entries, err := os.ReadDir(quarantineDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	report, err = Check(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy(), report.String())
+
+	// 只丢失了损坏的一条记录
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 99, len(db.ListKeys()))
+	assert.Nil(t, db.Put([]byte("after-repair"), []byte("ok")))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("after-repair"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ok"), val)
+	assert.Nil(t, db.Close())
+}
+
+func TestRepair_RebuildHintFile(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair-hint")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(64)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// 重启使 merge 生效之后破坏 hint 文件
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	corruptFile(t, filepath.Join(dir, data.HintFileName), 10)
+
+	report, err := Check(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.CorruptRecords)+len(report.TornTails))
+
+	_, err = Repair(dir, RepairOptions{})
+	assert.Nil(t, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(db.ListKeys()))
+	for i := 50; i < 100; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestRepair_RebuildNamespaceFile(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair-namespace")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	assert.Nil(t, users.Put([]byte("user-key"), []byte("user")))
+	orders, err := db.CreateNamespace("orders")
+	assert.Nil(t, err)
+	assert.Nil(t, orders.Put([]byte("order-key"), []byte("order")))
+	assert.Nil(t, db.Close())
+
+	// 破坏 namespace 文件中最后一个命名空间的记录
+	namespaceFileName := filepath.Join(dir, data.NamespaceFileName)
+	stat, err := os.Stat(namespaceFileName)
+	assert.Nil(t, err)
+	corruptFile(t, namespaceFileName, stat.Size()-1)
+
+	report, err := Check(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.Healthy())
+
+	_, err = Repair(dir, RepairOptions{})
+	assert.Nil(t, err)
+	report, err = Check(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy(), report.String())
+
+	// 完好的命名空间保留名称，丢失名称的命名空间以 recovered-<id> 恢复，数据都不丢失
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	names := db.ListNamespaces()
+	assert.Equal(t, 2, len(names))
+	values := make(map[string]bool)
+	var recovered int
+	for _, name := range names {
+		if name != "users" && name != "orders" {
+			recovered++
+		}
+		ns, err := db.Namespace(name)
+		assert.Nil(t, err)
+		assert.Nil(t, ns.Fold(func(key []byte, value []byte) bool {
+			values[string(value)] = true
+			return true
+		}))
+	}
+	assert.Equal(t, 1, recovered)
+	assert.Equal(t, map[string]bool{"user": true, "order": true}, values)
+
+	// 新建的命名空间不会复用已有的 id
+	ns, err := db.CreateNamespace("new")
+	assert.Nil(t, err)
+	keys, err := ns.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(keys))
+	assert.Nil(t, db.Close())
+}
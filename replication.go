@@ -0,0 +1,656 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+主从复制协议，从节点连接之后发送 8 字节的起始序列号（大端），主节点随后只发送消息：
+
+	type(1) | seq(uvarint) | expire(varint) | keySize(uvarint) | valueSize(uvarint) | key | value
+
+起始序列号为上一次应用的事件序列号 + 1，为 0 或者无法从保留的数据文件中续传时，
+主节点先发送 BootstrapBegin、快照中所有的数据以及携带快照序列号的 BootstrapEnd，再发送实时的变更
+*/
+
+// 复制消息的类型
+const (
+	replicationPut            byte = iota + 1 /* 写入 */
+	replicationDelete                         /* 删除 */
+	replicationTxnPut                         /* 事务中的写入 */
+	replicationTxnDelete                      /* 事务中的删除 */
+	replicationTxnCommit                      /* 事务提交 */
+	replicationBootstrapBegin                 /* 开始全量同步 */
+	replicationBootstrapEnd                   /* 全量同步结束，seq 为快照对应的序列号 */
+)
+
+// replicationSeqFileName 从节点保存已应用序列号的文件
+const replicationSeqFileName = "replication-seq"
+
+// followerRetryInterval 从节点断开连接之后重连的间隔
+const followerRetryInterval = 200 * time.Millisecond
+
+// ReplicationServer 主节点的复制服务，将追加的数据实时发送给从节点
+type ReplicationServer struct {
+	db       *DB
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{} /* 已经连接的从节点 */
+	closed   bool
+	stopped  bool /* 是否已经停止复制（需要持有 db.mu） */
+	wg       sync.WaitGroup
+}
+
+// StartReplication 在 addr 上监听从节点的连接
+func (db *DB) StartReplication(addr string) (*ReplicationServer, error) {
+
+	// 命名空间中的数据不会复制到从节点
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.namespaces) > 0 {
+		return nil, ErrNamespaceNotReplicated
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	db.replicating++
+
+	s := &ReplicationServer{
+		db:       db,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr 复制服务监听的地址
+func (s *ReplicationServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close 停止复制服务并断开所有从节点
+func (s *ReplicationServer) Close() error {
+
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+
+	s.db.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		s.db.replicating--
+	}
+	s.db.mu.Unlock()
+	return err
+}
+
+// serve 接受从节点的连接
+func (s *ReplicationServer) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+// handle 向一个从节点发送数据，连接断开或者订阅因为缓冲区写满被终止时返回，由从节点重新连接
+func (s *ReplicationServer) handle(conn net.Conn) {
+
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return
+	}
+	fromSeq := binary.BigEndian.Uint64(buf)
+
+	// 从节点之后不再发送数据，读到 EOF 表示连接已经断开
+	connClosed := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(connClosed)
+	}()
+
+	// 在同一次加锁中判断能否续传，并创建快照或者订阅，保证两者之间没有遗漏的写入
+	db := s.db
+	db.mu.Lock()
+	var snapshot *Snapshot
+	var snapshotSeq uint64
+	if !db.canReplicateFrom(fromSeq) {
+		var err error
+		if snapshot, err = db.newSnapshot(); err != nil {
+			db.mu.Unlock()
+			return
+		}
+		if db.activeFile != nil {
+			snapshotSeq = eventSeq(db.activeFile.FileId, db.activeFile.WriteOff)
+		}
+		fromSeq = 0
+	}
+	events, cancel := db.watch(nil, fromSeq)
+	db.mu.Unlock()
+	defer cancel()
+
+	bw := bufio.NewWriter(conn)
+	if snapshot != nil {
+		err := s.sendSnapshot(bw, snapshot, snapshotSeq)
+		_ = snapshot.Release()
+		if err != nil {
+			return
+		}
+	}
+
+	for {
+		var event Event
+		var ok bool
+
+		// 没有待发送的事件时才将缓冲的数据发送出去
+		select {
+		case event, ok = <-events:
+		default:
+			if err := bw.Flush(); err != nil {
+				return
+			}
+			select {
+			case event, ok = <-events:
+			case <-connClosed:
+				return
+			}
+		}
+		if !ok {
+			_ = bw.Flush()
+			return
+		}
+
+		typ := replicationPut
+		switch {
+		case event.Type == EventTxnCommit:
+			typ = replicationTxnCommit
+		case event.Type == EventDelete && event.Txn:
+			typ = replicationTxnDelete
+		case event.Type == EventDelete:
+			typ = replicationDelete
+		case event.Txn:
+			typ = replicationTxnPut
+		}
+		if err := writeReplicationMessage(bw, typ, event.Seq, event.Expire, event.Key, event.Value); err != nil {
+			return
+		}
+	}
+}
+
+// canReplicateFrom 能否从 fromSeq 开始回放，merge 之前的文件中已经丢失了删除的记录（需要持有 db.mu）
+func (db *DB) canReplicateFrom(fromSeq uint64) bool {
+	if fromSeq == 0 {
+		return false
+	}
+	if uint32(fromSeq>>eventSeqOffsetBits) < db.historyFileId {
+		return false
+	}
+	var endSeq uint64
+	if db.activeFile != nil {
+		endSeq = eventSeq(db.activeFile.FileId, db.activeFile.WriteOff)
+	}
+	return fromSeq <= endSeq+1
+}
+
+// sendSnapshot 发送快照中所有未过期的数据
+func (s *ReplicationServer) sendSnapshot(bw *bufio.Writer, snapshot *Snapshot, snapshotSeq uint64) error {
+
+	if err := writeReplicationMessage(bw, replicationBootstrapBegin, 0, 0, nil, nil); err != nil {
+		return err
+	}
+
+	iterator := snapshot.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		logRecordPos := iterator.Value()
+		if isExpired(logRecordPos, snapshot.readTime) {
+			continue
+		}
+
+		s.db.mu.RLock()
+		value, err := s.db.getValueByPosition(logRecordPos)
+		s.db.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		err = writeReplicationMessage(bw, replicationPut, 0, logRecordPos.Expire, iterator.Key(), value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeReplicationMessage(bw, replicationBootstrapEnd, snapshotSeq, 0, nil, nil)
+}
+
+// writeReplicationMessage 编码并写入一条复制消息
+func writeReplicationMessage(w io.Writer, typ byte, seq uint64, expire int64, key, value []byte) error {
+
+	header := make([]byte, 1+4*binary.MaxVarintLen64)
+	header[0] = typ
+	index := 1
+	index += binary.PutUvarint(header[index:], seq)
+	index += binary.PutVarint(header[index:], expire)
+	index += binary.PutUvarint(header[index:], uint64(len(key)))
+	index += binary.PutUvarint(header[index:], uint64(len(value)))
+
+	if _, err := w.Write(header[:index]); err != nil {
+		return err
+	}
+	if _, err := w.Write(key); err != nil {
+		return err
+	}
+	_, err := w.Write(value)
+	return err
+}
+
+// replicationMessage 一条复制消息
+type replicationMessage struct {
+	typ    byte
+	seq    uint64
+	expire int64
+	key    []byte
+	value  []byte
+}
+
+// readReplicationMessage 读取一条复制消息
+func readReplicationMessage(br *bufio.Reader) (*replicationMessage, error) {
+
+	typ, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	msg := &replicationMessage{typ: typ}
+	if msg.seq, err = binary.ReadUvarint(br); err != nil {
+		return nil, err
+	}
+	if msg.expire, err = binary.ReadVarint(br); err != nil {
+		return nil, err
+	}
+	keySize, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	valueSize, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, keySize+valueSize)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, err
+	}
+	msg.key, msg.value = buf[:keySize], buf[keySize:]
+	return msg, nil
+}
+
+// Follower 复制的从节点，持续从主节点同步数据，本地的数据库只读
+type Follower struct {
+	db         *DB
+	leaderAddr string
+	appliedSeq uint64 /* 已经应用的最后一个事件的序列号 */
+	bootstraps uint64 /* 全量同步的次数 */
+
+	mu      sync.Mutex
+	conn    net.Conn
+	closeCh chan struct{}
+	doneCh  chan struct{}
+}
+
+// OpenFollower 打开从节点的数据库并开始从 leaderAddr 同步数据，断开连接之后自动重连并续传
+// 落后太多（主节点已经 merge 掉需要的数据）或者第一次启动时，从主节点的快照全量同步
+func OpenFollower(options Options, leaderAddr string) (*Follower, error) {
+
+	db, err := Open(options)
+	if err != nil {
+		return nil, err
+	}
+	db.readOnly = true
+
+	// 从节点中的命名空间不会与主节点同步
+	if len(db.namespaces) > 0 {
+		_ = db.Close()
+		return nil, ErrNamespaceNotReplicated
+	}
+
+	appliedSeq, err := readReplicationSeq(options.DirPath)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	f := &Follower{
+		db:         db,
+		leaderAddr: leaderAddr,
+		appliedSeq: appliedSeq,
+		closeCh:    make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	go f.run()
+	return f, nil
+}
+
+// DB 从节点的数据库，只能读取，写入返回 ErrReadOnly
+func (f *Follower) DB() *DB {
+	return f.db
+}
+
+// AppliedSeq 已经应用的最后一个事件的序列号
+func (f *Follower) AppliedSeq() uint64 {
+	return atomic.LoadUint64(&f.appliedSeq)
+}
+
+// Close 停止同步，保存同步的位置并关闭数据库
+func (f *Follower) Close() error {
+
+	f.mu.Lock()
+	select {
+	case <-f.closeCh:
+		f.mu.Unlock()
+		return nil
+	default:
+	}
+	close(f.closeCh)
+	if f.conn != nil {
+		_ = f.conn.Close()
+	}
+	f.mu.Unlock()
+	<-f.doneCh
+
+	// 数据持久化之后才保存同步的位置
+	if err := f.db.Sync(); err != nil {
+		return err
+	}
+	if err := writeReplicationSeq(f.db.options.DirPath, f.AppliedSeq()); err != nil {
+		return err
+	}
+	return f.db.Close()
+}
+
+// run 保持与主节点的连接
+func (f *Follower) run() {
+	defer close(f.doneCh)
+
+	for {
+		_ = f.follow()
+		select {
+		case <-f.closeCh:
+			return
+		case <-time.After(followerRetryInterval):
+		}
+	}
+}
+
+// follow 建立一次连接并应用收到的数据，直到连接断开
+func (f *Follower) follow() error {
+
+	conn, err := net.DialTimeout("tcp", f.leaderAddr, time.Second)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	select {
+	case <-f.closeCh:
+		f.mu.Unlock()
+		_ = conn.Close()
+		return nil
+	default:
+	}
+	f.conn = conn
+	f.mu.Unlock()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var fromSeq uint64
+	if appliedSeq := f.AppliedSeq(); appliedSeq > 0 {
+		fromSeq = appliedSeq + 1
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, fromSeq)
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(conn)
+	var txnRecords []*data.LogRecord
+	var staleKeys map[string]struct{}
+	for {
+		msg, err := readReplicationMessage(br)
+		if err != nil {
+			return err
+		}
+
+		switch msg.typ {
+		case replicationBootstrapBegin:
+			// 同步位置在全量同步完成之前无效
+			if err := removeReplicationSeq(f.db.options.DirPath); err != nil {
+				return err
+			}
+			atomic.StoreUint64(&f.appliedSeq, 0)
+			atomic.AddUint64(&f.bootstraps, 1)
+			staleKeys = f.db.replicaKeys()
+
+		case replicationBootstrapEnd:
+			// 删除主节点中已经不存在的 key
+			var records []*data.LogRecord
+			for key := range staleKeys {
+				records = append(records, &data.LogRecord{Key: []byte(key), Type: data.LogRecordDeleted})
+			}
+			staleKeys = nil
+			if err := f.db.applyReplicated(records, false); err != nil {
+				return err
+			}
+			if err := f.db.Sync(); err != nil {
+				return err
+			}
+			if err := writeReplicationSeq(f.db.options.DirPath, msg.seq); err != nil {
+				return err
+			}
+			atomic.StoreUint64(&f.appliedSeq, msg.seq)
+
+		case replicationPut, replicationDelete:
+			record := &data.LogRecord{Key: msg.key, Value: msg.value, Type: data.LogRecordNormal, Expire: msg.expire}
+			if msg.typ == replicationDelete {
+				record.Type = data.LogRecordDeleted
+			}
+			if staleKeys != nil {
+				delete(staleKeys, string(msg.key))
+			}
+			if err := f.db.applyReplicated([]*data.LogRecord{record}, false); err != nil {
+				return err
+			}
+			if msg.seq > 0 {
+				atomic.StoreUint64(&f.appliedSeq, msg.seq)
+			}
+
+		case replicationTxnPut, replicationTxnDelete:
+			record := &data.LogRecord{Key: msg.key, Value: msg.value, Type: data.LogRecordNormal, Expire: msg.expire}
+			if msg.typ == replicationTxnDelete {
+				record.Type = data.LogRecordDeleted
+			}
+			txnRecords = append(txnRecords, record)
+
+		case replicationTxnCommit:
+			if err := f.db.applyReplicated(txnRecords, true); err != nil {
+				return err
+			}
+			txnRecords = nil
+			atomic.StoreUint64(&f.appliedSeq, msg.seq)
+
+		default:
+			return ErrReplicationProtocol
+		}
+	}
+}
+
+// replicaKeys 从节点当前所有的 key，全量同步之后删除其中主节点已经不存在的 key
+func (db *DB) replicaKeys() map[string]struct{} {
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keys := make(map[string]struct{}, db.index.Size())
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys[string(iterator.Key())] = struct{}{}
+	}
+	return keys
+}
+
+// applyReplicated 应用主节点发送的数据，txn 为 true 时以事务的方式原子地写入
+func (db *DB) applyReplicated(records []*data.LogRecord, txn bool) error {
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.replicaWriting = true
+	defer func() {
+		db.replicaWriting = false
+	}()
+
+	if txn {
+		pendingWrites := make(map[string]*data.LogRecord, len(records))
+		for _, record := range records {
+			pendingWrites[string(record.Key)] = record
+		}
+		events, err := db.writeTxnRecords(pendingWrites, false)
+		if err != nil {
+			return err
+		}
+		return db.publishEvents(events, false)
+	}
+
+	var events []Event
+	for _, record := range records {
+
+		// 不存在的 key 不需要写入删除标识
+		if record.Type == data.LogRecordDeleted && db.index.Get(record.Key) == nil {
+			continue
+		}
+
+		logRecord := &data.LogRecord{
+			Key:    logRecordKeyWithSeq(record.Key, nonTransactionSeqNo),
+			Value:  record.Value,
+			Type:   record.Type,
+			Expire: record.Expire,
+		}
+		pos, err := db.writeLogRecord(logRecord, false)
+		if err != nil {
+			return err
+		}
+
+		var oldPos *data.LogRecordPos
+		if record.Type == data.LogRecordDeleted {
+			db.addTombstoneSize(pos)
+			oldPos, _ = db.index.Delete(record.Key)
+		} else {
+			oldPos = db.index.Put(record.Key, pos)
+		}
+		if oldPos != nil {
+			db.addReclaimSize(oldPos)
+		}
+		if len(db.watchers) > 0 {
+			events = append(events, recordEvent(record.Key, logRecord, pos))
+		}
+	}
+
+	// 整批写入更新索引之后再通知订阅者
+	return db.publishEvents(events, false)
+}
+
+// readReplicationSeq 读取从节点保存的同步位置，文件不存在表示需要全量同步
+func readReplicationSeq(dirPath string) (uint64, error) {
+	buf, err := os.ReadFile(filepath.Join(dirPath, replicationSeqFileName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) != 8 {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+// writeReplicationSeq 原子地保存从节点的同步位置
+func writeReplicationSeq(dirPath string, seq uint64) error {
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+
+	tmpName := filepath.Join(dirPath, replicationSeqFileName+".tmp")
+	f, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(dirPath, replicationSeqFileName))
+}
+
+// removeReplicationSeq 删除从节点保存的同步位置
+func removeReplicationSeq(dirPath string) error {
+	err := os.Remove(filepath.Join(dirPath, replicationSeqFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
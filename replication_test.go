@@ -0,0 +1,165 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForReplication 等待从节点应用主节点当前所有的数据
+func waitForReplication(t *testing.T, leader *DB, follower *Follower) {
+	leader.mu.RLock()
+	endSeq := eventSeq(leader.activeFile.FileId, leader.activeFile.WriteOff)
+	leader.mu.RUnlock()
+	assert.Eventually(t, func() bool {
+		return follower.AppliedSeq() >= endSeq
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplication_Stream(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-replication-leader")
+	opts.DirPath = dir
+	leader, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(leader); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestValue(32)))
+	}
+
+	server, err := leader.StartReplication("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer func() {
+		_ = server.Close()
+	}()
+
+	followerOpts := DefaultOptions
+	followerDir, _ := os.MkdirTemp("", "bitcask-go-replication-follower")
+	followerOpts.DirPath = followerDir
+	follower, err := OpenFollower(followerOpts, server.Addr())
+	assert.Nil(t, err)
+	defer func() {
+		_ = follower.Close()
+		_ = os.RemoveAll(followerDir)
+	}()
+
+	// 全量同步之后继续同步写入、删除、事务以及过期时间
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestValue(32)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, leader.Delete(utils.GetTestKey(i)))
+	}
+	wb := leader.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("txn-1"), []byte("value-1")))
+	assert.Nil(t, wb.Put([]byte("txn-2"), []byte("value-2")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(60)))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, leader.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour))
+
+	waitForReplication(t, leader, follower)
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&follower.bootstraps))
+
+	db := follower.DB()
+	assert.Equal(t, leader.Stat().KeyNum, db.Stat().KeyNum)
+	for i := 0; i < 200; i++ {
+		expected, expectedErr := leader.Get(utils.GetTestKey(i))
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, expectedErr, err)
+		assert.Equal(t, expected, value)
+	}
+	value, err := db.Get([]byte("txn-2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-2"), value)
+	ttl, err := db.TTL([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Hour)
+
+	// 从节点只读
+	assert.Equal(t, ErrReadOnly, db.Put([]byte("key"), []byte("value")))
+	assert.Equal(t, ErrReadOnly, db.Delete(utils.GetTestKey(100)))
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("key"), []byte("value")))
+	assert.Equal(t, ErrReadOnly, wb.Commit())
+}
+
+func TestReplication_Resume(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-replication-resume")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	leader, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(leader); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	server, err := leader.StartReplication("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer func() {
+		_ = server.Close()
+	}()
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	followerOpts := DefaultOptions
+	followerDir, _ := os.MkdirTemp("", "bitcask-go-replication-resume-follower")
+	followerOpts.DirPath = followerDir
+	defer func() {
+		_ = os.RemoveAll(followerDir)
+	}()
+	follower, err := OpenFollower(followerOpts, server.Addr())
+	assert.Nil(t, err)
+	waitForReplication(t, leader, follower)
+	assert.Nil(t, follower.Close())
+
+	// 从节点离线期间的写入在重新连接之后续传
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leader.Delete(utils.GetTestKey(i)))
+	}
+	follower, err = OpenFollower(followerOpts, server.Addr())
+	assert.Nil(t, err)
+	waitForReplication(t, leader, follower)
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&follower.bootstraps))
+	_, err = follower.DB().Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, follower.Close())
+
+	// 主节点 merge 之后删除的记录已经被清理，落后的从节点需要全量同步
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, leader.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, leader.Merge())
+	follower, err = OpenFollower(followerOpts, server.Addr())
+	assert.Nil(t, err)
+	waitForReplication(t, leader, follower)
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&follower.bootstraps))
+	for i := 0; i < 500; i++ {
+		_, err := follower.DB().Get(utils.GetTestKey(i))
+		if i < 200 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	assert.Equal(t, leader.Stat().KeyNum, follower.DB().Stat().KeyNum)
+	assert.Nil(t, follower.Close())
+}
@@ -0,0 +1,134 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/index"
+	"sync"
+	"time"
+)
+
+// Snapshot 数据库某一时刻的只读视图
+// 快照创建之后的写入、删除对其不可见，快照引用的数据文件在 Release 之前不会被删除
+type Snapshot struct {
+	db       *DB
+	mu       *sync.RWMutex
+	index    index.Reader /* 创建快照时刻的索引 */
+	seqNo    uint64       /* 创建快照时刻的事务序列号 */
+	readTime int64        /* 创建快照的时间，用于判断过期 */
+	fileIds  []uint32     /* 快照引用的数据文件 */
+	released bool
+}
+
+// Snapshot 创建当前数据库的快照，使用完毕后需要调用 Release
+// 注意 B+ 树索引的快照需要将整个索引拷贝到内存中，开销与数据量成正比
+func (db *DB) Snapshot() (*Snapshot, error) {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.newSnapshot()
+}
+
+// newSnapshot 创建当前数据库的快照，需要持有 db.mu
+func (db *DB) newSnapshot() (*Snapshot, error) {
+
+	indexSnapshot, err := db.index.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	// 固定当前所有的数据文件
+	fileIds := db.pinFiles()
+
+	return &Snapshot{
+		db:       db,
+		mu:       new(sync.RWMutex),
+		index:    indexSnapshot,
+		seqNo:    db.seqNo,
+		readTime: time.Now().UnixNano(),
+		fileIds:  fileIds,
+	}, nil
+}
+
+// SeqNo 快照对应的事务序列号
+func (s *Snapshot) SeqNo() uint64 {
+	return s.seqNo
+}
+
+// Get 读取快照时刻 key 对应的数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	// 判断 key 是否有效
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || isExpired(logRecordPos, s.readTime) {
+		return nil, ErrKeyNotFound
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return s.db.getValueByPosition(logRecordPos)
+}
+
+// NewIterator 创建遍历快照数据的迭代器，迭代器需要在 Release 之前关闭
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	return newIterator(s.db, s.index, opts, s.readTime)
+}
+
+// Fold 遍历快照中所有的数据，并且执行用户指定操作(func 返回 false 中止遍历)
+func (s *Snapshot) Fold(fn func(key []byte, value []byte) bool) error {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.released {
+		return ErrSnapshotReleased
+	}
+
+	iterator := s.index.Iterator(false)
+	defer iterator.Close()
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+
+		// 跳过快照时刻已经过期的 key
+		if isExpired(iterator.Value(), s.readTime) {
+			continue
+		}
+
+		value, err := s.db.getValueByPosition(iterator.Value())
+		if err != nil {
+			return err
+		}
+		if !fn(iterator.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Release 释放快照，解除对数据文件的引用
+func (s *Snapshot) Release() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.released {
+		return nil
+	}
+	s.released = true
+
+	s.db.unpinFiles(s.fileIds)
+
+	return s.index.Close()
+}
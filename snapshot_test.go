@@ -0,0 +1,122 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Snapshot(t *testing.T) {
+
+	for _, typ := range []IndexerType{BTree, ART, BPTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+		opts.DirPath = dir
+		opts.IndexType = typ
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		for i := 0; i < 100; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte("old"))
+			assert.Nil(t, err)
+		}
+
+		snap, err := db.Snapshot()
+
+		assert.Nil(t, err)
+
+		// 快照之后的修改对快照不可见
+		for i := 0; i < 50; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte("new"))
+			assert.Nil(t, err)
+		}
+		err = db.Put(utils.GetTestKey(1000), []byte("new"))
+		assert.Nil(t, err)
+
+		val, err := snap.Get(utils.GetTestKey(10))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("old"), val)
+		_, err = snap.Get(utils.GetTestKey(1000))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		// 迭代器与 Fold 看到相同的数据
+		iter := snap.NewIterator(DefaultIteratorOptions)
+		var iterNum int
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			val, err := iter.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("old"), val)
+			iterNum++
+		}
+		iter.Close()
+		assert.Equal(t, 100, iterNum)
+
+		var foldNum int
+		err = snap.Fold(func(key []byte, value []byte) bool {
+			assert.Equal(t, []byte("old"), value)
+			foldNum++
+			return true
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 100, foldNum)
+
+		// 数据库本身可以看到最新数据
+		val, err = db.Get(utils.GetTestKey(10))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new"), val)
+
+		// 释放之后不可再使用，文件引用被解除
+		assert.NotEmpty(t, db.pinnedFiles)
+		err = snap.Release()
+		assert.Nil(t, err)
+		assert.Empty(t, db.pinnedFiles)
+		_, err = snap.Get(utils.GetTestKey(10))
+		assert.Equal(t, ErrSnapshotReleased, err)
+
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestDB_Snapshot_Delete(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot-delete")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestValue(24))
+		assert.Nil(t, err)
+	}
+
+	snap, err := db.Snapshot()
+
+	assert.Nil(t, err)
+	defer snap.Release()
+
+	// 快照之后删除的 key 在快照中依然可见
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 0, len(db.ListKeys()))
+
+	for i := 0; i < 100; i++ {
+		val, err := snap.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestValue(24), val)
+	}
+}
@@ -0,0 +1,110 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"time"
+)
+
+// PersistentTTL 表示 key 没有设置过期时间（TTL 的返回值）
+const PersistentTTL time.Duration = -1
+
+// PutWithTTL 写入数据并设置存活时间，ttl <= 0 表示永不过期
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+
+	// 判断 key 是否有效
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+
+	// 构造 LogRecord 结构体
+	logRecord := &data.LogRecord{
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
+	}
+
+	// 追加写入到活跃的数据文件，追加成功则在持有锁的情况下将信息更新到内存索引中
+	_, err := db.appendLogRecordWithLock(logRecord, db.putIndex(key))
+	return err
+}
+
+// ExpireAt 为已存在的 key 设置过期的时间点
+func (db *DB) ExpireAt(key []byte, t time.Time) error {
+	return db.resetExpire(key, t.UnixNano())
+}
+
+// Persist 移除 key 的过期时间，使其永不过期
+func (db *DB) Persist(key []byte) error {
+	return db.resetExpire(key, 0)
+}
+
+// TTL 获取 key 剩余的存活时间，未设置过期时间则返回 PersistentTTL
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+
+	// 判断 key 是否有效
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || isExpired(logRecordPos, now) {
+		return 0, ErrKeyNotFound
+	}
+	if logRecordPos.Expire == 0 {
+		return PersistentTTL, nil
+	}
+
+	return time.Duration(logRecordPos.Expire - now), nil
+}
+
+// resetExpire 读出 key 当前的 value，携带新的过期时间重新追加写入
+func (db *DB) resetExpire(key []byte, expire int64) error {
+
+	// 判断 key 是否有效
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	// 检查与重写在同一次持有锁的过程中完成，开启组提交时同样如此
+	_, err := db.appendCheckedLogRecord(func() (*data.LogRecord, error) {
+
+		// key 不存在或已经过期
+		logRecordPos := db.index.Get(key)
+		if logRecordPos == nil || isExpired(logRecordPos, time.Now().UnixNano()) {
+			return nil, ErrKeyNotFound
+		}
+
+		// 过期时间没有变化则无需重写
+		if logRecordPos.Expire == expire {
+			return nil, nil
+		}
+
+		value, err := db.getValueByPosition(logRecordPos)
+		if err != nil {
+			return nil, err
+		}
+
+		return &data.LogRecord{
+			Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+			Value:  value,
+			Type:   data.LogRecordNormal,
+			Expire: expire,
+		}, nil
+	}, db.putIndex(key))
+	return err
+}
+
+// isExpired 判断索引对应的数据在 now 时刻是否已经过期
+func isExpired(pos *data.LogRecordPos, now int64) bool {
+	return pos.Expire > 0 && pos.Expire <= now
+}
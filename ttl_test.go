@@ -0,0 +1,169 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_PutWithTTL(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	/* 1.未过期可以正常读取 */
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.GetTestValue(24), time.Hour)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	/* 2.过期之后 Get / ListKeys / Fold / Iterator 均不可见 */
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.GetTestValue(24), time.Millisecond*10)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 20)
+
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 1, len(db.ListKeys()))
+	assert.Equal(t, uint(1), db.Stat().KeyNum)
+
+	var foldNum int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		foldNum++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, foldNum)
+
+	err = db.PutWithTTL(utils.GetTestKey(3), utils.GetTestValue(24), time.Millisecond*10)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 20)
+	iter := db.NewIterator(DefaultIteratorOptions)
+	var iterNum int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, utils.GetTestKey(1), iter.Key())
+		iterNum++
+	}
+	iter.Close()
+	assert.Equal(t, 1, iterNum)
+
+	/* 3.重启之后过期时间依然有效 */
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+
+	ttl, err := db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Hour)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_ExpireAt_Persist(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl-expire")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	/* 不存在的 key */
+	err = db.ExpireAt(utils.GetTestKey(1), time.Now().Add(time.Hour))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.TTL(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	/* 普通写入的 key 没有过期时间 */
+	err = db.Put(utils.GetTestKey(1), utils.GetTestValue(24))
+	assert.Nil(t, err)
+	ttl, err := db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, PersistentTTL, ttl)
+
+	/* 设置过期时间之后 value 不变 */
+	err = db.ExpireAt(utils.GetTestKey(1), time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	ttl, err = db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestValue(24), val)
+
+	/* Persist 移除过期时间 */
+	err = db.Persist(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	ttl, err = db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, PersistentTTL, ttl)
+
+	/* 过期时间设置为过去的时间点，key 立即失效 */
+	err = db.ExpireAt(utils.GetTestKey(1), time.Now().Add(-time.Second))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_Merge_Expired(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		if i%2 == 0 {
+			err = db.PutWithTTL(utils.GetTestKey(i), utils.GetTestValue(128), time.Millisecond*10)
+		} else {
+			err = db.PutWithTTL(utils.GetTestKey(i), utils.GetTestValue(128), time.Hour)
+		}
+		assert.Nil(t, err)
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 重启之后过期数据被回收，未过期数据依然保留过期时间
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db2); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	assert.Equal(t, 5000, db2.index.Size())
+	ttl, err := db2.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Hour)
+}
@@ -0,0 +1,399 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Txn 乐观读写事务
+// 读取基于事务开始时刻的快照，提交时检查读过的 key 以及遍历过的范围是否被其他提交修改过，写入复用 WriteBatch 的事务序列号机制
+// 冲突检测使用 key 的写入版本而不是数据的位置，merge、compaction 等重写数据不会导致冲突
+type Txn struct {
+	db            *DB
+	mu            *sync.Mutex
+	readOnly      bool                       /* 是否为只读事务 */
+	snapshot      *Snapshot                  /* 事务开始时刻的快照 */
+	startVersion  uint64                     /* 事务开始时刻的写入版本 */
+	pendingWrites map[string]*data.LogRecord /* 暂存用户写入数据 */
+	readKeys      map[string]struct{}        /* 事务读取过的 key，用于提交时的冲突检测 */
+	scanRanges    []IteratorOptions          /* 事务迭代器遍历过的范围，用于提交时检测范围内新写入的 key（幻读） */
+	done          bool                       /* 事务是否已经提交或者回滚 */
+}
+
+// Begin 开启一个事务，使用完毕后需要调用 Commit 或 Rollback
+func (db *DB) Begin(readOnly bool) (*Txn, error) {
+
+	// 如果索引为 b+ 树，与 WriteBatch 一样需要事务序列号文件
+	if !readOnly && db.options.IndexType == BPTree && !db.seqNoFileExists && !db.isInitial {
+		panic("cannot use transaction, seq no file not exists")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	snapshot, err := db.newSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	txn := &Txn{
+		db:            db,
+		mu:            new(sync.Mutex),
+		readOnly:      readOnly,
+		snapshot:      snapshot,
+		startVersion:  db.writeVersion,
+		pendingWrites: make(map[string]*data.LogRecord),
+		readKeys:      make(map[string]struct{}),
+	}
+
+	// 只读事务不需要冲突检测
+	if !readOnly {
+		db.activeTxns[txn] = struct{}{}
+	}
+	return txn, nil
+}
+
+// Get 读取数据，优先读取事务自身未提交的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+
+	// 判断 key 是否有效
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.done {
+		return nil, ErrTxnClosed
+	}
+
+	// 先查找事务内暂存的数据
+	if record, ok := txn.pendingWrites[string(key)]; ok {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	// 从快照中读取并记录读过的 key
+	txn.readKeys[string(key)] = struct{}{}
+	return txn.snapshot.Get(key)
+}
+
+// Put 在事务中写入数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+
+	// key 不能为空
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if err := txn.checkWritable(); err != nil {
+		return err
+	}
+
+	// 拷贝 key 和 value，调用方在提交之前复用缓冲区不影响写入的数据
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:   bytes.Clone(key),
+		Value: bytes.Clone(value),
+		Type:  data.LogRecordNormal,
+	}
+	return nil
+}
+
+// Delete 在事务中删除数据
+func (txn *Txn) Delete(key []byte) error {
+
+	// key 不能为空
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if err := txn.checkWritable(); err != nil {
+		return err
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:  bytes.Clone(key),
+		Type: data.LogRecordDeleted,
+	}
+	return nil
+}
+
+// Commit 提交事务，读过的 key 或者遍历过的范围内的 key 在事务开始之后被修改则返回 ErrTxnConflict
+func (txn *Txn) Commit() error {
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.done {
+		return ErrTxnClosed
+	}
+	txn.done = true
+	defer txn.snapshot.Release()
+
+	defer func() {
+		txn.db.mu.Lock()
+		txn.db.finishTxn(txn)
+		txn.db.mu.Unlock()
+	}()
+
+	if txn.readOnly || len(txn.pendingWrites) == 0 {
+		return nil
+	}
+
+	// 冲突检测：读过的 key 以及遍历过的范围内的 key 在事务开始之后不能被写入过，在写入的同一次加锁中进行
+	return txn.db.commitTxnRecords(txn.pendingWrites, false, func() error {
+		for key := range txn.readKeys {
+			if txn.db.keyVersions[key] > txn.startVersion {
+				return ErrTxnConflict
+			}
+		}
+		if len(txn.scanRanges) == 0 {
+			return nil
+		}
+		cmp := txn.db.comparator()
+		for key, version := range txn.db.keyVersions {
+			if version <= txn.startVersion {
+				continue
+			}
+			for _, scan := range txn.scanRanges {
+				if scan.inRange(cmp, []byte(key)) {
+					return ErrTxnConflict
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Rollback 放弃事务中所有的写入
+func (txn *Txn) Rollback() error {
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.done {
+		return nil
+	}
+	txn.done = true
+	txn.pendingWrites = nil
+
+	txn.db.mu.Lock()
+	txn.db.finishTxn(txn)
+	txn.db.mu.Unlock()
+
+	return txn.snapshot.Release()
+}
+
+// checkWritable 检查事务是否可以写入
+func (txn *Txn) checkWritable() error {
+	if txn.done {
+		return ErrTxnClosed
+	}
+	if txn.readOnly {
+		return ErrTxnReadOnly
+	}
+	return nil
+}
+
+// recordKeyVersion 递增写入版本，有进行中的读写事务时记录 key 的最新版本（需要持有 db.mu）
+func (db *DB) recordKeyVersion(key []byte) {
+	db.writeVersion++
+	if len(db.activeTxns) > 0 {
+		db.keyVersions[string(key)] = db.writeVersion
+	}
+}
+
+// finishTxn 结束读写事务，清理不会再被任何事务检查的 key 版本（需要持有 db.mu）
+func (db *DB) finishTxn(txn *Txn) {
+	if _, ok := db.activeTxns[txn]; !ok {
+		return
+	}
+	delete(db.activeTxns, txn)
+
+	if len(db.activeTxns) == 0 {
+		db.keyVersions = make(map[string]uint64)
+		return
+	}
+	var minVersion uint64 = math.MaxUint64
+	for active := range db.activeTxns {
+		if active.startVersion < minVersion {
+			minVersion = active.startVersion
+		}
+	}
+	for key, version := range db.keyVersions {
+		if version <= minVersion {
+			delete(db.keyVersions, key)
+		}
+	}
+}
+
+// TxnIterator 事务迭代器，合并遍历事务内未提交的写入和快照中已提交的数据
+type TxnIterator struct {
+	txn        *Txn
+	iter       *Iterator         /* 快照迭代器 */
+	pending    []*data.LogRecord /* 事务内暂存的数据，按遍历顺序排列 */
+	pendingIdx int               /* 当前遍历到的暂存数据下标 */
+	reverse    bool              /* 是否是反向遍历 */
+	cmp        Comparator        /* key 的比较规则 */
+	onPending  bool              /* 当前位置是否为暂存数据 */
+	limit      int               /* 最多遍历的 key 数量，0 表示不限制 */
+	count      int               /* 已经遍历过的 key 数量 */
+}
+
+// NewIterator 创建事务迭代器，迭代器需要在事务结束之前关闭
+// 遍历的整个范围（不考虑 Limit 以及是否遍历完）都会参与提交时的冲突检测，范围内出现新写入的 key 时提交返回 ErrTxnConflict
+func (txn *Txn) NewIterator(opts IteratorOptions) *TxnIterator {
+
+	// 取出满足前缀和范围条件的暂存数据并排序
+	var pending []*data.LogRecord
+	cmp := txn.db.comparator()
+	txn.mu.Lock()
+	// 拷贝范围参数，调用方之后复用缓冲区不影响冲突检测
+	txn.scanRanges = append(txn.scanRanges, IteratorOptions{
+		Prefix:     bytes.Clone(opts.Prefix),
+		LowerBound: bytes.Clone(opts.LowerBound),
+		UpperBound: bytes.Clone(opts.UpperBound),
+	})
+	for _, record := range txn.pendingWrites {
+		if opts.inRange(cmp, record.Key) {
+			pending = append(pending, record)
+		}
+	}
+	txn.mu.Unlock()
+	sort.Slice(pending, func(i, j int) bool {
+		if opts.Reverse {
+			return cmp.Compare(pending[i].Key, pending[j].Key) > 0
+		}
+		return cmp.Compare(pending[i].Key, pending[j].Key) < 0
+	})
+
+	// Limit 需要在合并之后计算
+	iterOpts := opts
+	iterOpts.Limit = 0
+
+	ti := &TxnIterator{
+		txn:     txn,
+		iter:    txn.snapshot.NewIterator(iterOpts),
+		pending: pending,
+		reverse: opts.Reverse,
+		cmp:     cmp,
+		limit:   opts.Limit,
+	}
+	ti.Rewind()
+	return ti
+}
+
+// Rewind 重新回到迭代器的起点
+func (ti *TxnIterator) Rewind() {
+	ti.count = 0
+	ti.iter.Rewind()
+	ti.pendingIdx = 0
+	ti.settle()
+}
+
+// Seek 根据传入 key 查找第一个大于（或小于）等于的目标 Key，根据这个 Key 开始遍历
+func (ti *TxnIterator) Seek(key []byte) {
+	ti.count = 0
+	ti.iter.Seek(key)
+	ti.pendingIdx = sort.Search(len(ti.pending), func(i int) bool {
+		return !ti.before(ti.pending[i].Key, key)
+	})
+	ti.settle()
+}
+
+// Next 跳转到下一个 Key
+func (ti *TxnIterator) Next() {
+	ti.count++
+	if ti.onPending {
+		// 暂存数据覆盖了相同的已提交数据，两者一起前进
+		if ti.iter.Valid() && bytes.Equal(ti.iter.Key(), ti.pending[ti.pendingIdx].Key) {
+			ti.iter.Next()
+		}
+		ti.pendingIdx++
+	} else {
+		ti.iter.Next()
+	}
+	ti.settle()
+}
+
+// Valid 是否有效，即是否已经遍历完所有的 key，用于退出遍历
+func (ti *TxnIterator) Valid() bool {
+	if ti.limit > 0 && ti.count >= ti.limit {
+		return false
+	}
+	return ti.onPending || ti.iter.Valid()
+}
+
+// Key 当前遍历位置的 Key 数据
+func (ti *TxnIterator) Key() []byte {
+	if ti.onPending {
+		return ti.pending[ti.pendingIdx].Key
+	}
+	return ti.iter.Key()
+}
+
+// Value 当前遍历位置的 Value 数据
+func (ti *TxnIterator) Value() ([]byte, error) {
+	if ti.onPending {
+		return ti.pending[ti.pendingIdx].Value, nil
+	}
+	return ti.iter.Value()
+}
+
+// Close 关闭迭代器并且释放相关资源
+func (ti *TxnIterator) Close() {
+	ti.iter.Close()
+}
+
+// settle 定位到下一个可见的位置，跳过事务内已经删除的 key
+func (ti *TxnIterator) settle() {
+	for {
+		ti.onPending = false
+		if ti.pendingIdx >= len(ti.pending) {
+			break
+		}
+
+		record := ti.pending[ti.pendingIdx]
+		if ti.iter.Valid() && ti.before(ti.iter.Key(), record.Key) {
+			break
+		}
+
+		// 事务内删除的 key，连同被覆盖的已提交数据一起跳过
+		if record.Type == data.LogRecordDeleted {
+			if ti.iter.Valid() && bytes.Equal(ti.iter.Key(), record.Key) {
+				ti.iter.Next()
+			}
+			ti.pendingIdx++
+			continue
+		}
+
+		ti.onPending = true
+		return
+	}
+
+	// 落在已提交的数据上，记录读过的 key
+	if ti.iter.Valid() {
+		ti.txn.mu.Lock()
+		ti.txn.readKeys[string(ti.iter.Key())] = struct{}{}
+		ti.txn.mu.Unlock()
+	}
+}
+
+// before 按照遍历顺序判断 a 是否排在 b 之前
+func (ti *TxnIterator) before(a, b []byte) bool {
+	if ti.reverse {
+		return ti.cmp.Compare(a, b) > 0
+	}
+	return ti.cmp.Compare(a, b) < 0
+}
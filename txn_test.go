@@ -0,0 +1,349 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Txn(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	err = db.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	/* 1.读取自身未提交的写入 */
+	txn, err := db.Begin(false)
+	assert.Nil(t, err)
+	err = txn.Put(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	val, err := txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	err = txn.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 提交之前数据库中不可见
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	/* 2.事务结束之后不能继续使用 */
+	err = txn.Put(utils.GetTestKey(3), []byte("v3"))
+	assert.Equal(t, ErrTxnClosed, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnClosed, err)
+
+	/* 3.只读事务不能写入 */
+	roTxn, err := db.Begin(true)
+	assert.Nil(t, err)
+	err = roTxn.Put(utils.GetTestKey(3), []byte("v3"))
+	assert.Equal(t, ErrTxnReadOnly, err)
+	val, err = roTxn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	err = roTxn.Commit()
+	assert.Nil(t, err)
+
+	/* 4.重启之后事务数据依然有效 */
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+}
+
+func TestDB_Txn_Conflict(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-conflict")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	err = db.Put([]byte("counter"), []byte("1"))
+	assert.Nil(t, err)
+
+	// 两个事务读取同一个 key
+	txn1, err := db.Begin(false)
+	assert.Nil(t, err)
+	txn2, err := db.Begin(false)
+	assert.Nil(t, err)
+	_, err = txn1.Get([]byte("counter"))
+	assert.Nil(t, err)
+	_, err = txn2.Get([]byte("counter"))
+	assert.Nil(t, err)
+
+	err = txn1.Put([]byte("counter"), []byte("2"))
+	assert.Nil(t, err)
+	err = txn2.Put([]byte("counter"), []byte("3"))
+	assert.Nil(t, err)
+
+	// 先提交的成功，后提交的冲突
+	err = txn1.Commit()
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	// 读取不存在的 key 之后该 key 被写入，同样冲突
+	txn3, err := db.Begin(false)
+	assert.Nil(t, err)
+	_, err = txn3.Get([]byte("absent"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = txn3.Put([]byte("other"), []byte("1"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("absent"), []byte("1"))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 只写不读的事务不会冲突
+	txn4, err := db.Begin(false)
+	assert.Nil(t, err)
+	err = txn4.Put([]byte("counter"), []byte("4"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("counter"), []byte("5"))
+	assert.Nil(t, err)
+	err = txn4.Commit()
+	assert.Nil(t, err)
+}
+
+func TestDB_Txn_Iterator(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-iterator")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for _, key := range []string{"a", "c", "e"} {
+		err := db.Put([]byte(key), []byte("committed"))
+		assert.Nil(t, err)
+	}
+
+	txn, err := db.Begin(false)
+
+	assert.Nil(t, err)
+	defer txn.Rollback()
+	assert.Nil(t, txn.Put([]byte("b"), []byte("pending")))
+	assert.Nil(t, txn.Put([]byte("c"), []byte("pending")))
+	assert.Nil(t, txn.Delete([]byte("e")))
+	assert.Nil(t, txn.Put([]byte("f"), []byte("pending")))
+
+	// 正向遍历
+	iter := txn.NewIterator(DefaultIteratorOptions)
+	var keys, values []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		keys = append(keys, string(iter.Key()))
+		values = append(values, string(val))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"a", "b", "c", "f"}, keys)
+	assert.Equal(t, []string{"committed", "pending", "pending", "pending"}, values)
+
+	// 反向遍历
+	iterOpts := DefaultIteratorOptions
+	iterOpts.Reverse = true
+	iter = txn.NewIterator(iterOpts)
+	keys = nil
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"f", "c", "b", "a"}, keys)
+
+	// Seek
+	iter.Seek([]byte("d"))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, []byte("c"), iter.Key())
+	iter.Close()
+}
+
+// 遍历过的范围内出现新写入的 key 时提交冲突，范围之外的写入不影响
+func TestDB_Txn_IteratorPhantom(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-phantom")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	err = db.Put([]byte("user:1"), []byte("1"))
+	assert.Nil(t, err)
+
+	scan := func(txn *Txn) int {
+		iterOpts := DefaultIteratorOptions
+		iterOpts.Prefix = []byte("user:")
+		iter := txn.NewIterator(iterOpts)
+		defer iter.Close()
+		var count int
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			count++
+		}
+		return count
+	}
+
+	// 1.遍历之后范围内新增了 key，提交冲突
+	txn1, err := db.Begin(false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, scan(txn1))
+	assert.Nil(t, txn1.Put([]byte("count"), []byte("1")))
+	err = db.Put([]byte("user:2"), []byte("2"))
+	assert.Nil(t, err)
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 2.范围之外的写入不冲突
+	txn2, err := db.Begin(false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, scan(txn2))
+	assert.Nil(t, txn2.Put([]byte("count"), []byte("2")))
+	err = db.Put([]byte("visitor:1"), []byte("1"))
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Nil(t, err)
+
+	// 3.范围内的 key 被删除同样冲突
+	txn3, err := db.Begin(false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, scan(txn3))
+	assert.Nil(t, txn3.Put([]byte("count"), []byte("2")))
+	err = db.Delete([]byte("user:2"))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+}
+
+// merge 和 compaction 重写数据只改变位置，不会导致事务冲突
+func TestDB_Txn_RewriteNoConflict(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-rewrite")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	txn, err := db.Begin(false)
+
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put(utils.GetTestKey(1), []byte("txn-value")))
+
+	// 重写之后 key 指向新的位置
+	oldPos := db.index.Get(utils.GetTestKey(1))
+	assert.Nil(t, db.Merge())
+	assert.NotEqual(t, *oldPos, *db.index.Get(utils.GetTestKey(1)))
+	assert.Nil(t, txn.Commit())
+
+	value, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("txn-value"), value)
+
+	// 其他写入仍然导致冲突
+	txn, err = db.Begin(false)
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put(utils.GetTestKey(3), []byte("txn-value")))
+	assert.Nil(t, db.Put(utils.GetTestKey(2), []byte("other-value")))
+	assert.Equal(t, ErrTxnConflict, txn.Commit())
+
+	// 没有进行中的事务时不保留 key 的版本
+	assert.Empty(t, db.keyVersions)
+}
+
+// 事务暂存的是 key 和 value 的拷贝，提交之前复用缓冲区不影响写入的数据
+func TestDB_Txn_CopyBuffers(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-copy")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	txn, err := db.Begin(false)
+
+	assert.Nil(t, err)
+	key, value := []byte("key-a"), []byte("value-a")
+	assert.Nil(t, txn.Put(key, value))
+	copy(key, "key-b")
+	copy(value, "value-b")
+	assert.Nil(t, txn.Commit())
+
+	got, err := db.Get([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-a"), got)
+	_, err = db.Get([]byte("key-b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,117 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"container/list"
+	"sync"
+)
+
+// valueCacheEntryOverhead 每个缓存项除 value 之外额外占用内存的估计值
+const valueCacheEntryOverhead = 64
+
+/*
+value 缓存以记录的位置（文件 id + 偏移）为 key，按照 LRU 淘汰：
+数据文件只追加写入，同一个位置的记录不会改变，key 被覆盖或者 merge 之后索引指向新的位置，旧的缓存项不再被访问，自然被淘汰；
+数据文件被删除时立即清理其中的缓存项
+*/
+
+// valueCacheKey 缓存项的 key，即记录在数据文件中的位置
+type valueCacheKey struct {
+	fid    uint32
+	offset int64
+}
+
+// valueCacheEntry 缓存项
+type valueCacheEntry struct {
+	key   valueCacheKey
+	value []byte
+}
+
+// valueCache 有大小上限的 LRU value 缓存，并发安全
+type valueCache struct {
+	mu       sync.Mutex
+	capacity int64 /* 缓存占用内存的上限 */
+	size     int64 /* 缓存当前占用的内存 */
+	lru      *list.List
+	items    map[valueCacheKey]*list.Element
+	hits     uint64 /* 命中次数 */
+	misses   uint64 /* 未命中次数 */
+}
+
+// newValueCache 初始化 value 缓存，capacity 为 0 时返回 nil 表示不使用缓存
+func newValueCache(capacity int64) *valueCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &valueCache{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[valueCacheKey]*list.Element),
+	}
+}
+
+// get 获取位置对应的 value，返回的是副本，调用方可以修改
+func (vc *valueCache) get(pos *data.LogRecordPos) ([]byte, bool) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	elem, ok := vc.items[valueCacheKey{fid: pos.Fid, offset: pos.Offset}]
+	if !ok {
+		vc.misses++
+		return nil, false
+	}
+	vc.hits++
+	vc.lru.MoveToFront(elem)
+	value := elem.Value.(*valueCacheEntry).value
+	return append(make([]byte, 0, len(value)), value...), true
+}
+
+// put 缓存位置对应的 value，超出上限时淘汰最久没有访问的缓存项
+func (vc *valueCache) put(pos *data.LogRecordPos, value []byte) {
+
+	cost := int64(len(value)) + valueCacheEntryOverhead
+	if cost > vc.capacity {
+		return
+	}
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	key := valueCacheKey{fid: pos.Fid, offset: pos.Offset}
+	if _, ok := vc.items[key]; ok {
+		return
+	}
+	entry := &valueCacheEntry{key: key, value: append(make([]byte, 0, len(value)), value...)}
+	vc.items[key] = vc.lru.PushFront(entry)
+	vc.size += cost
+
+	for vc.size > vc.capacity {
+		vc.removeElement(vc.lru.Back())
+	}
+}
+
+// removeFile 清理数据文件中所有的缓存项
+func (vc *valueCache) removeFile(fid uint32) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	for key, elem := range vc.items {
+		if key.fid == fid {
+			vc.removeElement(elem)
+		}
+	}
+}
+
+// removeElement 删除缓存项（需要持有 vc.mu）
+func (vc *valueCache) removeElement(elem *list.Element) {
+	entry := vc.lru.Remove(elem).(*valueCacheEntry)
+	delete(vc.items, entry.key)
+	vc.size -= int64(len(entry.value)) + valueCacheEntryOverhead
+}
+
+// stat 命中与未命中的次数
+func (vc *valueCache) stat() (uint64, uint64) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	return vc.hits, vc.misses
+}
@@ -0,0 +1,105 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueCache(t *testing.T) {
+
+	assert.Nil(t, newValueCache(0))
+
+	vc := newValueCache(3 * (100 + valueCacheEntryOverhead))
+	for i := 0; i < 4; i++ {
+		vc.put(&data.LogRecordPos{Fid: 1, Offset: int64(i)}, make([]byte, 100))
+	}
+
+	// 超出上限时淘汰最久没有访问的缓存项
+	_, ok := vc.get(&data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.False(t, ok)
+	value, ok := vc.get(&data.LogRecordPos{Fid: 1, Offset: 1})
+	assert.True(t, ok)
+	assert.Equal(t, 100, len(value))
+
+	// 返回的是副本，修改之后不影响缓存
+	value[0] = 1
+	value, _ = vc.get(&data.LogRecordPos{Fid: 1, Offset: 1})
+	assert.Equal(t, byte(0), value[0])
+
+	vc.put(&data.LogRecordPos{Fid: 2, Offset: 0}, make([]byte, 100))
+	_, ok = vc.get(&data.LogRecordPos{Fid: 1, Offset: 1})
+	assert.True(t, ok)
+	_, ok = vc.get(&data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.False(t, ok)
+
+	// 大于上限的 value 不缓存
+	vc.put(&data.LogRecordPos{Fid: 3, Offset: 0}, make([]byte, 1000))
+	_, ok = vc.get(&data.LogRecordPos{Fid: 3, Offset: 0})
+	assert.False(t, ok)
+
+	// 删除数据文件时清理其中的缓存项
+	vc.removeFile(1)
+	_, ok = vc.get(&data.LogRecordPos{Fid: 1, Offset: 1})
+	assert.False(t, ok)
+	_, ok = vc.get(&data.LogRecordPos{Fid: 2, Offset: 0})
+	assert.True(t, ok)
+	assert.Equal(t, int64(100+valueCacheEntryOverhead), vc.size)
+}
+
+func TestDB_ValueCache(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-value-cache")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+
+	// 第一次读取未命中，之后命中
+	value, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		cached, err := db.Get(utils.GetTestKey(0))
+		assert.Nil(t, err)
+		assert.Equal(t, value, cached)
+	}
+	stat := db.Stat()
+	assert.Equal(t, uint64(3), stat.ValueCacheHits)
+	assert.Equal(t, uint64(1), stat.ValueCacheMisses)
+
+	// 覆盖写入之后索引指向新的位置，读取到新的 value
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("new-value")))
+	value, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), value)
+
+	// merge 之后读取重写之后的位置，旧文件中的缓存项被清理
+	for i := 0; i < 1000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Merge())
+	for fid := range db.valueCache.items {
+		assert.NotNil(t, db.getDataFile(fid.fid))
+	}
+	value, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), value)
+	assert.Equal(t, uint64(1002), db.Stat().ValueCacheMisses)
+}
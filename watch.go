@@ -0,0 +1,401 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"io"
+	"sort"
+)
+
+// EventType 数据变更事件的类型
+type EventType = byte
+
+const (
+	EventPut       EventType = iota + 1 /* 写入 */
+	EventDelete                         /* 删除 */
+	EventTxnCommit                      /* 事务提交，紧跟在该事务的所有写入、删除事件之后 */
+)
+
+// 事件序列号中 offset 所占的位数
+const eventSeqOffsetBits = 40
+
+// Event 数据变更事件
+type Event struct {
+	Type   EventType /* 事件类型 */
+	Key    []byte    /* 变更的 key，事务提交事件为空 */
+	Value  []byte    /* 写入的 value */
+	Expire int64     /* 写入的过期时间（UnixNano），0 表示永不过期 */
+	Seq    uint64    /* 事件序列号，由记录所在的文件 id 和记录结束的 offset 编码而成，单调递增 */
+	Txn    bool      /* 是否属于事务，事务中的事件在收到 EventTxnCommit 之后才生效 */
+}
+
+// watcher 一个变更事件的订阅者
+type watcher struct {
+	prefix []byte        /* 订阅的 key 前缀 */
+	after  uint64        /* 订阅时已经写入的位置，之前的写入由回放发送，不再实时通知 */
+	buf    chan Event    /* 有界的事件缓冲区 */
+	done   chan struct{} /* 取消订阅 */
+}
+
+// Watch 订阅前缀为 prefix 的 key 的变更事件
+// fromSeq 为 0 表示只接收之后的变更，否则先从保留的数据文件中回放序列号不小于 fromSeq 的历史事件
+// 订阅者处理过慢导致缓冲区写满时，订阅会被终止并关闭 channel，可以使用最后收到的 Seq+1 重新订阅
+// merge 之后旧的数据文件被重写，从 merge 之前的序列号订阅会先收到重写之后全部数据的回放
+// 事件在写入更新内存索引之后发出，开启 SyncWrites 时只会收到已经持久化的写入；compaction 和 blob 回收重写数据不产生新的事件
+func (db *DB) Watch(prefix []byte, fromSeq uint64) (<-chan Event, func()) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.watch(prefix, fromSeq)
+}
+
+// watch 注册订阅者，需要持有 db.mu，调用方可以在同一次加锁中获取与订阅起点一致的状态
+func (db *DB) watch(prefix []byte, fromSeq uint64) (<-chan Event, func()) {
+
+	bufSize := db.options.WatchBufferSize
+	if bufSize <= 0 {
+		bufSize = DefaultOptions.WatchBufferSize
+	}
+	w := &watcher{
+		prefix: prefix,
+		buf:    make(chan Event, bufSize),
+		done:   make(chan struct{}),
+	}
+
+	// 组提交持久化期间已经写入的数据在持久化之后才会通知，这些写入已经在订阅起点之前
+	if db.activeFile != nil {
+		w.after = eventSeq(db.activeFile.FileId, db.activeFile.WriteOff)
+	}
+
+	// 注册订阅者，同时记录回放的终点以及需要回放的数据文件
+	db.watcherId++
+	id := db.watcherId
+	db.watchers[id] = w
+
+	endSeq := w.after
+	var replayFids []uint32
+	if fromSeq > 0 && db.activeFile != nil {
+		replayFids = db.pinFiles()
+		sort.Slice(replayFids, func(i, j int) bool {
+			return replayFids[i] < replayFids[j]
+		})
+	}
+
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+
+		// 先回放历史事件
+		if len(replayFids) > 0 {
+			err := db.replayEvents(w, replayFids, fromSeq, endSeq, out)
+			db.unpinFiles(replayFids)
+			if err != nil {
+				return
+			}
+		}
+
+		// 再转发实时事件
+		for {
+			select {
+			case event, ok := <-w.buf:
+				if !ok {
+					return
+				}
+				select {
+				case out <- event:
+				case <-w.done:
+					return
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}()
+
+	var cancelled bool
+	cancel := func() {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if cancelled {
+			return
+		}
+		cancelled = true
+		if _, ok := db.watchers[id]; ok {
+			delete(db.watchers, id)
+			close(w.buf)
+		}
+		close(w.done)
+	}
+
+	return out, cancel
+}
+
+// notifyWatchers 将事件发送给所有匹配的订阅者（需要持有 db.mu）
+// 一组事件（例如一个事务）对同一个订阅者要么全部送达，要么终止该订阅
+func (db *DB) notifyWatchers(events []Event) {
+
+	// 组提交的 leader 释放锁持久化期间，之后的写入产生的事件需要排在该组提交的事件之后
+	if db.syncing {
+		db.heldEvents = append(db.heldEvents, events)
+		return
+	}
+
+	for id, w := range db.watchers {
+		var matched []Event
+		for _, event := range events {
+			if event.Type == EventTxnCommit {
+				// 事务中有匹配的数据才通知事务提交
+				if len(matched) > 0 {
+					matched = append(matched, event)
+				}
+				continue
+			}
+			if event.Seq > w.after && bytes.HasPrefix(event.Key, w.prefix) {
+				matched = append(matched, event)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		// 缓冲区不足则终止订阅，由订阅者自行从最后的序列号重新订阅
+		if cap(w.buf)-len(w.buf) < len(matched) {
+			delete(db.watchers, id)
+			close(w.buf)
+			continue
+		}
+		for _, event := range matched {
+			w.buf <- event
+		}
+	}
+}
+
+// notifyWrite 非事务的写入更新内存索引之后通知订阅者（需要持有 db.mu）
+// 开启 SyncWrites 时调用方已经持久化了该写入
+func (db *DB) notifyWrite(logRecord *data.LogRecord, pos *data.LogRecordPos) {
+	if events := db.writeEvents(logRecord, pos); len(events) > 0 {
+		db.notifyWatchers(events)
+	}
+}
+
+// writeEvents 非事务的写入产生的事件，没有订阅者或者命名空间中的写入返回 nil（需要持有 db.mu）
+func (db *DB) writeEvents(logRecord *data.LogRecord, pos *data.LogRecordPos) []Event {
+	if len(db.watchers) == 0 || logRecord.Namespace != defaultNamespaceId {
+		return nil
+	}
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+	if seqNo != nonTransactionSeqNo {
+		return nil
+	}
+	return []Event{recordEvent(realKey, logRecord, pos)}
+}
+
+// publishEvents 一组写入更新内存索引之后通知订阅者（需要持有 db.mu）
+// 开启 SyncWrites 时订阅者只会收到已经持久化的写入，synced 为 false 表示这组写入还没有持久化
+func (db *DB) publishEvents(events []Event, synced bool) error {
+	if len(events) == 0 || len(db.watchers) == 0 {
+		return nil
+	}
+	if db.options.SyncWrites && !synced {
+		if err := db.syncActiveFiles(); err != nil {
+			return err
+		}
+		db.bytesWrite = 0
+	}
+	db.notifyWatchers(events)
+	return nil
+}
+
+// replayEvents 从数据文件中回放 [fromSeq, endSeq) 范围内的事件
+// compaction 和 blob 回收重写的记录只在原始记录没有被回放时发送，避免同一个写入产生重复的事件
+func (db *DB) replayEvents(w *watcher, fids []uint32, fromSeq, endSeq uint64, out chan<- Event) error {
+
+	send := func(events []Event) error {
+		for _, event := range events {
+			if event.Type != EventTxnCommit && !bytes.HasPrefix(event.Key, w.prefix) {
+				continue
+			}
+			select {
+			case out <- event:
+			case <-w.done:
+				return ErrWatchCancelled
+			}
+		}
+		return nil
+	}
+
+	// 暂存事务数据，直到读到事务完成标识
+	transactionEvents := make(map[uint64][]Event)
+
+	// 参与回放的文件，以及 value 已经被回收而没有发送的原始记录
+	replaying := make(map[uint32]bool, len(fids))
+	for _, fid := range fids {
+		replaying[fid] = true
+	}
+	skipped := make(map[uint64]bool)
+
+	for _, fid := range fids {
+		// 整个文件都在回放范围之前则跳过
+		if eventSeq(fid+1, 0) <= fromSeq {
+			continue
+		}
+
+		db.mu.RLock()
+		dataFile := db.getDataFile(fid)
+		db.mu.RUnlock()
+		if dataFile == nil {
+			return ErrDataFileNoFound
+		}
+
+		var offset int64 = 0
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			offset += size
+
+			seq := eventSeq(fid, offset)
+			if seq > endSeq {
+				return nil
+			}
+
+			// 命名空间中的写入不产生事件
+			if logRecord.Namespace != defaultNamespaceId {
+				continue
+			}
+
+			// 写入最初的位置，重写的记录使用原始记录的位置
+			origin := logRecord.Origin
+			if origin == 0 {
+				origin = seq
+			}
+
+			// value 存储在 blob 文件中，已经被回收的 value 如果仍然有效，会由之后重写的记录发送
+			if logRecord.Blob {
+				db.mu.RLock()
+				logRecord.Value, err = db.readBlobValue(logRecord.Value)
+				db.mu.RUnlock()
+				if err == ErrDataFileNoFound {
+					skipped[origin] = true
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			// 原始记录在回放范围之前，或者已经回放过，则跳过重写的记录
+			if logRecord.Origin != 0 {
+				originFid := uint32(logRecord.Origin >> eventSeqOffsetBits)
+				if logRecord.Origin < fromSeq || (replaying[originFid] && !skipped[logRecord.Origin]) {
+					continue
+				}
+			}
+
+			realKey, seqNo := parseLogRecordKey(logRecord.Key)
+			event := Event{Key: realKey, Value: logRecord.Value, Expire: logRecord.Expire, Seq: seq, Type: EventPut}
+			if logRecord.Type == data.LogRecordDeleted {
+				event.Type = EventDelete
+				event.Value = nil
+				event.Expire = 0
+			}
+
+			// 非事务数据直接发送
+			if seqNo == nonTransactionSeqNo {
+				if seq >= fromSeq {
+					if err := send([]Event{event}); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			// 事务完成之后发送整个事务
+			if logRecord.Type == data.LogRecordTxnFinished {
+				events := transactionEvents[seqNo]
+				delete(transactionEvents, seqNo)
+				if seq < fromSeq {
+					continue
+				}
+				var matched []Event
+				for _, e := range events {
+					if bytes.HasPrefix(e.Key, w.prefix) {
+						matched = append(matched, e)
+					}
+				}
+				if len(matched) > 0 {
+					matched = append(matched, Event{Type: EventTxnCommit, Seq: seq})
+				}
+				if err := send(matched); err != nil {
+					return err
+				}
+				continue
+			}
+			event.Txn = true
+			transactionEvents[seqNo] = append(transactionEvents[seqNo], event)
+		}
+	}
+
+	return nil
+}
+
+// pinFiles 引用当前所有的数据文件，返回被引用的文件 id（需要持有 db.mu）
+func (db *DB) pinFiles() []uint32 {
+	var fids []uint32
+	if db.activeFile != nil {
+		fids = append(fids, db.activeFile.FileId)
+	}
+	for fid := range db.olderFiles {
+		fids = append(fids, fid)
+	}
+	for _, fid := range fids {
+		db.pinnedFiles[fid]++
+	}
+	return fids
+}
+
+// unpinFiles 解除对数据文件的引用，已经被 merge 替换的文件在没有引用之后删除
+func (db *DB) unpinFiles(fids []uint32) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, fid := range fids {
+		if db.pinnedFiles[fid]--; db.pinnedFiles[fid] <= 0 {
+			delete(db.pinnedFiles, fid)
+			if dataFile, ok := db.retiredFiles[fid]; ok {
+				delete(db.retiredFiles, fid)
+				db.removeDataFile(dataFile)
+			}
+		}
+	}
+
+	// 已经回收的 blob 文件在没有任何引用之后删除
+	if len(db.pinnedFiles) == 0 {
+		db.removeRetiredBlobs()
+	}
+}
+
+// eventSeq 根据记录结束的位置计算事件序列号
+func eventSeq(fid uint32, endOffset int64) uint64 {
+	return uint64(fid)<<eventSeqOffsetBits | uint64(endOffset)
+}
+
+// recordEvent 根据写入的记录及其位置构造事件
+// key 和 value 可能引用调用方的缓冲区，事件中保存的是副本
+func recordEvent(key []byte, record *data.LogRecord, pos *data.LogRecordPos) Event {
+	event := Event{
+		Type:   EventPut,
+		Key:    bytes.Clone(key),
+		Value:  bytes.Clone(record.Value),
+		Expire: record.Expire,
+		Seq:    eventSeq(pos.Fid, pos.Offset+int64(pos.Size)),
+	}
+	if record.Type == data.LogRecordDeleted {
+		event.Type = EventDelete
+		event.Value = nil
+		event.Expire = 0
+	}
+	return event
+}
@@ -0,0 +1,281 @@
+package bitcaskkv
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receiveEvents 从 channel 中读取 n 个事件
+func receiveEvents(t *testing.T, ch <-chan Event, n int) []Event {
+	var events []Event
+	for i := 0; i < n; i++ {
+		select {
+		case event, ok := <-ch:
+			if !assert.True(t, ok) {
+				return events
+			}
+			events = append(events, event)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for event %d", i)
+		}
+	}
+	return events
+}
+
+func TestDB_Watch(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	ch, cancel := db.Watch([]byte("user:"), 0)
+
+	assert.Nil(t, db.Put([]byte("user:1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("other"), []byte("b")))
+	assert.Nil(t, db.Delete([]byte("user:1")))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:2"), []byte("c")))
+	assert.Nil(t, wb.Put([]byte("other"), []byte("d")))
+	assert.Nil(t, wb.Commit())
+
+	events := receiveEvents(t, ch, 4)
+	assert.Equal(t, EventPut, events[0].Type)
+	assert.Equal(t, []byte("user:1"), events[0].Key)
+	assert.Equal(t, []byte("a"), events[0].Value)
+	assert.Equal(t, EventDelete, events[1].Type)
+	assert.Equal(t, []byte("user:1"), events[1].Key)
+	assert.Equal(t, EventPut, events[2].Type)
+	assert.Equal(t, []byte("user:2"), events[2].Key)
+	assert.Equal(t, EventTxnCommit, events[3].Type)
+	for i := 1; i < len(events); i++ {
+		assert.Greater(t, events[i].Seq, events[i-1].Seq)
+	}
+
+	// 取消之后 channel 关闭
+	cancel()
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	// 从历史序列号回放，结果与实时事件一致
+	ch, cancel = db.Watch([]byte("user:"), events[1].Seq)
+	replayed := receiveEvents(t, ch, 3)
+	assert.Equal(t, events[1:], replayed)
+
+	// 回放结束之后继续接收实时事件
+	assert.Nil(t, db.Put([]byte("user:3"), []byte("e")))
+	live := receiveEvents(t, ch, 1)
+	assert.Equal(t, []byte("user:3"), live[0].Key)
+	assert.Greater(t, live[0].Seq, events[3].Seq)
+	cancel()
+}
+
+func TestDB_Watch_SlowConsumer(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-slow")
+	opts.DirPath = dir
+	opts.WatchBufferSize = 4
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	ch, cancel := db.Watch(nil, 0)
+	defer cancel()
+
+	// 不消费事件，缓冲区写满之后订阅被终止，写入不受影响
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte("v")))
+	}
+
+	var received int
+	for range ch {
+		received++
+	}
+	assert.LessOrEqual(t, received, 5)
+	assert.Equal(t, 100, len(db.ListKeys()))
+}
+
+// 写入之后修改调用方的缓冲区，不影响已经发出的事件
+func TestDB_Watch_CopiesBuffers(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-copy")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	ch, cancel := db.Watch(nil, 0)
+	defer cancel()
+
+	key, value := []byte("key"), []byte("value")
+	assert.Nil(t, db.Put(key, value))
+	copy(key, "xxx")
+	copy(value, "xxxxx")
+
+	events := receiveEvents(t, ch, 1)
+	assert.Equal(t, []byte("key"), events[0].Key)
+	assert.Equal(t, []byte("value"), events[0].Value)
+}
+
+// 组提交时收到事件之后一定可以读到对应的写入
+func TestDB_Watch_GroupCommit(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-group")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.GroupCommit = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	ch, cancel := db.Watch(nil, 0)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i*10+j), []byte("v")))
+			}
+		}(i)
+	}
+
+	for _, event := range receiveEvents(t, ch, 80) {
+		val, err := db.Get(event.Key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), val)
+	}
+	wg.Wait()
+}
+
+// compaction 重写的记录不会被重复回放
+func TestDB_Watch_ReplayAfterCompact(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-compact")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.WatchBufferSize = 2048
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	ch, cancel := db.Watch(nil, 0)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(128)))
+	}
+	for i := 0; i < 300; i++ {
+		if i%10 != 0 {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new")))
+		}
+	}
+	events := receiveEvents(t, ch, 1270)
+	cancel()
+
+	// 第一个文件中有效的数据被重写到活跃文件
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, db.Put([]byte("marker"), []byte("v")))
+
+	// 从最后收到的事件之后重新订阅，只会收到之后的写入
+	ch, cancel = db.Watch(nil, events[len(events)-1].Seq+1)
+	defer cancel()
+	replayed := receiveEvents(t, ch, 1)
+	assert.Equal(t, []byte("marker"), replayed[0].Key)
+}
+
+// blob 回收之后全量回放，每个 key 只会收到带有 value 的写入
+func TestDB_Watch_ReplayAfterGCBlobFiles(t *testing.T) {
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-blob")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 256
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		/* 销毁创建的临时 DB 以及临时文件 */
+		if err := destroyDB(db); err != nil {
+			assert.Nil(t, err)
+		}
+	}()
+
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(1024)))
+	}
+	for i := 0; i < 300; i++ {
+		if i%10 != 0 {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestValue(1024)))
+		}
+	}
+	assert.Nil(t, db.GCBlobFiles())
+	assert.Nil(t, db.Put([]byte("marker"), []byte("v")))
+
+	ch, cancel := db.Watch(nil, 1)
+	defer cancel()
+
+	puts := make(map[string]int)
+	last := make(map[string][]byte)
+	for {
+		event := receiveEvents(t, ch, 1)[0]
+		if string(event.Key) == "marker" {
+			break
+		}
+		assert.NotEmpty(t, event.Value)
+		puts[string(event.Key)]++
+		last[string(event.Key)] = event.Value
+	}
+
+	assert.Equal(t, 300, len(last))
+	for i := 0; i < 300; i++ {
+		key := utils.GetTestKey(i)
+		if i%10 == 0 {
+			assert.Equal(t, 1, puts[string(key)])
+		}
+		val, err := db.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, val, last[string(key)])
+	}
+}